@@ -1,17 +1,26 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"github.com/rubyist/gohat/pkg/heapfile"
+	"github.com/rubyist/gohat/pkg/heapquery"
 	"github.com/spf13/cobra"
 	"os"
+	"regexp"
 	"sort"
-	"strconv"
+	"strings"
+	"time"
 )
 
 func main() {
+	newGohatCommand().Execute()
+}
+
+// Builds the command tree. Command flags are bound to variables local to each build, and the
+// global --output flag's variable is reset to its default when the flag is registered, so the
+// script mode can build a fresh tree for every command it runs.
+func newGohatCommand() *cobra.Command {
 	var gohatCmd = &cobra.Command{
 		Use:   "gohat",
 		Short: "gohat is go heap dump analysis tool",
@@ -21,23 +30,94 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 		},
 	}
 
+	gohatCmd.PersistentFlags().StringVarP(&outputFormat, "output", "", "", "Structured output format for supported commands: json, csv or table")
+
+	var allocsBySite bool
+	var allocsSort string
 	var allocsCommand = &cobra.Command{
 		Use:   "allocs",
 		Short: "Dump the alloc stack trace samples",
 		Run: func(cmd *cobra.Command, args []string) {
 			heapFile := verifyHeapDumpFile(args)
 
+			if allocsBySite {
+				sites := heapFile.AllocSites()
+				switch allocsSort {
+				case "bytes":
+				case "objects":
+					sort.Sort(heapfile.AllocSitesByObjects(sites))
+				case "live":
+					sort.Sort(heapfile.AllocSitesByLiveRatio(sites))
+				default:
+					fmt.Println("Unknown sort:", allocsSort)
+					exit(1)
+				}
+
+				if structuredOutput() {
+					records := make([]*allocSiteRecord, 0, len(sites))
+					for _, site := range sites {
+						record := &allocSiteRecord{Function: site.Name(), Objects: site.Objects, Bytes: site.Bytes,
+							Allocs: site.Allocs, Frees: site.Frees, LiveRatio: site.LiveRatio()}
+						for _, t := range site.Types {
+							record.Types = append(record.Types, fmt.Sprintf("%d %s", t.Count, t.Name))
+						}
+						for _, frame := range site.Frames {
+							record.Stack = append(record.Stack, frame.String())
+						}
+						records = append(records, record)
+					}
+					writeOutput(records)
+					return
+				}
+
+				fmt.Println(len(sites), "allocation sites")
+				for _, site := range sites {
+					displayAllocSite(site)
+				}
+				return
+			}
+
 			allocs := heapFile.Allocs()
+			if structuredOutput() {
+				records := make([]*allocRecord, 0, len(allocs))
+				for _, alloc := range allocs {
+					record := &allocRecord{Type: "<freed>"}
+					if obj := alloc.Object(); obj != nil {
+						record.Object = hexUint(obj.Address)
+						record.Type = obj.Name()
+					}
+					if profile := alloc.Profile(); profile != nil {
+						record.Record = hexUint(profile.Record)
+						record.Size = profile.Size
+						record.Allocs = profile.Allocs
+						record.Frees = profile.Frees
+						for _, frame := range profile.Frames {
+							record.Stack = append(record.Stack, frame.String())
+						}
+					}
+					records = append(records, record)
+				}
+				writeOutput(records)
+				return
+			}
+
 			fmt.Println(len(allocs), "alloc samples")
 			for _, alloc := range allocs {
 				obj := alloc.Object()
-				if obj.Type == nil {
-					fmt.Println("<unknown>")
+				if obj == nil {
+					fmt.Println("<freed>")
+				} else if obj.Type == nil {
+					fmt.Println("unknown")
 				} else {
 					fmt.Println(obj.Type.Name)
 				}
 				record := alloc.Profile()
-				fmt.Printf("%x %d %d %d\n", record.Record, record.Size, record.Allocs, record.Frees)
+				if record == nil {
+					fmt.Println("<no profile record>")
+					fmt.Println()
+					continue
+				}
+				fmt.Printf("0x%x %d %d %d\n", record.Record, record.Size, record.Allocs, record.Frees)
 				for _, frame := range record.Frames {
 					fmt.Printf("\t%s   %s:%d\n", frame.Name, frame.File, frame.Line)
 				}
@@ -45,8 +125,70 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 			}
 		},
 	}
+	allocsCommand.Flags().BoolVarP(&allocsBySite, "by-site", "s", false, "Group the samples by allocation site")
+	allocsCommand.Flags().StringVarP(&allocsSort, "sort", "o", "bytes", "Order of sites with --by-site: bytes, objects or live")
 	gohatCmd.AddCommand(allocsCommand)
 
+	var checkCommand = &cobra.Command{
+		Use:   "check",
+		Short: "Check a heap file against thresholds from a rules file",
+		Long: `Checks the heap file against the thresholds in a YAML rules file and exits with
+status 1 if any is exceeded. Every threshold is optional:
+
+  types:
+    - pattern: "map.bucket*"
+      max_objects: 1000
+      max_bytes: 1048576
+  goroutines:
+    max_total: 500
+    max_per_stack: 100
+  max_garbage_bytes: 1048576
+  max_fragmented_bytes: 4194304
+  max_fragmentation: 0.5
+  max_duplicate_string_waste: 65536
+  memstats:
+    HeapInuse: 67108864
+
+max_fragmentation is the fraction of HeapInuse that no object occupies. memstats takes
+the statistics the memstats command shows.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			heapFile := verifyHeapDumpFile(args)
+
+			if len(args) != 2 {
+				fmt.Println("check <heap file> <rules file>")
+				exit(1)
+			}
+
+			rules, err := readRules(args[1])
+			if err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
+			}
+			results, err := heapFile.Check(rules)
+			if err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
+			}
+
+			failed := 0
+			for _, result := range results {
+				if !result.Passed {
+					failed++
+				}
+			}
+			if structuredOutput() {
+				writeOutput(newCheckRecords(results))
+			} else {
+				displayCheckResults(results)
+				fmt.Printf("\n%d of %d checks failed\n", failed, len(results))
+			}
+			if failed > 0 {
+				exit(1)
+			}
+		},
+	}
+	gohatCmd.AddCommand(checkCommand)
+
 	var dataCommand = &cobra.Command{
 		Use:   "data",
 		Short: "Dump objects found in the data segment",
@@ -55,6 +197,11 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 
 			data := heapFile.DataSegment()
 			objects := data.Objects()
+			if structuredOutput() {
+				writeOutput(newObjectRecords(objects))
+				return
+			}
+
 			fmt.Printf("Found %d objects in the data segment\n", len(objects))
 			for _, object := range objects {
 				displayObjectShort(object)
@@ -71,6 +218,11 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 
 			data := heapFile.BSS()
 			objects := data.Objects()
+			if structuredOutput() {
+				writeOutput(newObjectRecords(objects))
+				return
+			}
+
 			fmt.Printf("Found %d objects in the data segment\n", len(objects))
 			for _, object := range objects {
 				displayObjectShort(object)
@@ -79,17 +231,40 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 	}
 	gohatCmd.AddCommand(bssCommand)
 
+	var goroutineStatuses []string
 	var goroutinesCommand = &cobra.Command{
 		Use:   "goroutines",
 		Short: "Dump goroutines",
 		Run: func(cmd *cobra.Command, args []string) {
 			heapFile := verifyHeapDumpFile(args)
 
+			statuses := make(map[heapfile.GoroutineStatus]bool, len(goroutineStatuses))
+			for _, name := range goroutineStatuses {
+				status, err := heapfile.ParseGoroutineStatus(name)
+				if err != nil {
+					fmt.Println("Error:", err)
+					exit(1)
+				}
+				statuses[status] = true
+			}
+
+			records := make([]*goroutineRecord, 0)
 			for _, g := range heapFile.Goroutines() {
+				if len(statuses) > 0 && !statuses[g.Status().Base()] {
+					continue
+				}
+
+				if structuredOutput() {
+					records = append(records, &goroutineRecord{g.Id, hexUint(g.Address), hexUint(g.Top), hexUint(g.Location),
+						g.Status().String(), g.ReasonWaiting(), g.LastWaiting, hexUint(g.CurrentFrame), g.OSThread,
+						hexUint(g.DeferRecord), hexUint(g.PanicRecord), g.System, g.Background})
+					continue
+				}
+
 				fmt.Printf("Goroutine %d\n", g.Id)
-				fmt.Printf("\tAddress: %x\n", g.Address)
-				fmt.Printf("\tTop of stack: %x\n", g.Top)
-				fmt.Printf("\tCreator Location: %x\n", g.Location)
+				fmt.Printf("\tAddress: 0x%x\n", g.Address)
+				fmt.Printf("\tTop of stack: 0x%x\n", g.Top)
+				fmt.Printf("\tCreator Location: 0x%x\n", g.Location)
 				if g.System {
 					fmt.Println("\tSystem Started Go routine")
 				}
@@ -101,50 +276,185 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 					fmt.Printf("\tReason Waiting: %s\n", reason)
 				}
 				fmt.Printf("\tLast Started Waiting: %d\n", g.LastWaiting)
-				fmt.Printf("\tCurrent Frame: %x\n", g.CurrentFrame)
+				fmt.Printf("\tCurrent Frame: 0x%x\n", g.CurrentFrame)
 				fmt.Printf("\tOS Thread %d\n", g.OSThread)
-				fmt.Printf("\tTop Defer Record: %x\n", g.DeferRecord)
-				fmt.Printf("\tTop Panic Record: %x\n", g.PanicRecord)
+				fmt.Printf("\tTop Defer Record: 0x%x\n", g.DeferRecord)
+				fmt.Printf("\tTop Panic Record: 0x%x\n", g.PanicRecord)
 				fmt.Println("")
 			}
+			if structuredOutput() {
+				writeOutput(records)
+			}
 		},
 	}
+	goroutinesCommand.Flags().StringSliceVarP(&goroutineStatuses, "status", "s", nil, "Only show goroutines with these statuses (e.g. waiting,syscall)")
 	gohatCmd.AddCommand(goroutinesCommand)
 
+	var leaksCommand = &cobra.Command{
+		Use:   "leaks",
+		Short: "Find likely leaks",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	gohatCmd.AddCommand(leaksCommand)
+
+	var leakThreshold time.Duration
+	var leaksGoroutinesCommand = &cobra.Command{
+		Use:   "goroutines",
+		Short: "Find goroutines that have been blocked longer than a threshold",
+		Run: func(cmd *cobra.Command, args []string) {
+			heapFile := verifyHeapDumpFile(args)
+
+			leaks := heapFile.GoroutineLeaks(leakThreshold)
+			if structuredOutput() {
+				records := make([]*leakRecord, 0)
+				for _, leak := range leaks {
+					for _, blocked := range leak.Goroutines {
+						records = append(records, &leakRecord{blocked.Goroutine.Id, hexUint(leak.Location), leak.Function,
+							leak.Reason, blocked.Waiting.String(), objectAddresses(blocked.BlockedOn)})
+					}
+				}
+				writeOutput(records)
+				return
+			}
+
+			total := 0
+			for _, leak := range leaks {
+				total += len(leak.Goroutines)
+			}
+			fmt.Printf("Found %d goroutines blocked longer than %s in %d groups\n", total, leakThreshold, len(leaks))
+
+			for _, leak := range leaks {
+				location := fmt.Sprintf("0x%x", leak.Location)
+				if leak.Function != "" {
+					location = fmt.Sprintf("%s (0x%x)", leak.Function, leak.Location)
+				}
+				fmt.Printf("\n%d goroutines created at %s waiting on %s\n", len(leak.Goroutines), location, leak.Reason)
+				for _, blocked := range leak.Goroutines {
+					fmt.Printf("\tGoroutine %d waiting %s\n", blocked.Goroutine.Id, blocked.Waiting)
+					for _, object := range blocked.BlockedOn {
+						fmt.Printf("\t\tblocked on 0x%x %s %s\n", object.Address, object.Name(), object.Kind())
+					}
+				}
+			}
+		},
+	}
+	leaksGoroutinesCommand.Flags().DurationVarP(&leakThreshold, "threshold", "t", time.Minute, "Minimum time spent waiting")
+	leaksCommand.AddCommand(leaksGoroutinesCommand)
+
 	var histBySize bool
+	var histogramList listFlags
 	var histogramCommand = &cobra.Command{
 		Use:   "histogram",
 		Short: "Dump a histogram of object counts by type",
 		Run: func(cmd *cobra.Command, args []string) {
 			heapFile := verifyHeapDumpFile(args)
 
-			counts := make(map[string]int, 0)
-			for _, object := range heapFile.Objects() {
-				if object.Type != nil {
-					if histBySize {
-						counts[object.Type.Name] += object.Size
-					} else {
-						counts[object.Type.Name] += 1
-					}
-				} else {
-					if histBySize {
-						counts["<unknown>"] += object.Size
-					} else {
-						counts["<unknown>"] += 1
-					}
+			// Without --sort the histogram is sorted by what it shows, so --top keeps the largest
+			// buckets, and is printed in ascending order unless --reverse is given
+			options := histogramList.options()
+			ascending := false
+			if options.Sort == "" {
+				options.Sort = "count"
+				if histBySize {
+					options.Sort = "size"
+				}
+				ascending = !options.Reverse
+				options.Reverse = false
+			}
+			buckets := options.Histogram(heapFile.Objects())
+			if ascending {
+				for i, j := 0, len(buckets)-1; i < j; i, j = i+1, j-1 {
+					buckets[i], buckets[j] = buckets[j], buckets[i]
 				}
 			}
 
-			histogram := NewHistSorter(counts)
-			histogram.Sort()
-			for i := 0; i < len(histogram.Keys); i++ {
-				fmt.Printf("%d\t%s\n", histogram.Vals[i], histogram.Keys[i])
+			if structuredOutput() {
+				records := make([]*histogramRecord, 0, len(buckets))
+				for _, e := range buckets {
+					records = append(records, &histogramRecord{e.Name, int(e.Count), int(e.Size)})
+				}
+				writeOutput(records)
+				return
+			}
+
+			for _, e := range buckets {
+				if histBySize {
+					fmt.Printf("%d\t%s\n", e.Size, e.Name)
+				} else {
+					fmt.Printf("%d\t%s\n", e.Count, e.Name)
+				}
 			}
 		},
 	}
 	histogramCommand.Flags().BoolVarP(&histBySize, "size", "s", false, "Histogram by total object size")
+	histogramList.register(histogramCommand, true)
 	gohatCmd.AddCommand(histogramCommand)
 
+	var mapDepth int
+	var mapCommand = &cobra.Command{
+		Use:   "map",
+		Short: "Dump the entries and statistics of a map, or statistics for all maps",
+		Run: func(cmd *cobra.Command, args []string) {
+			heapFile := verifyHeapDumpFile(args)
+			if !canFindMapsAndChannels(heapFile, "maps") {
+				return
+			}
+
+			if len(args) == 1 {
+				maps := heapFile.Maps()
+				if structuredOutput() {
+					records := make([]*mapRecord, 0, len(maps))
+					for _, m := range maps {
+						records = append(records, &mapRecord{hexUint(m.Object.Address), m.Object.Name(), m.Count, m.Buckets,
+							m.OverflowBuckets, m.LoadFactor(), m.WastedBytes()})
+					}
+					writeOutput(records)
+					return
+				}
+
+				fmt.Printf("Found %d maps\n", len(maps))
+				for _, m := range maps {
+					fmt.Printf("0x%x %s count %d buckets %d overflow %d load %.2f wasted %d\n",
+						m.Object.Address, m.Object.Name(), m.Count, m.Buckets, m.OverflowBuckets, m.LoadFactor(), m.WastedBytes())
+				}
+				return
+			}
+
+			if len(args) != 2 {
+				fmt.Println("map <heap file> [address]")
+				exit(1)
+			}
+			checkOutputFormat("map <address>")
+
+			addr, err := parseAddress(args[1])
+			if err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
+			}
+			object := heapFile.Object(addr)
+			if object == nil {
+				fmt.Println("Could not find object")
+				return
+			}
+
+			m := heapFile.Map(object, mapDepth)
+			if m == nil {
+				fmt.Println("Object is not a map")
+				return
+			}
+
+			displayMapStats(m)
+			fmt.Println("")
+			for _, entry := range m.Entries {
+				fmt.Printf("%s: %s\n", entry.Key, entry.Value)
+			}
+		},
+	}
+	mapCommand.Flags().IntVarP(&mapDepth, "depth", "d", 0, "Number of pointers to follow in keys and values")
+	gohatCmd.AddCommand(mapCommand)
+
 	var memProfCommand = &cobra.Command{
 		Use:   "memprof",
 		Short: "Dump the alloc/free profile records",
@@ -152,8 +462,18 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 			heapFile := verifyHeapDumpFile(args)
 
 			memProf := heapFile.MemProf()
+			if structuredOutput() {
+				records := make([]*profileRecord, 0, len(memProf))
+				for _, record := range memProf {
+					records = append(records, &profileRecord{hexUint(record.Record), record.Size, record.Allocs, record.Frees,
+						record.Stack()})
+				}
+				writeOutput(records)
+				return
+			}
+
 			for _, record := range memProf {
-				fmt.Printf("%x %d %d %d\n", record.Record, record.Size, record.Allocs, record.Frees)
+				fmt.Printf("0x%x %d %d %d\n", record.Record, record.Size, record.Allocs, record.Frees)
 				for _, frame := range record.Frames {
 					fmt.Printf("\t%s   %s:%d\n", frame.Name, frame.File, frame.Line)
 				}
@@ -169,6 +489,11 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 			heapFile := verifyHeapDumpFile(args)
 
 			memstats := heapFile.MemStats()
+			if structuredOutput() {
+				writeOutput(newMemStatRecords(memstats))
+				return
+			}
+
 			fmt.Println("General statistics")
 			fmt.Println("Alloc:", memstats.Alloc)
 			fmt.Println("TotalAlloc:", memstats.TotalAlloc)
@@ -205,18 +530,109 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 	}
 	gohatCmd.AddCommand(memStatsCommand)
 
+	var channelDepth int
+	var channelsCommand = &cobra.Command{
+		Use:   "channels",
+		Short: "List channels by buffer size, or dump the contents of a channel",
+		Run: func(cmd *cobra.Command, args []string) {
+			heapFile := verifyHeapDumpFile(args)
+			if !canFindMapsAndChannels(heapFile, "channels") {
+				return
+			}
+
+			if len(args) == 1 {
+				channels := heapFile.Channels()
+				if structuredOutput() {
+					records := make([]*channelRecord, 0, len(channels))
+					for _, c := range channels {
+						records = append(records, &channelRecord{hexUint(c.Object.Address), c.Object.Name(), c.Count, c.Capacity,
+							c.BufferBytes(), c.FillRatio(), len(c.RecvWaiters), len(c.SendWaiters), c.Closed})
+					}
+					writeOutput(records)
+					return
+				}
+
+				fmt.Printf("Found %d channels\n", len(channels))
+				for _, c := range channels {
+					fmt.Printf("0x%x chan %s len %d cap %d buffer %d fill %.0f%% receivers %d senders %d",
+						c.Object.Address, c.Object.Name(), c.Count, c.Capacity, c.BufferBytes(), c.FillRatio()*100,
+						len(c.RecvWaiters), len(c.SendWaiters))
+					if c.Closed {
+						fmt.Print(" closed")
+					}
+					fmt.Println("")
+				}
+				return
+			}
+
+			if len(args) != 2 {
+				fmt.Println("channels <heap file> [address]")
+				exit(1)
+			}
+			checkOutputFormat("channels <address>")
+
+			addr, err := parseAddress(args[1])
+			if err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
+			}
+			object := heapFile.Object(addr)
+			if object == nil {
+				fmt.Println("Could not find object")
+				return
+			}
+
+			c := heapFile.Channel(object, channelDepth)
+			if c == nil {
+				fmt.Println("Object is not a channel")
+				return
+			}
+
+			fmt.Printf("0x%x chan %s\n", c.Object.Address, c.Object.Name())
+			fmt.Println("Capacity:", c.Capacity)
+			fmt.Println("Queued:", c.Count)
+			fmt.Println("Element Size:", c.ElemSize)
+			fmt.Println("Buffer Bytes:", c.BufferBytes())
+			fmt.Println("Send Index:", c.SendIndex)
+			fmt.Println("Receive Index:", c.RecvIndex)
+			fmt.Println("Closed:", c.Closed)
+
+			fmt.Println("\nBuffered Elements")
+			for _, elem := range c.Buffer {
+				fmt.Println(elem)
+			}
+
+			fmt.Println("\nWaiting Receivers")
+			for _, g := range c.RecvWaiters {
+				fmt.Printf("Goroutine %d %s\n", g.Id, g.ReasonWaiting())
+			}
+
+			fmt.Println("\nWaiting Senders")
+			for _, g := range c.SendWaiters {
+				fmt.Printf("Goroutine %d %s\n", g.Id, g.ReasonWaiting())
+			}
+		},
+	}
+	channelsCommand.Flags().IntVarP(&channelDepth, "depth", "d", 0, "Number of pointers to follow in buffered elements")
+	gohatCmd.AddCommand(channelsCommand)
+
 	var containsCommand = &cobra.Command{
 		Use:   "contains",
 		Short: "Find objects that point to an address",
 		Run: func(cmd *cobra.Command, args []string) {
+			checkOutputFormat("contains")
 			heapFile := verifyHeapDumpFile(args)
 
 			if len(args) != 2 {
 				fmt.Println("contains <heap file> <address>")
-				os.Exit(1)
+				exit(1)
 			}
 
-			addr, _ := strconv.ParseUint(args[1], 16, 64)
+			addr, err := parseAddress(args[1])
+			if err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
+			}
 
 			// Check data segment
 			for _, object := range heapFile.DataSegment().Objects() {
@@ -238,7 +654,7 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 			for _, object := range heapFile.Objects() {
 				for _, child := range object.Children() {
 					if uint64(addr) == child.Address {
-						fmt.Printf("Found in object %x\n", object.Address)
+						fmt.Printf("Found in object 0x%x\n", object.Address)
 						return
 					}
 				}
@@ -248,18 +664,26 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 	gohatCmd.AddCommand(containsCommand)
 
 	var objectBinary bool
+	var objectPretty bool
+	var objectString bool
+	var objectDepth int
 	var objectCommand = &cobra.Command{
 		Use:   "object",
 		Short: "Dump the contents of an object",
 		Run: func(cmd *cobra.Command, args []string) {
+			checkOutputFormat("object")
 			heapFile := verifyHeapDumpFile(args)
 
 			if len(args) != 2 {
 				fmt.Println("object <heap file> <address>")
-				os.Exit(1)
+				exit(1)
 			}
 
-			addr, _ := strconv.ParseUint(args[1], 16, 64)
+			addr, err := parseAddress(args[1])
+			if err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
+			}
 			object := heapFile.Object(addr)
 			if object == nil {
 				fmt.Println("Could not find object")
@@ -271,55 +695,43 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 				return
 			}
 
-			fmt.Printf("%x %s %d %d\n", object.Address, object.Kind(), object.Size, len(object.Content))
+			fmt.Printf("0x%x %s %d %d\n", object.Address, object.Kind(), object.Size, len(object.Content))
 			if object.Type != nil {
 				fmt.Println(object.Type.Name)
 			}
 
+			text, isString := "", false
 			if object.Type != nil && object.Type.Name == "string" {
-				val := derefToString([]byte(object.Content), heapFile)
-				if val != "" {
-					fmt.Printf("Value: %s\n", val)
+				if val := heapFile.DecodeObject(object, 0); val.Kind == heapfile.ValueString && val.Object != nil {
+					text, isString = val.FullText(), true
 				}
 			}
+			if objectString {
+				if !isString {
+					text = strings.TrimRight(object.Content, "\x00")
+				}
+				fmt.Printf("\n%s\n", text)
+				return
+			}
+			if isString {
+				fmt.Printf("Value: %s\n", text)
+			}
 			fmt.Println("")
-			fmt.Print(hexDump(object.Content))
-			fmt.Print("\n\n")
 
-			if object.Type != nil {
-				fmt.Println("Field List:")
-				var lastOffset uint64
-				for idx, field := range object.Type.FieldList {
-					if idx == len(object.Type.FieldList)-1 {
-						data := []byte(object.Content)[lastOffset:]
-						switch field.KindString() {
-						case "Ptr   ":
-							var ptraddr int64
-							buf := bytes.NewReader(data)
-							binary.Read(buf, binary.LittleEndian, &ptraddr)
-							fmt.Printf("%s 0x%04x  %x\n", field.KindString(), field.Offset, ptraddr)
-						case "String":
-							// val := derefToString(data, heapFile)
-							fmt.Printf("%s 0x%04x  \n", field.KindString(), field.Offset)
-						default:
-							fmt.Printf("%s 0x%04x  \n", field.KindString(), field.Offset)
-						}
-					} else {
-						lastOffset = object.Type.FieldList[idx].Offset
-						nextOffset := object.Type.FieldList[idx+1].Offset
-						data := []byte(object.Content)[lastOffset:nextOffset]
-						switch field.Kind {
-						case heapfile.FieldPtr:
-							var ptraddr int64
-							buf := bytes.NewReader(data)
-							binary.Read(buf, binary.LittleEndian, &ptraddr)
-							fmt.Printf("%s 0x%04x  %x\n", field.KindString(), field.Offset, ptraddr)
-						case heapfile.FieldStr:
-							// val := derefToString(data, heapFile)
-							fmt.Printf("%s 0x%04x  \n", field.KindString(), field.Offset)
-						default:
-							fmt.Printf("%s 0x%04x  \n", field.KindString(), field.Offset)
+			if objectPretty {
+				fmt.Println(heapFile.DecodeObject(object, objectDepth))
+			} else {
+				fmt.Print(hexDump(object.Content))
+				fmt.Print("\n\n")
+
+				if object.Type != nil {
+					fmt.Println("Field List:")
+					for _, field := range object.Type.FieldList {
+						value := ""
+						if v := heapFile.DecodeField(object, field, 0); v != nil {
+							value = v.String()
 						}
+						fmt.Printf("%s 0x%04x  %s\n", field.KindString(), field.Offset, value)
 					}
 				}
 			}
@@ -331,24 +743,30 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 		},
 	}
 	objectCommand.Flags().BoolVarP(&objectBinary, "binary", "b", false, "Dump the binary contents of the object")
+	objectCommand.Flags().BoolVarP(&objectPretty, "pretty", "p", false, "Show the object as a Go value")
+	objectCommand.Flags().BoolVarP(&objectString, "string", "s", false, "Show the string the object holds or points to in full")
+	objectCommand.Flags().IntVarP(&objectDepth, "depth", "d", 1, "Number of pointers to follow with --pretty")
 	gohatCmd.AddCommand(objectCommand)
 
+	var objectsList listFlags
 	var objectsCommand = &cobra.Command{
 		Use:   "objects",
 		Short: "Dump a list of objects",
 		Run: func(cmd *cobra.Command, args []string) {
 			heapFile := verifyHeapDumpFile(args)
 
-			objects := heapFile.Objects()
+			objects := filterObjects(heapFile.Objects(), objectsList.options())
+			if structuredOutput() {
+				writeOutput(newObjectRecords(objects))
+				return
+			}
+
 			for _, object := range objects {
-				typeName := "<unknown>"
-				if object.Type != nil {
-					typeName = object.Type.Name
-				}
-				fmt.Printf("%x,%s,%s,%d\n", object.Address, typeName, object.Kind(), object.Size)
+				fmt.Printf("0x%x,%s,%s,%d\n", object.Address, object.Name(), object.Kind(), object.Size)
 			}
 		},
 	}
+	objectsList.register(objectsCommand, true)
 	gohatCmd.AddCommand(objectsCommand)
 
 	var paramsCommand = &cobra.Command{
@@ -358,30 +776,103 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 			heapFile := verifyHeapDumpFile(args)
 
 			dumpParams := heapFile.DumpParams()
+			if structuredOutput() {
+				writeOutput(newParamsRecord(heapFile.Version(), dumpParams, heapFile.Metadata()))
+				return
+			}
+
+			fmt.Println("Format:", heapFile.Version())
 			if dumpParams.BigEndian {
 				fmt.Println("Big Endian")
 			} else {
 				fmt.Println("Little Endian")
 			}
 			fmt.Println("Pointer Size:", dumpParams.PtrSize)
-			fmt.Println("Channel Header Size:", dumpParams.ChHdrSize)
-			fmt.Printf("Heap Starting Address %02x\n", dumpParams.StartAddress)
-			fmt.Printf("Heap Ending Address: %02x\n", dumpParams.EndAddress)
-			fmt.Println("Architecture:", dumpParams.Arch)
-			fmt.Println("GOEXPERIMENT:", dumpParams.GoExperiment)
+			if heapFile.Version() == "go1.3" {
+				fmt.Println("Channel Header Size:", dumpParams.ChHdrSize)
+			}
+			fmt.Printf("Heap Starting Address 0x%x\n", dumpParams.StartAddress)
+			fmt.Printf("Heap Ending Address: 0x%x\n", dumpParams.EndAddress)
+			if heapFile.Version() == "go1.3" {
+				fmt.Println("Architecture:", dumpParams.Arch)
+				fmt.Println("GOEXPERIMENT:", dumpParams.GoExperiment)
+			} else {
+				fmt.Println("GOARCH:", dumpParams.GoArch)
+				fmt.Println("Runtime Version:", dumpParams.GoVersion)
+			}
 			fmt.Println("nCPU:", dumpParams.NCPU)
+
+			if metadata := heapFile.Metadata(); metadata != nil {
+				fmt.Println("")
+				displayMetadata(metadata)
+			}
 		},
 	}
 	gohatCmd.AddCommand(paramsCommand)
 
+	var redactScramble bool
+	var redactTypes []string
+	var redactStrings bool
+	var redactCommand = &cobra.Command{
+		Use:   "redact",
+		Short: "Write a copy of a heap file with object contents redacted",
+		Run: func(cmd *cobra.Command, args []string) {
+			checkOutputFormat("redact")
+			heapFile := verifyHeapDumpFile(args)
+
+			if len(args) != 2 {
+				fmt.Println("redact <heap file> <output file>")
+				exit(1)
+			}
+
+			options := &heapfile.RedactOptions{Strings: redactStrings}
+			if redactScramble {
+				options.Mode = heapfile.RedactScramble
+			}
+			for _, t := range redactTypes {
+				re, err := regexp.Compile(t)
+				if err != nil {
+					fmt.Println("Error:", err)
+					exit(1)
+				}
+				options.Types = append(options.Types, re)
+			}
+
+			out, err := os.Create(args[1])
+			if err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
+			}
+			defer out.Close()
+
+			if err := heapFile.Redact(out, options); err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
+			}
+		},
+	}
+	redactCommand.Flags().BoolVarP(&redactScramble, "scramble", "s", false, "Scramble contents instead of zeroing them")
+	redactCommand.Flags().StringArrayVarP(&redactTypes, "type", "t", nil, "Only redact objects whose type name matches this regex (may be repeated)")
+	redactCommand.Flags().BoolVarP(&redactStrings, "strings", "", false, "Only redact string data (combined with --type)")
+	gohatCmd.AddCommand(redactCommand)
+
 	var rootsCommand = &cobra.Command{
 		Use:   "roots",
 		Short: "dump other roots",
 		Run: func(cmd *cobra.Command, args []string) {
 			heapFile := verifyHeapDumpFile(args)
 
+			if structuredOutput() {
+				records := make([]*rootRecord, 0)
+				for _, root := range heapFile.OtherRoots() {
+					records = append(records, &rootRecord{hexUint(root.Pointer), root.Description})
+				}
+				writeOutput(records)
+				return
+			}
+
 			for _, root := range heapFile.OtherRoots() {
-				fmt.Printf("%x %s\n", root.Pointer, root.Description)
+				fmt.Printf("0x%x %s\n", root.Pointer, root.Description)
 			}
 		},
 	}
@@ -394,103 +885,421 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 			heapFile := verifyHeapDumpFile(args)
 
 			totalFrag := uint64(0)
-
-			objects := heapFile.Objects()
-			addresses := make(uint64arr, 0, len(objects))
-			for _, obj := range objects {
-				addresses = append(addresses, obj.Address)
+			records := make([]*fragmentRecord, 0)
+			for _, fragment := range heapFile.Fragments() {
+				totalFrag += fragment.Size()
+				records = append(records, &fragmentRecord{hexUint(fragment.Start), hexUint(fragment.End), fragment.Size()})
+				if !structuredOutput() {
+					fmt.Printf("0x%x - 0x%x  (%d)\n", fragment.Start, fragment.End, fragment.Size())
+				}
 			}
-			sort.Sort(addresses)
-
-			firstAddr := addresses[0]
-			lastAddr := addresses[len(addresses)-1]
-			lastObject := heapFile.Object(lastAddr)
 
-			for i := 0; i < len(addresses)-1; i++ {
-				addr := addresses[i]
-				nextAddr := addresses[i+1]
-				object := heapFile.Object(addr)
-				size := object.Size
-				endAddr := addr + uint64(size)
+			if structuredOutput() {
+				writeOutput(records)
+				return
+			}
 
-				if endAddr != nextAddr {
-					fragAmount := nextAddr - endAddr
-					totalFrag += fragAmount
-					fmt.Printf("%x - %x  (%d)\n", endAddr, nextAddr, fragAmount)
+			objects := heapFile.Objects()
+			firstAddr := objects[0].Address
+			for _, object := range objects {
+				if object.Address < firstAddr {
+					firstAddr = object.Address
 				}
 			}
-
-			// May be junk on the end
 			params := heapFile.DumpParams()
-			endAddr := lastAddr + uint64(lastObject.Size)
-			endCruft := params.EndAddress - endAddr
-			if endCruft > 0 {
-				totalFrag += endCruft
-				fmt.Printf("%x - %x  (%d)\n", endAddr, params.EndAddress, endCruft)
-			}
-
-			fmt.Printf("Total bytes fragmented between %x and %x: %d\n", firstAddr, params.EndAddress, totalFrag)
+			fmt.Printf("Total bytes fragmented between 0x%x and 0x%x: %d\n", firstAddr, params.EndAddress, totalFrag)
 		},
 	}
 	gohatCmd.AddCommand(fragmentCommand)
 
-	type sameObject struct {
-		Object      *heapfile.Object
-		SameContent bool
-	}
+	var sameFreed bool
+	var sameAllocated bool
 	var sameCommand = &cobra.Command{
 		Use:   "same",
 		Short: "find objects that are the same in two heap files",
+		Long: `Pairs the objects of the first heap file with the objects they became in the second,
+by following pointers from globals, roots and stacks and by comparing contents. Prints the old
+address, new address, type, size and whether the contents are unchanged, ignoring pointers.`,
 		Run: func(cmd *cobra.Command, args []string) {
+			checkOutputFormat("same")
 			heapFile1 := verifyHeapDumpFile(args)
 
 			if len(args) != 2 {
 				fmt.Println("same <heap file> <heap file>")
-				os.Exit(1)
+				exit(1)
+			}
+			if sameFreed && sameAllocated {
+				fmt.Println("Error: --freed and --allocated can't be used together")
+				exit(1)
 			}
 
-			heapFile2, err := heapfile.New(args[1])
+			heapFile2, err := openHeapFile(args[1])
 			if err != nil {
 				fmt.Println("Error:", err)
-				os.Exit(1)
+				exit(1)
 			}
 
-			heapObjects1 := heapFile1.Objects()
+			matching := heapfile.Match(heapFile1, heapFile2)
 
-			same := make([]*sameObject, 0, len(heapObjects1))
+			if sameFreed || sameAllocated {
+				objects := matching.Freed
+				if sameAllocated {
+					objects = matching.Allocated
+				}
+				for _, object := range objects {
+					fmt.Printf("0x%x,%s,%d\n", object.Address, object.Name(), object.Size)
+				}
+				return
+			}
 
-			for _, obj := range heapObjects1 {
-				if cmp := heapFile2.Object(obj.Address); cmp != nil {
-					if cmp.TypeAddress == obj.TypeAddress &&
-						cmp.Kind() == obj.Kind() &&
-						cmp.Size == obj.Size {
-						same = append(same, &sameObject{obj, cmp.Content == obj.Content})
-					}
+			for _, match := range matching.Survived {
+				fmt.Printf("0x%x,0x%x,%s,%d,%v\n", match.Old.Address, match.New.Address, match.Old.Name(), match.Old.Size, !match.Changed)
+			}
+		},
+	}
+	sameCommand.Flags().BoolVarP(&sameFreed, "freed", "f", false, "List objects only in the first heap file")
+	sameCommand.Flags().BoolVarP(&sameAllocated, "allocated", "n", false, "List objects only in the second heap file")
+	gohatCmd.AddCommand(sameCommand)
+
+	var diffJSON bool
+	var diffLimit int
+	var diffCommand = &cobra.Command{
+		Use:   "diff",
+		Short: "Compare two heap files by type, allocation site, root, goroutine and memstats",
+		Run: func(cmd *cobra.Command, args []string) {
+			checkOutputFormat("diff", "json")
+			oldHeapFile := verifyHeapDumpFile(args)
+
+			if len(args) != 2 {
+				fmt.Println("diff <old heap file> <new heap file>")
+				exit(1)
+			}
+
+			newHeapFile, err := openHeapFile(args[1])
+			if err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
+			}
+
+			diff := heapfile.Diff(oldHeapFile, newHeapFile)
+			if diffJSON || outputFormat == "json" {
+				out, err := json.MarshalIndent(diff, "", "  ")
+				if err != nil {
+					fmt.Println("Error:", err)
+					exit(1)
 				}
+				fmt.Println(string(out))
+				return
 			}
+			displayDiff(diff, diffLimit)
+		},
+	}
+	diffCommand.Flags().BoolVarP(&diffJSON, "json", "j", false, "Output the full diff as JSON")
+	diffCommand.Flags().IntVarP(&diffLimit, "limit", "l", 20, "Maximum number of entries per section (0 for all)")
+	gohatCmd.AddCommand(diffCommand)
+
+	var callTreeFolded bool
+	var callTreeDepth int
+	var callTreeCommand = &cobra.Command{
+		Use:   "calltree",
+		Short: "Show the bytes retained by the objects allocated at each alloc sample stack",
+		Run: func(cmd *cobra.Command, args []string) {
+			checkOutputFormat("calltree")
+			heapFile := verifyHeapDumpFile(args)
 
-			for _, same := range same {
-				object := same.Object
-				typeName := "unknown"
-				if object.Type != nil {
-					typeName = object.Type.Name
+			tree := heapFile.RetainedCallTree()
+			if callTreeFolded {
+				if err := tree.WriteFolded(os.Stdout); err != nil {
+					fmt.Println("Error:", err)
+					exit(1)
 				}
+				return
+			}
+			displayCallTree(tree, 0, callTreeDepth)
+		},
+	}
+	callTreeCommand.Flags().BoolVarP(&callTreeFolded, "folded", "f", false, "Output folded stacks for flamegraph tools")
+	callTreeCommand.Flags().IntVarP(&callTreeDepth, "depth", "d", 0, "Maximum depth of the tree to show (0 for all)")
+	gohatCmd.AddCommand(callTreeCommand)
+
+	var pprofOutput string
+	var pprofLive bool
+	var pprofCommand = &cobra.Command{
+		Use:   "pprof",
+		Short: "Export the memory profile as a pprof heap profile",
+		Long: `Writes a gzipped profile.proto with alloc_objects, alloc_space, inuse_objects and
+inuse_space samples, for use with go tool pprof. With --live the samples are synthesized
+from the live objects, grouped by type and, for sampled objects, allocation stack.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkOutputFormat("pprof")
+			heapFile := verifyHeapDumpFile(args)
 
-				fmt.Printf("%x,%s,%d,%v\n", object.Address, typeName, object.Size, same.SameContent)
+			f, err := os.Create(pprofOutput)
+			if err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
+			}
+			defer f.Close()
+
+			if err := heapFile.WritePprof(f, pprofLive); err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
 			}
 		},
 	}
-	gohatCmd.AddCommand(sameCommand)
+	pprofCommand.Flags().StringVarP(&pprofOutput, "file", "o", "heap.pb.gz", "File to write the profile to")
+	pprofCommand.Flags().BoolVarP(&pprofLive, "live", "l", false, "Synthesize samples from the live objects by type")
+	gohatCmd.AddCommand(pprofCommand)
+
+	var queryCommand = &cobra.Command{
+		Use:   "query",
+		Short: "Select objects with a SQL like query",
+		Long: `Runs a query over the objects in the heap, for example:
+
+  gohat query dumpfile.dump 'SELECT addr, size FROM "map.bucket*" WHERE size > 1024 AND referrers.count == 1 ORDER BY retained DESC LIMIT 20'
+
+Attributes are addr, type, kind, size, retained, dominator, reachable, path, root, depth,
+referrers, referrers.count, children, children.count, fields.count and field[offset].
+Conditions use ==, !=, <, <=, >, >=, =~ (regex), LIKE (glob), AND, OR and NOT.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			heapFile := verifyHeapDumpFile(args)
+
+			if len(args) < 2 {
+				fmt.Println("query <heap file> <query>")
+				exit(1)
+			}
+
+			result, err := heapquery.Run(heapFile, strings.Join(args[1:], " "))
+			if err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
+			}
+			structuredOutput()
+			writeQueryResult(result)
+		},
+	}
+	gohatCmd.AddCommand(queryCommand)
+
+	var trendJSON bool
+	var trendLimit int
+	var trendCommand = &cobra.Command{
+		Use:   "trend",
+		Short: "Find types and allocation sites that grow across a series of heap files",
+		Run: func(cmd *cobra.Command, args []string) {
+			checkOutputFormat("trend", "json")
+			heapFiles := verifyHeapDumpFiles(args)
+
+			if len(heapFiles) < 2 {
+				fmt.Println("trend <heap file> <heap file> [<heap file> ...]")
+				exit(1)
+			}
+
+			report := heapfile.Trends(heapFiles)
+			if trendJSON || outputFormat == "json" {
+				out, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					fmt.Println("Error:", err)
+					exit(1)
+				}
+				fmt.Println(string(out))
+				return
+			}
+			displayTrends(report, trendLimit)
+		},
+	}
+	trendCommand.Flags().BoolVarP(&trendJSON, "json", "j", false, "Output the full report as JSON")
+	trendCommand.Flags().IntVarP(&trendLimit, "limit", "l", 20, "Maximum number of entries per section (0 for all)")
+	gohatCmd.AddCommand(trendCommand)
+
+	var runJSONLines bool
+	var runCommand = &cobra.Command{
+		Use:   "run",
+		Short: "Run a script of commands against one parse of a heap file",
+		Long: `Reads commands, one per line, from the script file or from stdin if it is
+missing or -, and runs each against the heap file, which is parsed only once. Lines are
+written as they would be on the command line without "gohat" and the heap file, starting
+with the command, e.g.
+
+  objects --type 'map.*' --top 10
+  memstats --output json
+  query 'SELECT addr FROM string WHERE size > 1024'
+
+Each command's output follows a "==> command" line, or with --json-lines each command is
+a line of JSON with its status and output. Exits with status 1 if any command failed.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkOutputFormat("run")
+			if len(args) < 1 || len(args) > 2 {
+				fmt.Println("run <heap file> [script file]")
+				exit(1)
+			}
+			verifyHeapDumpFile(args)
+
+			script := os.Stdin
+			if len(args) == 2 && args[1] != "-" {
+				f, err := os.Open(args[1])
+				if err != nil {
+					fmt.Println("Error:", err)
+					exit(1)
+				}
+				defer f.Close()
+				script = f
+			}
+
+			failed, err := runScript(args[0], script, runJSONLines)
+			if err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
+			}
+			if failed > 0 {
+				exit(1)
+			}
+		},
+	}
+	runCommand.Flags().BoolVarP(&runJSONLines, "json-lines", "j", false, "Write each command's result as a line of JSON")
+	gohatCmd.AddCommand(runCommand)
 
 	var frameChildren bool
+	var searchMode string
+	var searchLimit int
+	var searchCommand = &cobra.Command{
+		Use:   "search",
+		Short: "Find objects, stack frames and segments containing a value",
+		Run: func(cmd *cobra.Command, args []string) {
+			heapFile := verifyHeapDumpFile(args)
+
+			if len(args) != 2 {
+				fmt.Println("search <heap file> <pattern>")
+				exit(1)
+			}
+
+			matcher, err := newMatcher(heapFile, args[1], searchMode)
+			if err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
+			}
+
+			hits := heapFile.Search(matcher, searchLimit)
+			if structuredOutput() {
+				records := make([]*searchRecord, 0, len(hits))
+				for _, hit := range hits {
+					records = append(records, &searchRecord{hexUint(hit.Address), hexUint(hit.Base()), hit.Offset, hit.Location()})
+				}
+				writeOutput(records)
+				return
+			}
+
+			fmt.Printf("Found %d matches\n", len(hits))
+			for _, hit := range hits {
+				fmt.Printf("0x%x 0x%x+0x%x %s\n", hit.Address, hit.Base(), hit.Offset, hit.Location())
+			}
+		},
+	}
+	searchCommand.Flags().StringVarP(&searchMode, "mode", "m", "string", "Pattern type: string, hex, regex, int or ptr")
+	searchCommand.Flags().IntVarP(&searchLimit, "limit", "l", 0, "Maximum number of matches (0 for all)")
+	gohatCmd.AddCommand(searchCommand)
+
+	var secretPatterns []string
+	var secretsShow bool
+	var secretsCommand = &cobra.Command{
+		Use:   "secrets",
+		Short: "Scan objects for credentials and other sensitive data",
+		Run: func(cmd *cobra.Command, args []string) {
+			heapFile := verifyHeapDumpFile(args)
+
+			patterns := append([]*heapfile.SecretPattern(nil), heapfile.DefaultSecretPatterns...)
+			for _, p := range secretPatterns {
+				pattern, err := parseSecretPattern(p)
+				if err != nil {
+					fmt.Println("Error:", err)
+					exit(1)
+				}
+				patterns = append(patterns, pattern)
+			}
+
+			secrets := heapFile.Secrets(patterns)
+			if structuredOutput() {
+				records := make([]*secretRecord, 0, len(secrets))
+				for _, secret := range secrets {
+					match := secret.Redacted()
+					if secretsShow {
+						match = secret.Match
+					}
+					path := "unreachable"
+					if p := heapFile.RootPath(secret.Object); p != nil {
+						path = p.String()
+					}
+					records = append(records, &secretRecord{secret.Pattern.Name, hexUint(secret.Object.Address), secret.Offset,
+						secret.Object.Name(), match, len(secret.Match), path})
+				}
+				writeOutput(records)
+				return
+			}
+
+			fmt.Printf("Found %d possible secrets\n", len(secrets))
+			for _, secret := range secrets {
+				match := secret.Redacted()
+				if secretsShow {
+					match = secret.Match
+				}
+				fmt.Printf("\n%s 0x%x+0x%x %s %s (%d bytes)\n", secret.Pattern.Name, secret.Object.Address, secret.Offset,
+					secret.Object.Name(), quoteShort(match), len(secret.Match))
+				if path := heapFile.RootPath(secret.Object); path != nil {
+					fmt.Printf("\t%s\n", path)
+				} else {
+					fmt.Println("\tunreachable")
+				}
+			}
+		},
+	}
+	secretsCommand.Flags().StringArrayVarP(&secretPatterns, "pattern", "p", nil, "Additional pattern as name=regex (may be repeated)")
+	secretsCommand.Flags().BoolVarP(&secretsShow, "show", "s", false, "Show the matched text instead of redacting it")
+	gohatCmd.AddCommand(secretsCommand)
+
+	var shellCommand = &cobra.Command{
+		Use:   "shell",
+		Short: "Explore a heap file interactively",
+		Long: `Parses the heap file once and reads commands to explore it: object, ls, cd, back,
+type, referrers, path, goroutine and histogram. Tab completes commands, addresses and type
+names, and history is kept in ~/.gohat_history. Type help for the list of commands.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkOutputFormat("shell")
+			heapFile := verifyHeapDumpFile(args)
+
+			heapFile.Objects() // parse before the first prompt
+			if err := newShell(heapFile).Run(); err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
+			}
+		},
+	}
+	gohatCmd.AddCommand(shellCommand)
+
+	var stackFramesList listFlags
 	var stackFramesCommand = &cobra.Command{
 		Use:   "stackframes",
 		Short: "Dump the stack frames",
 		Run: func(cmd *cobra.Command, args []string) {
 			heapFile := verifyHeapDumpFile(args)
 
-			for _, frame := range heapFile.StackFrames() {
-				fmt.Printf("%x %s\n", frame.StackPointer, frame.Name)
+			entries := stackFramesList.options().Apply(heapfile.FrameEntries(heapFile.StackFrames()))
+			frames := make([]*heapfile.StackFrame, 0, len(entries))
+			for _, e := range entries {
+				frames = append(frames, e.Value.(*heapfile.StackFrame))
+			}
+
+			if structuredOutput() {
+				records := make([]*frameRecord, 0)
+				for _, frame := range frames {
+					records = append(records, &frameRecord{hexUint(frame.StackPointer), frame.Name, frame.DepthInStack,
+						hexUint(frame.ChildFramePointer), hexUint(frame.EntryPC), hexUint(frame.CurrentPC),
+						hexUint(frame.ContinuationPC), len(frame.Content), len(frame.Objects())})
+				}
+				writeOutput(records)
+				return
+			}
+
+			for _, frame := range frames {
+				fmt.Printf("0x%x %s\n", frame.StackPointer, frame.Name)
 				if frameChildren {
 					for _, object := range frame.Objects() {
 						fmt.Print("\t")
@@ -505,30 +1314,125 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 		},
 	}
 	stackFramesCommand.Flags().BoolVarP(&frameChildren, "children", "c", false, "Show the children of the stack frames")
+	stackFramesList.register(stackFramesCommand, false)
 	gohatCmd.AddCommand(stackFramesCommand)
 
+	var dupStringsMinWaste uint64
+	var dupStringsCommand = &cobra.Command{
+		Use:   "dupstrings",
+		Short: "Find strings whose contents are duplicated on the heap",
+		Run: func(cmd *cobra.Command, args []string) {
+			heapFile := verifyHeapDumpFile(args)
+
+			dups := heapFile.DuplicateStrings(dupStringsMinWaste)
+			if structuredOutput() {
+				records := make([]*dupStringRecord, 0, len(dups))
+				for _, dup := range dups {
+					records = append(records, &dupStringRecord{dup.Text, dup.Count, dup.WastedBytes, objectAddresses(dup.Referrers)})
+				}
+				writeOutput(records)
+				return
+			}
+
+			total := uint64(0)
+			for _, dup := range dups {
+				total += dup.WastedBytes
+			}
+			fmt.Printf("Found %d duplicated strings wasting %d bytes\n", len(dups), total)
+
+			for _, dup := range dups {
+				fmt.Printf("\n%d copies wasting %d bytes: %s\n", dup.Count, dup.WastedBytes, quoteShort(dup.Text))
+				for _, referrer := range dup.Referrers {
+					fmt.Print("\t")
+					displayObjectShort(referrer)
+				}
+			}
+		},
+	}
+	dupStringsCommand.Flags().Uint64VarP(&dupStringsMinWaste, "min-waste", "m", 0, "Minimum wasted bytes to report")
+	gohatCmd.AddCommand(dupStringsCommand)
+
+	var duplicatesIgnorePointers bool
+	var duplicatesMinWaste uint64
+	var duplicatesCommand = &cobra.Command{
+		Use:   "duplicates",
+		Short: "Find objects of the same type with identical contents",
+		Run: func(cmd *cobra.Command, args []string) {
+			heapFile := verifyHeapDumpFile(args)
+
+			dups := heapFile.DuplicateObjects(duplicatesIgnorePointers, duplicatesMinWaste)
+			if structuredOutput() {
+				records := make([]*duplicatesRecord, 0, len(dups))
+				for _, dup := range dups {
+					typeName := "unknown"
+					if dup.Type != nil {
+						typeName = dup.Type.Name
+					}
+					records = append(records, &duplicatesRecord{typeName, dup.Size, len(dup.Objects), dup.WastedBytes,
+						objectAddresses(dup.Objects)})
+				}
+				writeOutput(records)
+				return
+			}
+
+			total := uint64(0)
+			for _, dup := range dups {
+				total += dup.WastedBytes
+			}
+			fmt.Printf("Found %d clusters of duplicate objects wasting %d bytes\n", len(dups), total)
+
+			for _, dup := range dups {
+				typeName := "unknown"
+				if dup.Type != nil {
+					typeName = dup.Type.Name
+				}
+				fmt.Printf("\n%d copies of %s (%d bytes) wasting %d bytes\n", len(dup.Objects), typeName, dup.Size, dup.WastedBytes)
+				for i, object := range dup.Objects {
+					if i == 5 {
+						fmt.Printf("\t... %d more\n", len(dup.Objects)-i)
+						break
+					}
+					fmt.Printf("\t0x%x\n", object.Address)
+				}
+			}
+		},
+	}
+	duplicatesCommand.Flags().BoolVarP(&duplicatesIgnorePointers, "ignore-pointers", "i", false, "Ignore pointer fields when comparing objects")
+	duplicatesCommand.Flags().Uint64VarP(&duplicatesMinWaste, "min-waste", "m", 0, "Minimum wasted bytes to report")
+	gohatCmd.AddCommand(duplicatesCommand)
+
+	var garbageList listFlags
 	var garbageCommand = &cobra.Command{
 		Use:   "garbage",
 		Short: "Dump unreachable objects",
 		Run: func(cmd *cobra.Command, args []string) {
 			heapFile := verifyHeapDumpFile(args)
 
-			trash := heapFile.Garbage()
+			trash := filterObjects(heapFile.Garbage(), garbageList.options())
+			if structuredOutput() {
+				writeOutput(newObjectRecords(trash))
+				return
+			}
+
 			fmt.Printf("Found %d unreachable objects\n", len(trash))
 			for _, object := range trash {
 				displayObjectShort(object)
 			}
 		},
 	}
+	garbageList.register(garbageCommand, true)
 	gohatCmd.AddCommand(garbageCommand)
 
 	var serverAddress string
 	var serverCommand = &cobra.Command{
 		Use:   "server",
 		Short: "run the web interface",
+		Long: `Serves the first heap file. When more heap files are given, in the order they
+were taken, the trend page charts the growth of types and allocation sites across them.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			heapFile := verifyHeapDumpFile(args)
-			s := newGohatServer(serverAddress, heapFile)
+			checkOutputFormat("server")
+			heapFiles := verifyHeapDumpFiles(args)
+			s := newGohatServer(serverAddress, heapFiles)
 			s.Run()
 		},
 	}
@@ -539,17 +1443,22 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 		Use:   "type",
 		Short: "Dump information about a type",
 		Run: func(cmd *cobra.Command, args []string) {
+			checkOutputFormat("type")
 			heapFile := verifyHeapDumpFile(args)
 
 			if len(args) != 2 {
 				fmt.Println("type <heap file> <address>")
-				os.Exit(1)
+				exit(1)
 			}
 
-			addr, _ := strconv.ParseUint(args[1], 16, 64)
+			addr, err := parseAddress(args[1])
+			if err != nil {
+				fmt.Println("Error:", err)
+				exit(1)
+			}
 
 			t := heapFile.Type(addr)
-			fmt.Printf("%x %d %s\n", t.Address, len(t.FieldList), t.Name)
+			fmt.Printf("0x%x %d %s\n", t.Address, len(t.FieldList), t.Name)
 			for _, field := range t.FieldList {
 				fmt.Printf("%s 0x%0.4x\n", field.KindString(), field.Offset)
 			}
@@ -557,76 +1466,89 @@ Complete documentation is available at http://github.com/rubyist/gohat`,
 	}
 	gohatCmd.AddCommand(typeCommand)
 
+	var typesList listFlags
 	var typesCommand = &cobra.Command{
 		Use:   "types",
 		Short: "Dump all the types found in the heap",
 		Run: func(cmd *cobra.Command, args []string) {
 			heapFile := verifyHeapDumpFile(args)
 
-			types := heapFile.Types()
+			entries := typesList.options().Apply(heapFile.TypeEntries())
+			types := make([]*heapfile.Type, 0, len(entries))
+			for _, e := range entries {
+				types = append(types, e.Value.(*heapfile.Type))
+			}
+			if structuredOutput() {
+				records := make([]*typeRecord, 0, len(types))
+				for _, t := range types {
+					records = append(records, &typeRecord{hexUint(t.Address), t.Name, t.Size, len(t.FieldList)})
+				}
+				writeOutput(records)
+				return
+			}
+
 			for _, t := range types {
-				fmt.Printf("%x %d %s\n", t.Address, len(t.FieldList), t.Name)
+				fmt.Printf("0x%x %d %s\n", t.Address, len(t.FieldList), t.Name)
 			}
 		},
 	}
+	typesList.register(typesCommand, false)
 	gohatCmd.AddCommand(typesCommand)
 
-	gohatCmd.Execute()
+	return gohatCmd
+}
+
+// Called to exit with an error. The script mode replaces it so that a failing command
+// doesn't end the script.
+var exit = os.Exit
+
+// Heap files opened so far by path, so that commands run by one process share a parse
+var openHeapFiles = make(map[string]*heapfile.HeapFile, 0)
+
+func openHeapFile(path string) (*heapfile.HeapFile, error) {
+	if heapFile, ok := openHeapFiles[path]; ok {
+		return heapFile, nil
+	}
+	heapFile, err := heapfile.New(path)
+	if err != nil {
+		return nil, err
+	}
+	// Without types most commands only show unknown objects, so say why
+	if err := heapFile.TypeError(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", path, err)
+	} else if err := heapFile.MetadataError(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring the metadata, %s\n", err)
+	}
+	openHeapFiles[path] = heapFile
+	return heapFile, nil
 }
 
 func verifyHeapDumpFile(args []string) *heapfile.HeapFile {
 	if len(args) < 1 {
 		fmt.Println("heap file required")
-		os.Exit(1)
+		exit(1)
 	}
-	heapFile, err := heapfile.New(args[0])
+	heapFile, err := openHeapFile(args[0])
 	if err != nil {
 		fmt.Println("Error:", err)
-		os.Exit(1)
+		exit(1)
 	}
 	return heapFile
 }
 
-func derefToString(b []byte, heapFile *heapfile.HeapFile) string {
-	var len int64
-	var addr int64
-	buf := bytes.NewReader(b[8:])
-	binary.Read(buf, binary.LittleEndian, &len)
-	buf = bytes.NewReader(b[:8])
-	binary.Read(buf, binary.LittleEndian, &addr)
-	if obj := heapFile.Object(uint64(addr)); obj != nil {
-		return obj.Content
-	}
-	return ""
-}
-
-type HistSorter struct {
-	Keys []string
-	Vals []int
-}
-
-func NewHistSorter(m map[string]int) *HistSorter {
-	hs := &HistSorter{make([]string, 0, len(m)), make([]int, 0, len(m))}
-	for k, v := range m {
-		hs.Keys = append(hs.Keys, k)
-		hs.Vals = append(hs.Vals, v)
+func verifyHeapDumpFiles(args []string) []*heapfile.HeapFile {
+	if len(args) < 1 {
+		fmt.Println("heap file required")
+		exit(1)
 	}
-	return hs
-}
-
-func (hs *HistSorter) Sort() {
-	sort.Sort(hs)
-}
-
-func (hs *HistSorter) Len() int           { return len(hs.Vals) }
-func (hs *HistSorter) Less(i, j int) bool { return hs.Vals[i] < hs.Vals[j] }
-func (hs *HistSorter) Swap(i, j int) {
-	hs.Vals[i], hs.Vals[j] = hs.Vals[j], hs.Vals[i]
-	hs.Keys[i], hs.Keys[j] = hs.Keys[j], hs.Keys[i]
+	heapFiles := make([]*heapfile.HeapFile, 0, len(args))
+	for _, arg := range args {
+		heapFile, err := openHeapFile(arg)
+		if err != nil {
+			fmt.Println("Error:", err)
+			exit(1)
+		}
+		heapFiles = append(heapFiles, heapFile)
+	}
+	return heapFiles
 }
-
-type uint64arr []uint64
-
-func (a uint64arr) Len() int           { return len(a) }
-func (a uint64arr) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a uint64arr) Less(i, j int) bool { return a[i] < a[j] }
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/rubyist/gohat/pkg/heapfile"
+	"github.com/rubyist/gohat/pkg/heapquery"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Format for commands with structured output, set by the global --output flag. Empty for
+// each command's usual text output.
+var outputFormat string
+
+var outputFormats = []string{"json", "csv", "table"}
+
+// Returns true if structured output was requested. Exits if the format is unknown.
+func structuredOutput() bool {
+	if outputFormat == "" {
+		return false
+	}
+	for _, format := range outputFormats {
+		if outputFormat == format {
+			return true
+		}
+	}
+	fmt.Printf("Unknown output format %q, expected one of %s\n", outputFormat, strings.Join(outputFormats, ", "))
+	exit(1)
+	return false
+}
+
+// Exits with an error if --output was given in a format the command doesn't support. With no
+// formats the command only has text output.
+func checkOutputFormat(command string, formats ...string) {
+	if outputFormat == "" {
+		return
+	}
+	for _, format := range formats {
+		if outputFormat == format {
+			return
+		}
+	}
+	fmt.Printf("Error: %s doesn't support --output %s\n", command, outputFormat)
+	exit(1)
+}
+
+// A uint64 shown in 0x prefixed hex, as addresses are in the text output
+type hexUint uint64
+
+func (h hexUint) String() string {
+	return fmt.Sprintf("0x%x", uint64(h))
+}
+
+func (h hexUint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.String())
+}
+
+// Writes data, a struct or a slice of structs or struct pointers, in the --output format. JSON is written as
+// an object or an array of objects. CSV and tables have a header row of the JSON field names
+// and a row for each struct; string slices are joined with semicolons.
+func writeOutput(data interface{}) {
+	if outputFormat == "json" {
+		out, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			fmt.Println("Error:", err)
+			exit(1)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	v := reflect.ValueOf(data)
+	rows := make([]reflect.Value, 0)
+	t := v.Type()
+	if v.Kind() == reflect.Slice {
+		t = t.Elem()
+		for i := 0; i < v.Len(); i++ {
+			rows = append(rows, reflect.Indirect(v.Index(i)))
+		}
+	} else {
+		rows = append(rows, reflect.Indirect(v))
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	header := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		header = append(header, strings.Split(t.Field(i).Tag.Get("json"), ",")[0])
+	}
+	records := [][]string{header}
+	for _, row := range rows {
+		record := make([]string, 0, len(header))
+		for i := 0; i < row.NumField(); i++ {
+			field := row.Field(i)
+			if values, ok := field.Interface().([]string); ok {
+				record = append(record, strings.Join(values, ";"))
+			} else {
+				record = append(record, fmt.Sprint(field.Interface()))
+			}
+		}
+		records = append(records, record)
+	}
+	writeRecords(records)
+}
+
+// Writes records, the first of which is the header, as CSV or a table
+func writeRecords(records [][]string) {
+	if outputFormat == "csv" {
+		w := csv.NewWriter(os.Stdout)
+		w.WriteAll(records)
+		if err := w.Error(); err != nil {
+			fmt.Println("Error:", err)
+			exit(1)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, record := range records {
+		fmt.Fprintln(w, strings.Join(record, "\t"))
+	}
+	w.Flush()
+}
+
+// Writes a query result in the --output format, as a table by default. In JSON each row is
+// an object keyed by column, with addresses in hex.
+func writeQueryResult(result *heapquery.Result) {
+	if outputFormat == "json" {
+		rows := make([]map[string]interface{}, 0, len(result.Rows))
+		for _, row := range result.Rows {
+			values := make(map[string]interface{}, len(result.Columns))
+			for i, column := range result.Columns {
+				switch v := row.Values[i].(type) {
+				case heapquery.Address:
+					values[column] = hexUint(v)
+				case []*heapfile.Object:
+					addresses := make([]hexUint, 0, len(v))
+					for _, object := range v {
+						addresses = append(addresses, hexUint(object.Address))
+					}
+					values[column] = addresses
+				default:
+					values[column] = v
+				}
+			}
+			rows = append(rows, values)
+		}
+		out, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			fmt.Println("Error:", err)
+			exit(1)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	records := [][]string{result.Columns}
+	for _, row := range result.Rows {
+		record := make([]string, 0, len(row.Values))
+		for _, v := range row.Values {
+			record = append(record, heapquery.FormatValue(v))
+		}
+		records = append(records, record)
+	}
+	writeRecords(records)
+}
+
+type objectRecord struct {
+	Address hexUint `json:"address"`
+	Type    string  `json:"type"`
+	Kind    string  `json:"kind"`
+	Size    int     `json:"size"`
+}
+
+type typeRecord struct {
+	Address hexUint `json:"address"`
+	Name    string  `json:"name"`
+	Size    uint64  `json:"size"`
+	Fields  int     `json:"fields"`
+}
+
+type goroutineRecord struct {
+	Id           uint64  `json:"id"`
+	Address      hexUint `json:"address"`
+	Top          hexUint `json:"top"`
+	Location     hexUint `json:"location"`
+	Status       string  `json:"status"`
+	Reason       string  `json:"reason"`
+	LastWaiting  uint64  `json:"last_waiting"`
+	CurrentFrame hexUint `json:"current_frame"`
+	OSThread     uint64  `json:"os_thread"`
+	DeferRecord  hexUint `json:"defer_record"`
+	PanicRecord  hexUint `json:"panic_record"`
+	System       bool    `json:"system"`
+	Background   bool    `json:"background"`
+}
+
+type frameRecord struct {
+	StackPointer   hexUint `json:"stack_pointer"`
+	Name           string  `json:"name"`
+	Depth          uint64  `json:"depth"`
+	Child          hexUint `json:"child"`
+	EntryPC        hexUint `json:"entry_pc"`
+	CurrentPC      hexUint `json:"current_pc"`
+	ContinuationPC hexUint `json:"continuation_pc"`
+	Size           int     `json:"size"`
+	Objects        int     `json:"objects"`
+}
+
+type rootRecord struct {
+	Pointer     hexUint `json:"pointer"`
+	Description string  `json:"description"`
+}
+
+type memStatRecord struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
+type paramsRecord struct {
+	Format       string  `json:"format"`
+	BigEndian    bool    `json:"big_endian"`
+	PtrSize      uint64  `json:"ptr_size"`
+	ChHdrSize    uint64  `json:"chan_header_size"`
+	StartAddress hexUint `json:"start_address"`
+	EndAddress   hexUint `json:"end_address"`
+	Arch         uint64  `json:"arch"`
+	GoExperiment string  `json:"goexperiment"`
+	GoArch       string  `json:"goarch"`
+	Runtime      string  `json:"runtime_version"`
+	NCPU         uint64  `json:"ncpu"`
+
+	// From the metadata sidecar, empty if there is none
+	Binary      string   `json:"binary"`
+	BuildID     string   `json:"build_id"`
+	GoVersion   string   `json:"go_version"`
+	MainModule  string   `json:"main_module"`
+	Hostname    string   `json:"hostname"`
+	Time        string   `json:"time"`
+	Reason      string   `json:"reason"`
+	CommandLine []string `json:"command_line"`
+	Modules     []string `json:"modules"`
+}
+
+type allocRecord struct {
+	Object hexUint  `json:"object"`
+	Type   string   `json:"type"`
+	Record hexUint  `json:"record"`
+	Size   uint64   `json:"size"`
+	Allocs uint64   `json:"allocs"`
+	Frees  uint64   `json:"frees"`
+	Stack  []string `json:"stack"`
+}
+
+type allocSiteRecord struct {
+	Function  string   `json:"function"`
+	Objects   int      `json:"objects"`
+	Bytes     int      `json:"bytes"`
+	Allocs    uint64   `json:"allocs"`
+	Frees     uint64   `json:"frees"`
+	LiveRatio float64  `json:"live_ratio"`
+	Types     []string `json:"types"`
+	Stack     []string `json:"stack"`
+}
+
+type profileRecord struct {
+	Record hexUint  `json:"record"`
+	Size   uint64   `json:"size"`
+	Allocs uint64   `json:"allocs"`
+	Frees  uint64   `json:"frees"`
+	Stack  []string `json:"stack"`
+}
+
+type leakRecord struct {
+	Goroutine uint64   `json:"goroutine"`
+	Location  hexUint  `json:"location"`
+	Function  string   `json:"function"`
+	Reason    string   `json:"reason"`
+	Waiting   string   `json:"waiting"`
+	BlockedOn []string `json:"blocked_on"`
+}
+
+type mapRecord struct {
+	Address    hexUint `json:"address"`
+	Type       string  `json:"type"`
+	Count      uint64  `json:"count"`
+	Buckets    uint64  `json:"buckets"`
+	Overflow   uint64  `json:"overflow"`
+	LoadFactor float64 `json:"load_factor"`
+	Wasted     uint64  `json:"wasted"`
+}
+
+type channelRecord struct {
+	Address   hexUint `json:"address"`
+	Type      string  `json:"type"`
+	Count     uint64  `json:"count"`
+	Capacity  uint64  `json:"capacity"`
+	Buffer    uint64  `json:"buffer"`
+	Fill      float64 `json:"fill"`
+	Receivers int     `json:"receivers"`
+	Senders   int     `json:"senders"`
+	Closed    bool    `json:"closed"`
+}
+
+type searchRecord struct {
+	Address  hexUint `json:"address"`
+	Base     hexUint `json:"base"`
+	Offset   uint64  `json:"offset"`
+	Location string  `json:"location"`
+}
+
+type secretRecord struct {
+	Pattern string  `json:"pattern"`
+	Object  hexUint `json:"object"`
+	Offset  uint64  `json:"offset"`
+	Type    string  `json:"type"`
+	Match   string  `json:"match"`
+	Length  int     `json:"length"`
+	Path    string  `json:"path"`
+}
+
+type dupStringRecord struct {
+	Text      string   `json:"text"`
+	Count     int      `json:"count"`
+	Wasted    uint64   `json:"wasted"`
+	Referrers []string `json:"referrers"`
+}
+
+type duplicatesRecord struct {
+	Type    string   `json:"type"`
+	Size    int      `json:"size"`
+	Copies  int      `json:"copies"`
+	Wasted  uint64   `json:"wasted"`
+	Objects []string `json:"objects"`
+}
+
+type checkRecord struct {
+	Rule   string  `json:"rule"`
+	Limit  float64 `json:"limit"`
+	Actual float64 `json:"actual"`
+	Passed bool    `json:"passed"`
+	Detail string  `json:"detail"`
+}
+
+type fragmentRecord struct {
+	Start hexUint `json:"start"`
+	End   hexUint `json:"end"`
+	Size  uint64  `json:"size"`
+}
+
+type histogramRecord struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+	Bytes int    `json:"bytes"`
+}
+
+func newObjectRecords(objects []*heapfile.Object) []*objectRecord {
+	records := make([]*objectRecord, 0, len(objects))
+	for _, object := range objects {
+		records = append(records, &objectRecord{hexUint(object.Address), object.Name(), object.Kind(), object.Size})
+	}
+	return records
+}
+
+func newParamsRecord(format string, dumpParams *heapfile.DumpParams, metadata *heapfile.Metadata) *paramsRecord {
+	record := &paramsRecord{Format: format, BigEndian: dumpParams.BigEndian, PtrSize: dumpParams.PtrSize, ChHdrSize: dumpParams.ChHdrSize,
+		StartAddress: hexUint(dumpParams.StartAddress), EndAddress: hexUint(dumpParams.EndAddress), Arch: dumpParams.Arch,
+		GoExperiment: dumpParams.GoExperiment, GoArch: dumpParams.GoArch, Runtime: dumpParams.GoVersion, NCPU: dumpParams.NCPU}
+	if metadata == nil {
+		return record
+	}
+
+	record.Binary = metadata.Binary
+	record.BuildID = metadata.BuildID
+	record.GoVersion = metadata.GoVersion
+	record.MainModule = moduleString(metadata.Main)
+	record.Hostname = metadata.Hostname
+	record.Time = metadata.Time.Format(time.RFC3339)
+	record.Reason = metadata.Reason
+	record.CommandLine = metadata.CommandLine
+	for _, module := range metadata.Modules {
+		record.Modules = append(record.Modules, moduleString(module))
+	}
+	return record
+}
+
+// Formats a module as path@version
+func moduleString(module *heapfile.Module) string {
+	if module == nil {
+		return ""
+	}
+	return module.Path + "@" + module.Version
+}
+
+func newCheckRecords(results []*heapfile.CheckResult) []*checkRecord {
+	records := make([]*checkRecord, 0, len(results))
+	for _, result := range results {
+		records = append(records, &checkRecord{result.Rule, result.Limit, result.Actual, result.Passed, result.Detail})
+	}
+	return records
+}
+
+// Lists the statistics of memstats that are recorded in heap dumps
+func newMemStatRecords(memstats *runtime.MemStats) []*memStatRecord {
+	records := make([]*memStatRecord, 0, len(heapfile.MemStatsFields))
+	v := reflect.Indirect(reflect.ValueOf(memstats))
+	for _, name := range heapfile.MemStatsFields {
+		records = append(records, &memStatRecord{name, v.FieldByName(name).Uint()})
+	}
+	return records
+}
+
+// Formats the addresses of objects in hex
+func objectAddresses(objects []*heapfile.Object) []string {
+	addresses := make([]string, 0, len(objects))
+	for _, object := range objects {
+		addresses = append(addresses, hexUint(object.Address).String())
+	}
+	return addresses
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Commands a script can't run, because they are interactive or don't return
+var unscriptableCommands = map[string]bool{"run": true, "shell": true, "server": true}
+
+// The result of one line of a script, written as a JSON line
+type scriptResult struct {
+	Command string      `json:"command"`
+	Status  int         `json:"status"`
+	Output  interface{} `json:"output"` // the command's JSON output, or its text output as a string
+}
+
+// Passed to panic by exit while a script is running
+type scriptExit int
+
+// Runs each line of the script as a gohat command against the heap file, which is inserted
+// as the first argument after the command's name. Blank lines and lines starting with # are
+// skipped. Returns the number of commands that failed.
+func runScript(heapPath string, script io.Reader, jsonLines bool) (int, error) {
+	failed := 0
+	scanner := bufio.NewScanner(script)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		words, err := splitCommandLine(line)
+		if err != nil {
+			return failed, err
+		}
+
+		var status int
+		var output string
+		if jsonLines {
+			output, status = captureStdout(func() int { return runScriptCommand(heapPath, words) })
+		} else {
+			fmt.Printf("==> %s\n", line)
+			status = runScriptCommand(heapPath, words)
+		}
+		if status != 0 {
+			failed++
+		}
+
+		if jsonLines {
+			result := &scriptResult{Command: line, Status: status, Output: output}
+			if trimmed := bytes.TrimSpace([]byte(output)); len(trimmed) > 0 && json.Valid(trimmed) {
+				result.Output = json.RawMessage(trimmed)
+			}
+			out, err := json.Marshal(result)
+			if err != nil {
+				return failed, err
+			}
+			fmt.Println(string(out))
+		}
+	}
+	return failed, scanner.Err()
+}
+
+// Runs one command with a fresh command tree, returning its exit status
+func runScriptCommand(heapPath string, words []string) (status int) {
+	if len(words) > 0 && strings.HasPrefix(words[0], "-") {
+		fmt.Printf("Error: flags go after the command, found %s first\n", words[0])
+		return 1
+	}
+	if len(words) > 0 && unscriptableCommands[words[0]] {
+		fmt.Printf("Error: %s can't be run from a script\n", words[0])
+		return 1
+	}
+
+	root := newGohatCommand()
+
+	// The heap file goes after the command and any subcommands
+	cmd, i := root, 0
+	for ; i < len(words); i++ {
+		var next = cmd
+		for _, c := range cmd.Commands() {
+			if c.Name() == words[i] {
+				next = c
+			}
+		}
+		if next == cmd {
+			break
+		}
+		cmd = next
+	}
+	args := append(append(append([]string{}, words[:i]...), heapPath), words[i:]...)
+
+	defer func() {
+		if r := recover(); r != nil {
+			code, ok := r.(scriptExit)
+			if !ok {
+				panic(r)
+			}
+			status = int(code)
+		}
+	}()
+	exit = func(code int) { panic(scriptExit(code)) }
+	defer func() { exit = os.Exit }()
+
+	root.SetArgs(args)
+	if err := root.Execute(); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// Calls fn with stdout redirected, returning what it wrote and fn's result. Stdout is
+// restored even if fn panics.
+func captureStdout(fn func() int) (output string, status int) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err.Error(), fn()
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	written := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		written <- buf.String()
+	}()
+	defer func() {
+		os.Stdout = stdout
+		w.Close()
+		output = <-written
+	}()
+
+	return "", fn()
+}
+
+// Splits a line into words at spaces. Single and double quotes group words, and a
+// backslash escapes the next character outside single quotes.
+func splitCommandLine(line string) ([]string, error) {
+	words := make([]string, 0)
+	var word bytes.Buffer
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", line)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
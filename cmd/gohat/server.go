@@ -1,21 +1,34 @@
 package main
 
 import (
+	"fmt"
 	"github.com/rubyist/gohat/pkg/heapfile"
+	"github.com/rubyist/gohat/pkg/heapquery"
+	"html"
 	"html/template"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 )
 
+// Most pointers the object page follows when decoding a value
+const maxObjectDepth = 8
+
 type gohatServer struct {
-	heapFile *heapfile.HeapFile
-	address  string
+	heapFile  *heapfile.HeapFile
+	snapshots []*heapfile.HeapFile // all of the heap files, in the order they were taken
+	address   string
+
+	trendOnce sync.Once
+	trends    *heapfile.TrendReport // computed on the first request for the trend page
 }
 
-func newGohatServer(address string, heapFile *heapfile.HeapFile) *gohatServer {
+func newGohatServer(address string, heapFiles []*heapfile.HeapFile) *gohatServer {
 
-	return &gohatServer{heapFile, address}
+	return &gohatServer{heapFile: heapFiles[0], snapshots: heapFiles, address: address}
 }
 
 func (s *gohatServer) Run() {
@@ -25,6 +38,13 @@ func (s *gohatServer) Run() {
 	http.HandleFunc("/roots", s.rootsPage)
 	http.HandleFunc("/garbage", s.garbagePage)
 	http.HandleFunc("/frame", s.framePage)
+	http.HandleFunc("/maps", s.mapsPage)
+	http.HandleFunc("/dupstrings", s.dupStringsPage)
+	http.HandleFunc("/search", s.searchPage)
+	http.HandleFunc("/trend", s.trendPage)
+	http.HandleFunc("/allocs", s.allocsPage)
+	http.HandleFunc("/calltree", s.callTreePage)
+	http.HandleFunc("/query", s.queryPage)
 
 	log.Printf("Serving %s on %s", s.heapFile.Name, s.address)
 	log.Fatal(http.ListenAndServe(s.address, nil))
@@ -42,7 +62,7 @@ func (s *gohatServer) mainPage(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *gohatServer) objectsPage(w http.ResponseWriter, r *http.Request) {
-	render(w, objectsTemplate, s.heapFile)
+	render(w, objectsTemplate, s.objectList(r, s.heapFile.Objects()))
 	log.Printf("[200] %s", r.URL)
 }
 
@@ -62,25 +82,180 @@ func (s *gohatServer) objectPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	depth, err := strconv.Atoi(r.URL.Query().Get("depth"))
+	if err != nil || depth < 0 {
+		depth = 1
+	}
+	if depth > maxObjectDepth {
+		depth = maxObjectDepth
+	}
+
 	data := map[string]interface{}{
-		"Name":   s.heapFile.Name,
-		"Object": object,
+		"Name":     s.heapFile.Name,
+		"Object":   object,
+		"Value":    s.heapFile.DecodeObject(object, depth),
+		"Depth":    depth,
+		"MaxDepth": maxObjectDepth,
+		"Map":      s.heapFile.Map(object, 0),
+		"Channel":  s.heapFile.Channel(object, 0),
 	}
 
 	render(w, objectTemplate, data)
 	log.Printf("[200] %s", r.URL)
 }
 
+func (s *gohatServer) mapsPage(w http.ResponseWriter, r *http.Request) {
+	data := map[string]interface{}{
+		"Name":    s.heapFile.Name,
+		"Version": s.heapFile.Version(),
+		"Maps":    s.heapFile.Maps(),
+	}
+
+	render(w, mapsTemplate, data)
+	log.Printf("[200] %s", r.URL)
+}
+
+func (s *gohatServer) dupStringsPage(w http.ResponseWriter, r *http.Request) {
+	minWaste, _ := strconv.ParseUint(r.URL.Query().Get("min"), 10, 64)
+
+	data := map[string]interface{}{
+		"Name":       s.heapFile.Name,
+		"MinWaste":   minWaste,
+		"Duplicates": s.heapFile.DuplicateStrings(minWaste),
+	}
+
+	render(w, dupStringsTemplate, data)
+	log.Printf("[200] %s", r.URL)
+}
+
+func (s *gohatServer) searchPage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "string"
+	}
+
+	data := map[string]interface{}{
+		"Name":  s.heapFile.Name,
+		"Query": query,
+		"Mode":  mode,
+	}
+
+	if query != "" {
+		matcher, err := newMatcher(s.heapFile, query, mode)
+		if err != nil {
+			data["Error"] = err
+		} else {
+			data["Hits"] = s.heapFile.Search(matcher, 1000)
+		}
+	}
+
+	render(w, searchTemplate, data)
+	log.Printf("[200] %s", r.URL)
+}
+
+func (s *gohatServer) allocsPage(w http.ResponseWriter, r *http.Request) {
+	sortBy := r.URL.Query().Get("sort")
+	sites := s.heapFile.AllocSites()
+	switch sortBy {
+	case "objects":
+		sort.Sort(heapfile.AllocSitesByObjects(sites))
+	case "live":
+		sort.Sort(heapfile.AllocSitesByLiveRatio(sites))
+	default:
+		sortBy = "bytes"
+	}
+
+	data := map[string]interface{}{
+		"Name":  s.heapFile.Name,
+		"Sort":  sortBy,
+		"Sites": sites,
+	}
+
+	render(w, allocsTemplate, data)
+	log.Printf("[200] %s", r.URL)
+}
+
+func (s *gohatServer) callTreePage(w http.ResponseWriter, r *http.Request) {
+	data := map[string]interface{}{
+		"Name": s.heapFile.Name,
+		"Tree": s.heapFile.RetainedCallTree(),
+	}
+
+	render(w, callTreeTemplate, data)
+	log.Printf("[200] %s", r.URL)
+}
+
+func (s *gohatServer) queryPage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		query = "SELECT addr, type, size, retained FROM * ORDER BY retained DESC LIMIT 20"
+	}
+
+	data := map[string]interface{}{
+		"Name":  s.heapFile.Name,
+		"Query": query,
+	}
+
+	result, err := heapquery.Run(s.heapFile, query)
+	if err != nil {
+		data["Error"] = err
+	} else {
+		data["Result"] = result
+	}
+
+	render(w, queryTemplate, data)
+	log.Printf("[200] %s", r.URL)
+}
+
+func (s *gohatServer) trendPage(w http.ResponseWriter, r *http.Request) {
+	data := map[string]interface{}{
+		"Name":      s.heapFile.Name,
+		"Snapshots": len(s.snapshots),
+	}
+	if len(s.snapshots) > 1 {
+		s.trendOnce.Do(func() { s.trends = heapfile.Trends(s.snapshots) })
+		data["Report"] = s.trends
+	}
+
+	render(w, trendTemplate, data)
+	log.Printf("[200] %s", r.URL)
+}
+
 func (s *gohatServer) rootsPage(w http.ResponseWriter, r *http.Request) {
 	render(w, rootsTemplate, s.heapFile)
 	log.Printf("[200] %s", r.URL)
 }
 
 func (s *gohatServer) garbagePage(w http.ResponseWriter, r *http.Request) {
-	render(w, garbageTemplate, s.heapFile)
+	render(w, garbageTemplate, s.objectList(r, s.heapFile.Garbage()))
 	log.Printf("[200] %s", r.URL)
 }
 
+// Filters objects with the list options in the query: type, kind, min, max, range, sort,
+// reverse and top, as the listing commands' flags
+func (s *gohatServer) objectList(r *http.Request, objects []*heapfile.Object) map[string]interface{} {
+	query := r.URL.Query()
+	data := map[string]interface{}{
+		"Name":   s.heapFile.Name,
+		"Path":   r.URL.Path,
+		"Filter": query,
+	}
+
+	minSize, _ := strconv.ParseUint(query.Get("min"), 10, 64)
+	maxSize, _ := strconv.ParseUint(query.Get("max"), 10, 64)
+	top, _ := strconv.Atoi(query.Get("top"))
+	options, err := newListOptions(query.Get("type"), query.Get("kind"), minSize, maxSize, query.Get("range"),
+		query.Get("sort"), query.Get("reverse") != "", top)
+	if err != nil {
+		data["Error"] = err
+		data["Objects"] = objects
+	} else {
+		data["Objects"] = filterObjects(objects, options)
+	}
+	return data
+}
+
 func (s *gohatServer) framePage(w http.ResponseWriter, r *http.Request) {
 	frameId := r.URL.Query().Get("id")
 	addr, err := strconv.ParseUint(frameId, 10, 64)
@@ -109,6 +284,13 @@ func (s *gohatServer) framePage(w http.ResponseWriter, r *http.Request) {
 func render(w http.ResponseWriter, templateString string, data interface{}) {
 	funcMap := template.FuncMap{
 		"hexdump": hexDump,
+		"value":   valueHTML,
+		"add":     func(a, b int) int { return a + b },
+		"quote":   quoteShort,
+		"split":   strings.Fields,
+		"chart":   chartSVG,
+		"cell":    queryValueHTML,
+		"percent": func(f float64) string { return fmt.Sprintf("%.0f%%", f*100) },
 	}
 
 	t := template.Must(template.New("main").Parse(bodyTemplate))
@@ -116,6 +298,61 @@ func render(w http.ResponseWriter, templateString string, data interface{}) {
 	t.Execute(w, data)
 }
 
+// Renders a decoded value with links to the objects it points to
+func valueHTML(v *heapfile.Value) template.HTML {
+	link := func(addr uint64, text string) string {
+		return fmt.Sprintf(`<a href="/object?id=%d">%s</a>`, addr, text)
+	}
+	return template.HTML(v.Format(html.EscapeString, link))
+}
+
+// Formats a query result value, linking addresses to their objects
+func queryValueHTML(v interface{}) template.HTML {
+	link := func(addr uint64) string {
+		return fmt.Sprintf(`<a href="/object?id=%d">0x%x</a>`, addr, addr)
+	}
+	switch v := v.(type) {
+	case heapquery.Address:
+		return template.HTML(link(uint64(v)))
+	case []*heapfile.Object:
+		links := make([]string, 0, len(v))
+		for _, object := range v {
+			links = append(links, link(object.Address))
+		}
+		return template.HTML(strings.Join(links, " "))
+	}
+	return template.HTML(html.EscapeString(heapquery.FormatValue(v)))
+}
+
+// Renders the values as a line chart, scaled to fit
+func chartSVG(values []int64) template.HTML {
+	const width, height = 240, 60
+	if len(values) < 2 {
+		return ""
+	}
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	points := make([]string, 0, len(values))
+	for i, v := range values {
+		x := float64(i) * width / float64(len(values)-1)
+		y := float64(height)
+		if max > min {
+			y = float64(height) - float64(v-min)*height/float64(max-min)
+		}
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	return template.HTML(fmt.Sprintf(`<svg width="%d" height="%d" viewBox="-2 -2 %d %d">`+
+		`<polyline fill="none" stroke="#c33" stroke-width="2" points="%s"/></svg>`,
+		width+4, height+4, width+4, height+4, strings.Join(points, " ")))
+}
+
 var bodyTemplate = `<html>
 <head>
 	<title>GoHat {{.Name}}</title>
@@ -129,6 +366,15 @@ var bodyTemplate = `<html>
 <a href="/objects">All Objects</a>
 <a href="/roots">Roots</a>
 <a href="/garbage">Garbage Objects</a>
+<a href="/maps">Maps</a>
+<a href="/dupstrings">Duplicate Strings</a>
+<a href="/allocs">Allocation Sites</a>
+<a href="/calltree">Retained Call Tree</a>
+<a href="/trend">Trend</a>
+<a href="/query">Query</a>
+<form action="/search" style="display: inline">
+<input type="text" name="q" placeholder="Search"> <input type="submit" value="Search">
+</form>
 {{template "body" .}}
 </body>
 </html>
@@ -137,15 +383,42 @@ var bodyTemplate = `<html>
 var mainTemplate = `
 <h2>Heap Parameters</h2>
 <table>
+<tr><td>Format</td><td>{{.Version}}</td></tr>
 <tr><td>Endianness</td><td>{{if .DumpParams.BigEndian}}Big{{else}}Little{{end}} Endian</td></tr>
 <tr><td>Pointer Size</td><td>{{.DumpParams.PtrSize}}</td></tr>
 <tr><td>Heap Start Address</td><td>{{printf "0x%x" .DumpParams.StartAddress}}</td></tr>
 <tr><td>End Addres</td><td>{{printf "0x%x" .DumpParams.EndAddress}}</td></tr>
+{{if eq .Version "go1.3"}}
 <tr><td>Arch</td><td>{{.DumpParams.Arch}}</td></tr>
 <tr><td>GOEXPERIMENT</td><td>{{.DumpParams.GoExperiment}}</td></tr>
+{{else}}
+<tr><td>GOARCH</td><td>{{.DumpParams.GoArch}}</td></tr>
+<tr><td>Runtime Version</td><td>{{.DumpParams.GoVersion}}</td></tr>
+{{end}}
 <tr><td>Num CPU</td><td>{{.DumpParams.NCPU}}</td></tr>
 </table>
 
+{{with .Metadata}}
+<h2>Provenance</h2>
+<table>
+<tr><td>Binary</td><td>{{.Binary}}</td></tr>
+<tr><td>Build ID</td><td>{{.BuildID}}</td></tr>
+<tr><td>Go Version</td><td>{{.GoVersion}}</td></tr>
+<tr><td>Main Module</td><td>{{with .Main}}{{.Path}}@{{.Version}}{{end}}</td></tr>
+<tr><td>Host</td><td>{{.Hostname}}</td></tr>
+<tr><td>Time</td><td>{{.Time}}</td></tr>
+<tr><td>Reason</td><td>{{.Reason}}</td></tr>
+<tr><td>Command Line</td><td>{{range .CommandLine}}{{.}} {{end}}</td></tr>
+{{range $key, $value := .Settings}}<tr><td>{{$key}}</td><td>{{$value}}</td></tr>{{end}}
+</table>
+{{if .Modules}}
+<h3>Modules</h3>
+<table>
+{{range .Modules}}<tr><td>{{.Path}}</td><td>{{.Version}}</td></tr>{{end}}
+</table>
+{{end}}
+{{end}}
+
 <h2>MemStats</h2>
 <table>
 <tr><th colspan="2">General Statistics</th></tr>
@@ -183,8 +456,32 @@ var mainTemplate = `
 </table>
 `
 
+var listFilterTemplate = `
+<form action="{{.Path}}">
+Type <input type="text" name="type" value="{{.Filter.Get "type"}}">
+Kind <select name="kind">
+{{$kind := .Filter.Get "kind"}}
+<option value="">any</option>
+{{range $k := "regular array channel" | split}}<option{{if eq $k $kind}} selected{{end}}>{{$k}}</option>{{end}}
+</select>
+Size <input type="text" name="min" size="6" value="{{.Filter.Get "min"}}"> to <input type="text" name="max" size="6" value="{{.Filter.Get "max"}}">
+Addresses <input type="text" name="range" placeholder="start-end" value="{{.Filter.Get "range"}}">
+Sort <select name="sort">
+{{$sort := .Filter.Get "sort"}}
+<option value="">none</option>
+{{range $s := "size address count name" | split}}<option{{if eq $s $sort}} selected{{end}}>{{$s}}</option>{{end}}
+</select>
+<input type="checkbox" name="reverse" value="1"{{if .Filter.Get "reverse"}} checked{{end}}> Reverse
+Top <input type="text" name="top" size="4" value="{{.Filter.Get "top"}}">
+<input type="submit" value="Filter">
+</form>
+{{with .Error}}<div>Error: {{.}}</div>{{end}}
+<div>{{len .Objects}} objects</div>
+`
+
 var objectsTemplate = `
 <h2>Objects</h2>
+` + listFilterTemplate + `
 {{range .Objects}}
 <div><a href="/object?id={{.Address}}">{{printf "0x%x" .Address}} {{.Name}}</a></div>
 {{end}}
@@ -192,7 +489,8 @@ var objectsTemplate = `
 
 var garbageTemplate = `
 <h2>Unreachable Objects</h2>
-{{range .Garbage}}
+` + listFilterTemplate + `
+{{range .Objects}}
 <div><a href="/object?id={{.Address}}">{{printf "0x%x" .Address}} {{.Name}}</a></div>
 {{end}}
 `
@@ -207,6 +505,56 @@ var objectTemplate = `
 <div>{{.Kind}} {{printf "0x%.4x" .Offset}}</div>
 {{end}}
 
+<h3>Value</h3>
+<pre>
+{{value .Value}}
+</pre>
+{{if lt .Depth .MaxDepth}}<a href="/object?id={{.Object.Address}}&depth={{add .Depth 1}}">Follow more pointers</a>{{end}}
+
+{{with .Map}}
+<h3>Map</h3>
+<table>
+<tr><td>Count</td><td>{{.Count}}</td></tr>
+<tr><td>Buckets</td><td>{{.Buckets}}</td></tr>
+<tr><td>Overflow Buckets</td><td>{{.OverflowBuckets}}</td></tr>
+<tr><td>Load Factor</td><td>{{printf "%.2f" .LoadFactor}}</td></tr>
+<tr><td>Growing</td><td>{{.Growing}}</td></tr>
+<tr><td>Bucket Bytes</td><td>{{.BucketBytes}}</td></tr>
+<tr><td>Wasted Bytes</td><td>{{.WastedBytes}}</td></tr>
+</table>
+<table>
+<tr><th>Key</th><th>Value</th></tr>
+{{range .Entries}}
+<tr><td>{{value .Key}}</td><td>{{value .Value}}</td></tr>
+{{end}}
+</table>
+{{end}}
+
+{{with .Channel}}
+<h3>Channel</h3>
+<table>
+<tr><td>Capacity</td><td>{{.Capacity}}</td></tr>
+<tr><td>Queued</td><td>{{.Count}}</td></tr>
+<tr><td>Element Size</td><td>{{.ElemSize}}</td></tr>
+<tr><td>Buffer Bytes</td><td>{{.BufferBytes}}</td></tr>
+<tr><td>Send Index</td><td>{{.SendIndex}}</td></tr>
+<tr><td>Receive Index</td><td>{{.RecvIndex}}</td></tr>
+<tr><td>Closed</td><td>{{.Closed}}</td></tr>
+</table>
+<h4>Buffered Elements</h4>
+{{range .Buffer}}
+<div>{{value .}}</div>
+{{end}}
+<h4>Waiting Receivers</h4>
+{{range .RecvWaiters}}
+<div>Goroutine {{.Id}} {{.ReasonWaiting}}</div>
+{{end}}
+<h4>Waiting Senders</h4>
+{{range .SendWaiters}}
+<div>Goroutine {{.Id}} {{.ReasonWaiting}}</div>
+{{end}}
+{{end}}
+
 <h3>Content</h3>
 <pre>
 {{hexdump .Object.Content}}
@@ -218,6 +566,140 @@ var objectTemplate = `
 {{end}}
 `
 
+var mapsTemplate = `
+<h2>Maps</h2>
+{{if ne .Version "go1.3"}}<p>Maps aren't recognized in {{.Version}} dumps</p>{{end}}
+<table>
+<tr><th>Map</th><th>Count</th><th>Buckets</th><th>Overflow</th><th>Load Factor</th><th>Wasted Bytes</th></tr>
+{{range .Maps}}
+<tr>
+<td><a href="/object?id={{.Object.Address}}">{{printf "0x%x" .Object.Address}} {{.Object.Name}}</a></td>
+<td>{{.Count}}</td><td>{{.Buckets}}</td><td>{{.OverflowBuckets}}</td>
+<td>{{printf "%.2f" .LoadFactor}}</td><td>{{.WastedBytes}}</td>
+</tr>
+{{end}}
+</table>
+`
+
+var dupStringsTemplate = `
+<h2>Duplicate Strings</h2>
+<form action="/dupstrings">
+Minimum wasted bytes <input type="text" name="min" value="{{.MinWaste}}"> <input type="submit" value="Filter">
+</form>
+<table>
+<tr><th>Copies</th><th>Wasted Bytes</th><th>String</th><th>Referrers</th></tr>
+{{range .Duplicates}}
+<tr>
+<td>{{.Count}}</td><td>{{.WastedBytes}}</td><td>{{quote .Text}}</td>
+<td>{{range .Referrers}}<div><a href="/object?id={{.Address}}">{{printf "0x%x" .Address}} {{.Name}}</a></div>{{end}}</td>
+</tr>
+{{end}}
+</table>
+`
+
+var searchTemplate = `
+<h2>Search</h2>
+<form action="/search">
+<input type="text" name="q" value="{{.Query}}">
+<select name="mode">
+{{$mode := .Mode}}
+{{range $m := "string hex regex int ptr" | split}}<option{{if eq $m $mode}} selected{{end}}>{{$m}}</option>{{end}}
+</select>
+<input type="submit" value="Search">
+</form>
+{{with .Error}}<div>Error: {{.}}</div>{{end}}
+{{range .Hits}}
+<div>{{printf "0x%x" .Address}}
+{{if .Object}}<a href="/object?id={{.Object.Address}}">{{printf "0x%x" .Object.Address}} {{.Object.Name}}</a>
+{{else if .Frame}}<a href="/frame?id={{.Frame.StackPointer}}">{{printf "0x%x" .Frame.StackPointer}} {{.Frame.Name}}</a>
+{{else}}{{.Segment}}{{end}}
++{{printf "0x%x" .Offset}}</div>
+{{end}}
+`
+
+var queryTemplate = `
+<h2>Query</h2>
+<form action="/query">
+<textarea name="q" rows="3" cols="100">{{.Query}}</textarea>
+<div><input type="submit" value="Run"></div>
+</form>
+<div>Attributes: addr, type, kind, size, retained, dominator, reachable, path, root, depth,
+referrers, referrers.count, children, children.count, fields.count, field[offset]</div>
+{{with .Error}}<div>Error: {{.}}</div>{{end}}
+{{with .Result}}
+<div>{{len .Rows}} rows</div>
+<table>
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}
+<tr>{{range .Values}}<td>{{cell .}}</td>{{end}}</tr>
+{{end}}
+</table>
+{{end}}
+`
+
+var allocsTemplate = `
+<h2>Allocation Sites</h2>
+<div>Sort by
+{{$sort := .Sort}}
+{{range $s := "bytes objects live" | split}}{{if eq $s $sort}}{{$s}}{{else}}<a href="/allocs?sort={{$s}}">{{$s}}</a>{{end}} {{end}}
+</div>
+<table>
+<tr><th>Site</th><th>Live Objects</th><th>Live Bytes</th><th>Types</th><th>Live / Allocated</th></tr>
+{{range .Sites}}
+<tr>
+<td>{{range .Frames}}<div>{{.Name}} {{.File}}:{{.Line}}</div>{{end}}</td>
+<td>{{.Objects}}</td><td>{{.Bytes}}</td>
+<td>{{range .Types}}<div>{{.Count}} {{.Name}} ({{.Bytes}} bytes)</div>{{end}}</td>
+<td>{{percent .LiveRatio}}</td>
+</tr>
+{{end}}
+</table>
+`
+
+var callTreeTemplate = `
+<h2>Retained Call Tree</h2>
+<div>Bytes retained by the sampled objects allocated below each function. Each object is
+counted once, for its closest sampled dominator.</div>
+{{define "node"}}
+<details>
+<summary>{{if .IsType}}[{{.Name}}]{{else}}{{.Name}}{{end}}
+{{.RetainedBytes}} retained, {{.ShallowBytes}} shallow, {{.Objects}} objects</summary>
+<div style="margin-left: 2em">{{range .Children}}{{template "node" .}}{{end}}</div>
+</details>
+{{end}}
+{{with .Tree}}<div>All samples: {{.RetainedBytes}} retained, {{.ShallowBytes}} shallow, {{.Objects}} objects</div>
+{{range .Children}}{{template "node" .}}{{end}}{{end}}
+`
+
+var trendTemplate = `
+<h2>Trend</h2>
+{{with .Report}}
+<div>Across {{len .Snapshots}} heap files: {{range .Snapshots}}{{.}} {{end}}</div>
+<h3>Growing Types</h3>
+<table>
+<tr><th>Type</th><th>Bytes per heap file</th><th>Objects per heap file</th><th>Confidence</th><th>Growth</th><th>Live Bytes</th></tr>
+{{range .Types}}
+<tr>
+<td>{{.Name}}</td><td>{{printf "%+.1f" .Slope}}</td><td>{{printf "%+.1f" .CountSlope}}</td><td>{{printf "%.2f" .Confidence}}</td>
+<td>{{chart .Bytes}}</td><td>{{range .Bytes}}{{.}} {{end}}</td>
+</tr>
+{{end}}
+</table>
+<h3>Growing Allocation Sites</h3>
+<table>
+<tr><th>Site</th><th>Bytes per heap file</th><th>Objects per heap file</th><th>Confidence</th><th>Growth</th><th>Live Bytes</th></tr>
+{{range .Sites}}
+<tr>
+<td>{{range .Stack}}<div>{{.}}</div>{{end}}</td><td>{{printf "%+.1f" .Slope}}</td><td>{{printf "%+.1f" .CountSlope}}</td><td>{{printf "%.2f" .Confidence}}</td>
+<td>{{chart .Bytes}}</td><td>{{range .Bytes}}{{.}} {{end}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<div>Trends need more than one heap file: gohat server &lt;heap file&gt; &lt;heap file&gt; ...</div>
+{{end}}
+`
+
 var rootsTemplate = `
 <h2>Roots</h2>
 <a href="#frames">Stack Frames</a>
@@ -229,7 +711,7 @@ var rootsTemplate = `
 
 <h3 id="frames">Stack Frames</h3>
 {{range .StackFrames}}
-<div><a href="/frame?id={{.StackPointer}}">{{printf "%010x" .StackPointer}} {{.Name}}</a></div>
+<div><a href="/frame?id={{.StackPointer}}">{{printf "0x%x" .StackPointer}} {{.Name}}</a></div>
 {{end}}
 
 <h3 id="data">Data Segment</h3>
@@ -259,7 +741,7 @@ var rootsTemplate = `
 `
 
 var frameTemplate = `
-<h2>Stack Frame {{printf "%010x" .Frame.StackPointer}} {{.Frame.Name}}</h2>
+<h2>Stack Frame {{printf "0x%x" .Frame.StackPointer}} {{.Frame.Name}}</h2>
 
 <h3>Children</h3>
 {{range .Frame.Objects}}
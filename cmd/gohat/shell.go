@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/chzyer/readline"
+	"github.com/rubyist/gohat/pkg/heapfile"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Maximum number of addresses offered when completing an address prefix
+const maxAddressCompletions = 100
+
+// An interactive session over one parsed heap file
+type shell struct {
+	heapFile *heapfile.HeapFile
+	rl       *readline.Instance
+	current  *heapfile.Object   // object the session is in, or nil at the top
+	previous []*heapfile.Object // objects cd left, for back
+	commands map[string]*shellCommand
+}
+
+type shellCommand struct {
+	usage    string
+	help     string
+	complete string // what the arguments are: "address", "type" or ""
+	run      func(s *shell, w io.Writer, args []string) error
+}
+
+func newShell(heapFile *heapfile.HeapFile) *shell {
+	s := &shell{heapFile: heapFile}
+	s.commands = map[string]*shellCommand{
+		"help":      {"help", "List the commands", "", (*shell).help},
+		"object":    {"object [address]", "Show an object, the current object by default", "address", (*shell).object},
+		"ls":        {"ls", "List the children of the current object", "", (*shell).ls},
+		"cd":        {"cd <address|index>", "Go into an object, or into a child of the current object by its ls index", "address", (*shell).cd},
+		"back":      {"back", "Go back to the object before the last cd", "", (*shell).back},
+		"type":      {"type [address|name]", "Show a type by address or name, the current object's by default", "type", (*shell).typeInfo},
+		"referrers": {"referrers [address]", "List the objects that point to an object", "address", (*shell).referrers},
+		"path":      {"path [address]", "Show a shortest path from a GC root to an object", "address", (*shell).path},
+		"goroutine": {"goroutine [id]", "Show a goroutine and its stack, or list the goroutines", "", (*shell).goroutine},
+		"histogram": {"histogram [pattern]", "Count the objects by type, optionally of types matching a glob or /regex/", "type", (*shell).histogram},
+		"quit":      {"quit", "Leave the shell", "", nil},
+	}
+	return s
+}
+
+// Reads and runs commands until quit or end of input
+func (s *shell) Run() error {
+	home, _ := os.UserHomeDir()
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          s.prompt(),
+		HistoryFile:     filepath.Join(home, ".gohat_history"),
+		AutoComplete:    s,
+		InterruptPrompt: "^C",
+		EOFPrompt:       "quit",
+
+		DisableAutoSaveHistory: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer rl.Close()
+	s.rl = rl
+
+	for {
+		line, err := rl.Readline()
+		if err == readline.ErrInterrupt {
+			continue
+		} else if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		rl.SaveHistory(line)
+		if args[0] == "quit" || args[0] == "exit" {
+			return nil
+		}
+
+		command, ok := s.commands[args[0]]
+		if !ok {
+			fmt.Fprintf(rl.Stderr(), "Unknown command %q, try help\n", args[0])
+			continue
+		}
+		var out bytes.Buffer
+		if err := command.run(s, &out, args[1:]); err != nil {
+			fmt.Fprintln(rl.Stderr(), "Error:", err)
+		}
+		s.page(out.String())
+		rl.SetPrompt(s.prompt())
+	}
+}
+
+func (s *shell) prompt() string {
+	if s.current == nil {
+		return "gohat> "
+	}
+	return fmt.Sprintf("gohat 0x%x %s> ", s.current.Address, s.current.Name())
+}
+
+// Writes output a screen at a time, waiting for enter between screens. q stops the output.
+func (s *shell) page(output string) {
+	lines := strings.SplitAfter(output, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	_, height, err := readline.GetSize(int(os.Stdout.Fd()))
+	if err != nil || height < 2 {
+		height = len(lines) + 1
+	}
+
+	w := s.rl.Stdout()
+	for len(lines) > 0 {
+		n := height - 1
+		if n > len(lines) {
+			n = len(lines)
+		}
+		fmt.Fprint(w, strings.Join(lines[:n], ""))
+		lines = lines[n:]
+		if len(lines) == 0 {
+			break
+		}
+
+		s.rl.SetPrompt(fmt.Sprintf("-- %d more lines, enter for more, q to stop -- ", len(lines)))
+		answer, err := s.rl.ReadlineWithDefault("")
+		if err != nil || strings.TrimSpace(answer) == "q" {
+			break
+		}
+	}
+}
+
+// Completes command names, then addresses or type names depending on the command
+func (s *shell) Do(line []rune, pos int) ([][]rune, int) {
+	text := string(line[:pos])
+	fields := strings.Fields(text)
+	word := ""
+	if len(fields) > 0 && !strings.HasSuffix(text, " ") {
+		word = fields[len(fields)-1]
+		fields = fields[:len(fields)-1]
+	}
+
+	candidates := make([]string, 0)
+	if len(fields) == 0 {
+		for name := range s.commands {
+			candidates = append(candidates, name)
+		}
+	} else if command, ok := s.commands[fields[0]]; ok && len(fields) == 1 {
+		switch command.complete {
+		case "address":
+			prefix := strings.TrimPrefix(word, "0x")
+			for _, object := range s.heapFile.Objects() {
+				if address := fmt.Sprintf("%x", object.Address); strings.HasPrefix(address, prefix) {
+					candidates = append(candidates, strings.TrimSuffix(word, prefix)+address)
+				}
+			}
+			sort.Strings(candidates)
+			if len(candidates) > maxAddressCompletions {
+				candidates = candidates[:maxAddressCompletions]
+			}
+		case "type":
+			for _, t := range s.heapFile.Types() {
+				candidates = append(candidates, t.Name)
+			}
+		}
+	}
+	sort.Strings(candidates)
+
+	completions := make([][]rune, 0)
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) {
+			completions = append(completions, []rune(candidate[len(word):]+" "))
+		}
+	}
+	return completions, len([]rune(word))
+}
+
+// Returns the object an argument names, or the current object if there are no arguments.
+// Addresses inside an object name that object.
+func (s *shell) objectArg(args []string) (*heapfile.Object, error) {
+	if len(args) == 0 {
+		if s.current == nil {
+			return nil, fmt.Errorf("no current object, give an address or cd into one")
+		}
+		return s.current, nil
+	}
+	addr, err := parseAddress(args[0])
+	if err != nil {
+		return nil, err
+	}
+	object := s.heapFile.ObjectContaining(addr)
+	if object == nil {
+		return nil, fmt.Errorf("no object at 0x%x", addr)
+	}
+	return object, nil
+}
+
+func (s *shell) help(w io.Writer, args []string) error {
+	names := make([]string, 0, len(s.commands))
+	for name := range s.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%-24s %s\n", s.commands[name].usage, s.commands[name].help)
+	}
+	return nil
+}
+
+func (s *shell) object(w io.Writer, args []string) error {
+	object, err := s.objectArg(args)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "0x%x %s %s %d\n", object.Address, object.Name(), object.Kind(), object.Size)
+	fmt.Fprintf(w, "Retained: %d\n", s.heapFile.RetainedSize(object))
+	fmt.Fprintln(w, s.heapFile.DecodeObject(object, 1))
+	return nil
+}
+
+func (s *shell) ls(w io.Writer, args []string) error {
+	object, err := s.objectArg(nil)
+	if err != nil {
+		return err
+	}
+	for i, child := range object.Children() {
+		fmt.Fprintf(w, "%d\t0x%x %s %d\n", i, child.Address, child.Name(), child.Size)
+	}
+	return nil
+}
+
+func (s *shell) cd(w io.Writer, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", s.commands["cd"].usage)
+	}
+
+	// Small decimal numbers are ls indexes, anything else is an address
+	var object *heapfile.Object
+	var children []*heapfile.Object
+	if s.current != nil {
+		children = s.current.Children()
+	}
+	if i, err := strconv.Atoi(args[0]); err == nil && i >= 0 && i < len(children) {
+		object = children[i]
+	} else {
+		var err error
+		if object, err = s.objectArg(args); err != nil {
+			return err
+		}
+	}
+
+	if s.current != nil {
+		s.previous = append(s.previous, s.current)
+	}
+	s.current = object
+	return nil
+}
+
+func (s *shell) back(w io.Writer, args []string) error {
+	if len(s.previous) == 0 {
+		s.current = nil
+		return nil
+	}
+	s.current = s.previous[len(s.previous)-1]
+	s.previous = s.previous[:len(s.previous)-1]
+	return nil
+}
+
+func (s *shell) typeInfo(w io.Writer, args []string) error {
+	var t *heapfile.Type
+	if len(args) == 0 {
+		object, err := s.objectArg(nil)
+		if err != nil {
+			return err
+		}
+		t = object.Type
+	} else {
+		name := strings.Join(args, " ")
+		for _, candidate := range s.heapFile.Types() {
+			if candidate.Name == name {
+				t = candidate
+				break
+			}
+		}
+		if t == nil {
+			if addr, err := parseAddress(name); err == nil {
+				t = s.heapFile.Type(addr)
+			}
+		}
+	}
+	if t == nil {
+		return fmt.Errorf("unknown type")
+	}
+
+	fmt.Fprintf(w, "0x%x %s %d\n", t.Address, t.Name, t.Size)
+	for _, field := range t.FieldList {
+		fmt.Fprintf(w, "%s 0x%04x\n", field.KindString(), field.Offset)
+	}
+	return nil
+}
+
+func (s *shell) referrers(w io.Writer, args []string) error {
+	object, err := s.objectArg(args)
+	if err != nil {
+		return err
+	}
+	for _, referrer := range s.heapFile.Referrers(object) {
+		fmt.Fprintf(w, "0x%x %s %d\n", referrer.Address, referrer.Name(), referrer.Size)
+	}
+	return nil
+}
+
+func (s *shell) path(w io.Writer, args []string) error {
+	object, err := s.objectArg(args)
+	if err != nil {
+		return err
+	}
+	path := s.heapFile.RootPath(object)
+	if path == nil {
+		fmt.Fprintln(w, "unreachable")
+		return nil
+	}
+	fmt.Fprintln(w, path.Root)
+	for _, o := range path.Objects {
+		fmt.Fprintf(w, "\t0x%x %s\n", o.Address, o.Name())
+	}
+	return nil
+}
+
+func (s *shell) goroutine(w io.Writer, args []string) error {
+	if len(args) == 0 {
+		for _, g := range s.heapFile.Goroutines() {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", g.Id, g.Status(), g.ReasonWaiting())
+		}
+		return nil
+	}
+
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid goroutine id %q", args[0])
+	}
+	for _, g := range s.heapFile.Goroutines() {
+		if g.Id != id {
+			continue
+		}
+		fmt.Fprintf(w, "Goroutine %d %s", g.Id, g.Status())
+		if reason := g.ReasonWaiting(); reason != "" {
+			fmt.Fprintf(w, " (%s)", reason)
+		}
+		fmt.Fprintln(w)
+		for _, frame := range g.StackFrames() {
+			fmt.Fprintf(w, "0x%x %s\n", frame.StackPointer, frame.Name)
+			for _, object := range frame.Objects() {
+				fmt.Fprintf(w, "\t0x%x %s\n", object.Address, object.Name())
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("no goroutine %d", id)
+}
+
+func (s *shell) histogram(w io.Writer, args []string) error {
+	options := &heapfile.ListOptions{Sort: "count"}
+	if len(args) > 0 {
+		pattern, err := heapfile.CompileNamePattern(strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+		options.Name = pattern
+	}
+	for _, e := range options.Histogram(s.heapFile.Objects()) {
+		fmt.Fprintf(w, "%d\t%d\t%s\n", e.Count, e.Size, e.Name)
+	}
+	return nil
+}
@@ -1,8 +1,18 @@
 package main
 
 import (
+	"encoding/hex"
 	"fmt"
 	"github.com/rubyist/gohat/pkg/heapfile"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func hexDump(content string) string {
@@ -47,5 +57,334 @@ func displayObjectShort(o *heapfile.Object) {
 	if o.Type != nil {
 		typeName = o.Type.Name
 	}
-	fmt.Printf("%x %s\n", o.Address, typeName)
+	fmt.Printf("0x%x %s\n", o.Address, typeName)
+}
+
+func displayMetadata(m *heapfile.Metadata) {
+	fmt.Println("Binary:", m.Binary)
+	fmt.Println("Build ID:", m.BuildID)
+	fmt.Println("Go Version:", m.GoVersion)
+	fmt.Println("Main Module:", moduleString(m.Main))
+	fmt.Println("Host:", m.Hostname)
+	fmt.Println("Time:", m.Time.Format(time.RFC3339))
+	fmt.Println("Reason:", m.Reason)
+	fmt.Println("Command Line:", strings.Join(m.CommandLine, " "))
+	if len(m.Settings) > 0 {
+		fmt.Println("Build Settings:")
+		keys := make([]string, 0, len(m.Settings))
+		for key := range m.Settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("\t%s=%s\n", key, m.Settings[key])
+		}
+	}
+	if len(m.Modules) > 0 {
+		fmt.Println("Modules:")
+		for _, module := range m.Modules {
+			fmt.Printf("\t%s\n", moduleString(module))
+		}
+	}
+}
+
+func displayMapStats(m *heapfile.Map) {
+	fmt.Printf("0x%x %s\n", m.Object.Address, m.Object.Name())
+	fmt.Println("Count:", m.Count)
+	fmt.Println("Buckets:", m.Buckets)
+	fmt.Println("Overflow Buckets:", m.OverflowBuckets)
+	fmt.Printf("Load Factor: %.2f\n", m.LoadFactor())
+	fmt.Println("Growing:", m.Growing)
+	fmt.Println("Bucket Bytes:", m.BucketBytes)
+	fmt.Println("Wasted Bytes:", m.WastedBytes())
+}
+
+func displayDiff(diff *heapfile.HeapDiff, limit int) {
+	fmt.Printf("Comparing %s to %s\n", diff.Old, diff.New)
+	fmt.Printf("%d objects survived, %d were freed and %d were allocated\n", diff.Survived, diff.Freed, diff.Allocated)
+
+	fmt.Printf("\nTypes (%d changed)\n", len(diff.Types))
+	for i, t := range diff.Types {
+		if limit > 0 && i >= limit {
+			break
+		}
+		fmt.Printf("%+d objects %+d bytes %s (%d -> %d objects, %d -> %d bytes, %d survived %d freed %d allocated)\n",
+			t.CountDelta, t.BytesDelta, t.Name, t.OldCount, t.NewCount, t.OldBytes, t.NewBytes, t.Survived, t.Freed, t.Allocated)
+	}
+
+	fmt.Printf("\nAllocation sites (%d changed)\n", len(diff.Sites))
+	for i, site := range diff.Sites {
+		if limit > 0 && i >= limit {
+			break
+		}
+		fmt.Printf("%+d in use %+d bytes %+d samples, %d byte objects\n", site.InUseDelta, site.BytesDelta, site.SamplesDelta, site.Size)
+		for _, frame := range site.Stack {
+			fmt.Printf("\t%s\n", frame)
+		}
+	}
+
+	fmt.Printf("\nNew roots (%d)\n", len(diff.Roots))
+	for i, root := range diff.Roots {
+		if limit > 0 && i >= limit {
+			break
+		}
+		fmt.Printf("%+d %s -> %s (%d -> %d)\n", root.CountDelta, root.Description, root.Type, root.OldCount, root.NewCount)
+	}
+
+	fmt.Printf("\nGoroutines (%d stacks changed)\n", len(diff.Goroutines))
+	for i, g := range diff.Goroutines {
+		if limit > 0 && i >= limit {
+			break
+		}
+		fmt.Printf("%+d goroutines (%d -> %d)\n", g.CountDelta, g.OldCount, g.NewCount)
+		for _, frame := range g.Stack {
+			fmt.Printf("\t%s\n", frame)
+		}
+	}
+
+	fmt.Printf("\nMemStats (%d changed)\n", len(diff.MemStats))
+	for _, stat := range diff.MemStats {
+		fmt.Printf("%s: %d -> %d (%+d)\n", stat.Name, stat.Old, stat.New, stat.Delta)
+	}
+}
+
+func displayTrends(report *heapfile.TrendReport, limit int) {
+	fmt.Printf("Trends across %d heap files: %s\n", len(report.Snapshots), strings.Join(report.Snapshots, " "))
+
+	fmt.Printf("\nGrowing types (%d)\n", len(report.Types))
+	for i, t := range report.Types {
+		if limit > 0 && i >= limit {
+			break
+		}
+		displayTrend(t)
+		fmt.Printf("\t%d objects survived from the first heap file\n", t.Survivors)
+	}
+
+	fmt.Printf("\nGrowing allocation sites (%d)\n", len(report.Sites))
+	for i, t := range report.Sites {
+		if limit > 0 && i >= limit {
+			break
+		}
+		displayTrend(t)
+		for _, frame := range t.Stack {
+			fmt.Printf("\t\t%s\n", frame)
+		}
+	}
+}
+
+func displayTrend(t *heapfile.Trend) {
+	fmt.Printf("%s %+.1f bytes %+.1f objects per heap file, confidence %.2f\n", t.Name, t.Slope, t.CountSlope, t.Confidence)
+	fmt.Printf("\tobjects %s\n", strings.Trim(fmt.Sprint(t.Counts), "[]"))
+	fmt.Printf("\tbytes   %s\n", strings.Trim(fmt.Sprint(t.Bytes), "[]"))
+}
+
+func displayAllocSite(site *heapfile.AllocSite) {
+	fmt.Printf("%s %d objects %d bytes, %d/%d allocations live (%.0f%%)\n", site.Name(), site.Objects, site.Bytes,
+		site.Allocs-site.Frees, site.Allocs, site.LiveRatio()*100)
+	for _, t := range site.Types {
+		fmt.Printf("\t%d %s (%d bytes)\n", t.Count, t.Name, t.Bytes)
+	}
+	for _, frame := range site.Frames {
+		fmt.Printf("\t\t%s   %s:%d\n", frame.Name, frame.File, frame.Line)
+	}
+}
+
+func displayCallTree(node *heapfile.CallTreeNode, depth, maxDepth int) {
+	name := node.Name
+	if node.IsType {
+		name = "[" + name + "]"
+	}
+	fmt.Printf("%s%s %d retained %d shallow %d objects\n", strings.Repeat("  ", depth), name,
+		node.RetainedBytes, node.ShallowBytes, node.Objects)
+	if maxDepth > 0 && depth >= maxDepth {
+		return
+	}
+	for _, child := range node.Children {
+		displayCallTree(child, depth+1, maxDepth)
+	}
+}
+
+// Reads a YAML rules file for check. Unknown keys are errors, so typos don't silently
+// disable a threshold.
+func readRules(path string) (*heapfile.Rules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := &heapfile.Rules{}
+	if err := yaml.UnmarshalStrict(data, rules); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if err := rules.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return rules, nil
+}
+
+func displayCheckResults(results []*heapfile.CheckResult) {
+	for _, result := range results {
+		status, comparison := "PASS", "<="
+		if !result.Passed {
+			status, comparison = "FAIL", ">"
+		}
+		fmt.Printf("%s %s: %s %s %s\n", status, result.Rule, formatFloat(result.Actual), comparison, formatFloat(result.Limit))
+		if !result.Passed && result.Detail != "" {
+			fmt.Printf("\t%s\n", result.Detail)
+		}
+	}
+}
+
+// Formats whole numbers without a fraction or exponent
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Flags shared by the listing commands, see heapfile.ListOptions
+type listFlags struct {
+	typePattern  string
+	kind         string
+	minSize      uint64
+	maxSize      uint64
+	addressRange string
+	sort         string
+	reverse      bool
+	top          int
+}
+
+// Registers the flags on cmd. --kind is only registered for listings of objects.
+func (l *listFlags) register(cmd *cobra.Command, objects bool) {
+	cmd.Flags().StringVarP(&l.typePattern, "type", "", "", "Only list names matching this glob, or /regex/")
+	if objects {
+		cmd.Flags().StringVarP(&l.kind, "kind", "", "", "Only list objects of this kind (regular, array, channel, unknown)")
+	}
+	cmd.Flags().Uint64VarP(&l.minSize, "min-size", "", 0, "Minimum size")
+	cmd.Flags().Uint64VarP(&l.maxSize, "max-size", "", 0, "Maximum size (0 for no limit)")
+	cmd.Flags().StringVarP(&l.addressRange, "address-range", "", "", "Only list addresses in start-end, in hex (end exclusive)")
+	cmd.Flags().StringVarP(&l.sort, "sort", "", "", "Sort by size, address, count or name")
+	cmd.Flags().BoolVarP(&l.reverse, "reverse", "", false, "Reverse the order")
+	cmd.Flags().IntVarP(&l.top, "top", "", 0, "Only list the first N entries (0 for all)")
+}
+
+// Builds the list options from the flags, exiting on invalid values
+func (l *listFlags) options() *heapfile.ListOptions {
+	options, err := newListOptions(l.typePattern, l.kind, l.minSize, l.maxSize, l.addressRange, l.sort, l.reverse, l.top)
+	if err != nil {
+		fmt.Println("Error:", err)
+		exit(1)
+	}
+	return options
+}
+
+func newListOptions(typePattern, kind string, minSize, maxSize uint64, addressRange, sortBy string, reverse bool, top int) (*heapfile.ListOptions, error) {
+	options := &heapfile.ListOptions{Kind: kind, MinSize: minSize, MaxSize: maxSize, Sort: sortBy, Reverse: reverse, Top: top}
+	if typePattern != "" {
+		re, err := heapfile.CompileNamePattern(typePattern)
+		if err != nil {
+			return nil, err
+		}
+		options.Name = re
+	}
+	if addressRange != "" {
+		parts := strings.SplitN(addressRange, "-", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("address range %q is not start-end", addressRange)
+		}
+		var err error
+		if options.StartAddress, err = parseAddress(parts[0]); err != nil {
+			return nil, err
+		}
+		if options.EndAddress, err = parseAddress(parts[1]); err != nil {
+			return nil, err
+		}
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// Returns the objects that pass the list options, in their order
+func filterObjects(objects []*heapfile.Object, options *heapfile.ListOptions) []*heapfile.Object {
+	entries := options.Apply(heapfile.ObjectEntries(objects))
+	result := make([]*heapfile.Object, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, e.Value.(*heapfile.Object))
+	}
+	return result
+}
+
+// Quotes s, truncating long strings
+func quoteShort(s string) string {
+	if len(s) > 60 {
+		return strconv.Quote(s[:60]) + "..."
+	}
+	return strconv.Quote(s)
+}
+
+// Builds a search matcher for pattern. Modes are string, hex, regex, int and ptr.
+func newMatcher(heapFile *heapfile.HeapFile, pattern, mode string) (heapfile.Matcher, error) {
+	switch mode {
+	case "string":
+		return heapfile.BytesMatcher([]byte(pattern)), nil
+	case "hex":
+		b, err := hex.DecodeString(strings.Replace(pattern, " ", "", -1))
+		if err != nil {
+			return nil, err
+		}
+		return heapfile.BytesMatcher(b), nil
+	case "regex":
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return heapfile.RegexpMatcher(re), nil
+	case "int":
+		v, err := strconv.ParseInt(pattern, 0, 64)
+		if err != nil {
+			return nil, err
+		}
+		return heapFile.WordMatcher(uint64(v)), nil
+	case "ptr":
+		v, err := parseAddress(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return heapFile.WordMatcher(v), nil
+	}
+	return nil, fmt.Errorf("unknown search mode %q", mode)
+}
+
+// Returns true if the heap file's maps and channels can be recognized, which needs the object
+// kinds and types go1.3 dumps record. Otherwise warns that they can't.
+func canFindMapsAndChannels(heapFile *heapfile.HeapFile, what string) bool {
+	if heapFile.Version() == "go1.3" {
+		return true
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s aren't recognized in %s dumps\n", what, heapFile.Version())
+	return false
+}
+
+// Parses a hex address, with or without a 0x prefix
+func parseAddress(s string) (uint64, error) {
+	addr, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+	return addr, nil
+}
+
+var secretPatternName = regexp.MustCompile(`^[\w-]+=`)
+
+// Parses a secret pattern given as name=regex. Patterns without a name are named "custom".
+func parseSecretPattern(s string) (*heapfile.SecretPattern, error) {
+	name := "custom"
+	if prefix := secretPatternName.FindString(s); prefix != "" {
+		name = strings.TrimSuffix(prefix, "=")
+		s = s[len(prefix):]
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, err
+	}
+	return &heapfile.SecretPattern{Name: name, Regexp: re}, nil
 }
@@ -0,0 +1,180 @@
+// Package capture writes heap dumps of the running process for gohat to analyze. A Capturer
+// writes dumps to a directory, keeping the most recent ones, each with a heapfile.Metadata
+// sidecar describing the binary and why the dump was taken. Dumps can be triggered over
+// HTTP, by a signal, or automatically when the heap grows past a threshold:
+//
+//	c := capture.New("/var/tmp/heapdumps", 5)
+//	http.Handle("/debug/heapdump", c)
+//	c.HandleSignal(syscall.SIGUSR1)
+//	c.WatchHeapInuse(1<<30, time.Minute)
+//
+// Writing a heap dump stops the world until it is done, which can take seconds for large
+// heaps. gohat reads object types from the executable named in the sidecar, so it has to be
+// kept, and not linked with -w, for the dumps to be analyzed.
+package capture
+
+import (
+	"fmt"
+	"github.com/rubyist/gohat/pkg/heapfile"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Writes heap dumps to a directory
+type Capturer struct {
+	dir  string
+	keep int
+
+	mu sync.Mutex
+}
+
+// Returns a Capturer that writes dumps to dir, creating it if needed, and removes the oldest
+// dumps so that at most keep remain. If keep is 0 every dump is kept.
+func New(dir string, keep int) *Capturer {
+	return &Capturer{dir: dir, keep: keep}
+}
+
+// Collects garbage and writes a heap dump and its metadata, returning the dump's path.
+// Captures are serialized.
+func (c *Capturer) Capture(reason string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	path := filepath.Join(c.dir, "heap-"+now.UTC().Format("20060102-150405.000000000")+".dump")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	runtime.GC()
+	debug.WriteHeapDump(f.Fd())
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	metadata := heapfile.CurrentMetadata(reason)
+	metadata.Time = now
+	if err := metadata.Write(path); err != nil {
+		return "", err
+	}
+
+	return path, c.rotate()
+}
+
+// Removes the oldest dumps and their metadata beyond the number to keep
+func (c *Capturer) rotate() error {
+	if c.keep <= 0 {
+		return nil
+	}
+	dumps, err := filepath.Glob(filepath.Join(c.dir, "heap-*.dump"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(dumps) // names sort by time
+	for len(dumps) > c.keep {
+		if err := os.Remove(dumps[0]); err != nil {
+			return err
+		}
+		os.Remove(dumps[0] + ".json")
+		dumps = dumps[1:]
+	}
+	return nil
+}
+
+// Captures a dump for each request, with the reason from the reason query parameter, and
+// responds with the dump's path. Only POST requests are accepted, so crawlers and link
+// previews don't stop the world.
+func (c *Capturer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "heap dumps are captured with POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "http " + r.RemoteAddr
+	}
+	path, err := c.Capture(reason)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, path)
+}
+
+// Captures a dump whenever one of the signals is received, until stop is called. Errors are
+// written to stderr.
+func (c *Capturer) HandleSignal(signals ...os.Signal) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	done := make(chan bool)
+	signal.Notify(ch, signals...)
+
+	go func() {
+		for {
+			select {
+			case sig := <-ch:
+				if _, err := c.Capture("signal " + sig.String()); err != nil {
+					fmt.Fprintln(os.Stderr, "capture:", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
+
+// Checks runtime.MemStats.HeapInuse every interval and captures a dump when it rises above
+// threshold. Another dump isn't captured until HeapInuse has fallen back below the threshold,
+// so a heap that stays large produces one dump rather than one per interval. Errors are
+// written to stderr.
+func (c *Capturer) WatchHeapInuse(threshold uint64, interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan bool)
+
+	go func() {
+		above := false
+		var stats runtime.MemStats
+		for {
+			select {
+			case <-ticker.C:
+				runtime.ReadMemStats(&stats)
+				if stats.HeapInuse <= threshold {
+					above = false
+					continue
+				}
+				if above {
+					continue
+				}
+				above = true
+				reason := fmt.Sprintf("HeapInuse %d above %d", stats.HeapInuse, threshold)
+				if _, err := c.Capture(reason); err != nil {
+					fmt.Fprintln(os.Stderr, "capture:", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
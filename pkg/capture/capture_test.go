@@ -0,0 +1,93 @@
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rubyist/gohat/pkg/heapfile"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCaptureOpensAndRotates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := New(filepath.Join(dir, "dumps"), 2)
+	paths := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		path, err := c.Capture(fmt.Sprintf("test %d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	for _, path := range []string{paths[0], heapfile.MetadataPath(paths[0])} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s wasn't removed", path)
+		}
+	}
+
+	heapFile, err := heapfile.New(paths[2])
+	if err != nil {
+		t.Fatalf("reading %s: %s", paths[2], err)
+	}
+	if v := heapFile.DumpParams().GoVersion; v != runtime.Version() {
+		t.Errorf("dump written by %q, expected %q", v, runtime.Version())
+	}
+	if len(heapFile.Goroutines()) == 0 {
+		t.Error("dump has no goroutines")
+	}
+	if m := readMetadata(t, paths[2]); m.Reason != "test 2" {
+		t.Errorf("metadata %+v, expected reason %q", m, "test 2")
+	}
+}
+
+func readMetadata(t *testing.T, path string) *heapfile.Metadata {
+	data, err := ioutil.ReadFile(heapfile.MetadataPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &heapfile.Metadata{}
+	if err := json.Unmarshal(data, m); err != nil {
+		t.Fatalf("reading %s: %s", heapfile.MetadataPath(path), err)
+	}
+	return m
+}
+
+func TestServeHTTP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := New(dir, 1)
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/debug/heapdump", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status %d, expected %d", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("POST", "/debug/heapdump?reason=slow", nil))
+	path := strings.TrimSpace(w.Body.String())
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST status %d: %s", w.Code, path)
+	}
+	if _, err := heapfile.New(path); err != nil {
+		t.Fatalf("reading %s: %s", path, err)
+	}
+	if m := readMetadata(t, path); m.Reason != "slow" {
+		t.Errorf("metadata %+v, expected reason %q", m, "slow")
+	}
+}
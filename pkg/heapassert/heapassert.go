@@ -0,0 +1,216 @@
+// Package heapassert lets tests make assertions about the heap of the test process, such as
+// that no objects of a type are still reachable once the code under test is done with them.
+//
+//	func TestCloseReleasesBuffers(t *testing.T) {
+//		c := NewConn()
+//		c.Close()
+//
+//		heap := heapassert.Snapshot(t)
+//		heap.NoObjects("example.com/conn.buffer")
+//		heap.MaxGoroutines("*conn.readLoop", 0)
+//	}
+//
+// Failures name the offending objects with a shortest path from a GC root, so the reference
+// that keeps them alive can be found. Types are named with their full import paths. A
+// pattern starting with * also matches pointer types, which name the backing arrays of slices
+// of pointers.
+package heapassert
+
+import (
+	"fmt"
+	"github.com/rubyist/gohat/pkg/heapfile"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Maximum number of offending objects or goroutines described in a failure
+const maxReported = 5
+
+// A parsed heap dump of the test process
+type Heap struct {
+	*heapfile.HeapFile
+	t testing.TB
+}
+
+// Collects garbage, writes a heap dump of the process to a temporary file and parses it. The
+// runtime doesn't record object types in the dump, so they are read from the test binary's
+// DWARF information. go test leaves it out unless the binary is kept, with -c or -o, or
+// linked with -ldflags='-s=false -w=false'. The test stops if the dump can't be written or
+// read, or if the types can't be found. The file is removed once it is parsed.
+func Snapshot(t testing.TB) *Heap {
+	t.Helper()
+
+	f, err := ioutil.TempFile("", "heapassert")
+	if err != nil {
+		t.Fatalf("heapassert: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	runtime.GC()
+	debug.WriteHeapDump(f.Fd())
+	f.Close()
+
+	binary, err := os.Executable()
+	if err != nil {
+		t.Fatalf("heapassert: %s", err)
+	}
+
+	heapFile, err := heapfile.New(f.Name())
+	if err != nil {
+		t.Fatalf("heapassert: reading heap dump: %s", err)
+	}
+	heapFile.SetBinary(binary)
+	heapFile.Objects() // parse before the file is removed
+	if err := heapFile.TypeError(); err != nil {
+		t.Fatalf("heapassert: %s", err)
+	}
+	return &Heap{heapFile, t}
+}
+
+// Fails the test if any reachable object's type matches the pattern, a glob or /regex/ as
+// in heapfile.CompileNamePattern. Returns true if the assertion passed.
+func (h *Heap) NoObjects(typePattern string) bool {
+	h.t.Helper()
+	return h.MaxObjects(typePattern, 0)
+}
+
+// Fails the test if more than max reachable objects have types matching the pattern
+func (h *Heap) MaxObjects(typePattern string, max int) bool {
+	h.t.Helper()
+	objects := h.reachable(h.pattern(typePattern))
+	if len(objects) <= max {
+		return true
+	}
+
+	sort.Sort(objectsByAddress(objects))
+	h.t.Errorf("heapassert: %d live objects of type %s, want at most %d\n%s",
+		len(objects), typePattern, max, h.describe(objects))
+	return false
+}
+
+// Fails the test if the reachable objects with types matching the pattern retain more than
+// limit bytes. Objects dominated by another matching object are counted once, as part of it.
+func (h *Heap) MaxRetainedBytes(typePattern string, limit uint64) bool {
+	h.t.Helper()
+	objects := h.reachable(h.pattern(typePattern))
+	matching := make(map[uint64]bool, len(objects))
+	for _, object := range objects {
+		matching[object.Address] = true
+	}
+
+	top := make([]*heapfile.Object, 0)
+	total := uint64(0)
+	for _, object := range objects {
+		dominated := false
+		for d := h.Dominator(object); d != nil; d = h.Dominator(d) {
+			if matching[d.Address] {
+				dominated = true
+				break
+			}
+		}
+		if !dominated {
+			top = append(top, object)
+			total += h.RetainedSize(object)
+		}
+	}
+	if total <= limit {
+		return true
+	}
+
+	sort.Sort(objectsByRetained{top, h.HeapFile})
+	h.t.Errorf("heapassert: live objects of type %s retain %d bytes, want at most %d\n%s",
+		typePattern, total, limit, h.describe(top))
+	return false
+}
+
+// Fails the test if more than max goroutines have a function matching the pattern, a glob
+// or /regex/, on their stacks. Inlined functions have no frames of their own.
+func (h *Heap) MaxGoroutines(functionPattern string, max int) bool {
+	h.t.Helper()
+	pattern := h.pattern(functionPattern)
+
+	matching := make([]*heapfile.Goroutine, 0)
+	for _, g := range h.Goroutines() {
+		for _, frame := range g.StackFrames() {
+			if pattern.MatchString(frame.Name) {
+				matching = append(matching, g)
+				break
+			}
+		}
+	}
+	if len(matching) <= max {
+		return true
+	}
+
+	lines := make([]string, 0)
+	for i, g := range matching {
+		if i == maxReported {
+			lines = append(lines, fmt.Sprintf("\t... %d more", len(matching)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("\tgoroutine %d %s", g.Id, g.Status()))
+		for _, frame := range g.StackFrames() {
+			lines = append(lines, "\t\t"+frame.Name)
+		}
+	}
+	h.t.Errorf("heapassert: %d goroutines running %s, want at most %d\n%s",
+		len(matching), functionPattern, max, strings.Join(lines, "\n"))
+	return false
+}
+
+func (h *Heap) pattern(pattern string) *regexp.Regexp {
+	h.t.Helper()
+	re, err := heapfile.CompileNamePattern(pattern)
+	if err != nil {
+		h.t.Fatalf("heapassert: invalid pattern %q: %s", pattern, err)
+	}
+	return re
+}
+
+// Returns the objects reachable from the GC roots whose type names match
+func (h *Heap) reachable(pattern *regexp.Regexp) []*heapfile.Object {
+	objects := make([]*heapfile.Object, 0)
+	for _, object := range h.Objects() {
+		if pattern.MatchString(object.Name()) && h.RootPath(object) != nil {
+			objects = append(objects, object)
+		}
+	}
+	return objects
+}
+
+// Describes the first few objects with their retained sizes and root paths
+func (h *Heap) describe(objects []*heapfile.Object) string {
+	lines := make([]string, 0)
+	for i, object := range objects {
+		if i == maxReported {
+			lines = append(lines, fmt.Sprintf("\t... %d more", len(objects)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("\t0x%x %s, %d bytes retained", object.Address, object.Name(), h.RetainedSize(object)))
+		lines = append(lines, fmt.Sprintf("\t\t%s", h.RootPath(object)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+type objectsByAddress []*heapfile.Object
+
+func (o objectsByAddress) Len() int           { return len(o) }
+func (o objectsByAddress) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o objectsByAddress) Less(i, j int) bool { return o[i].Address < o[j].Address }
+
+type objectsByRetained struct {
+	objects []*heapfile.Object
+	heap    *heapfile.HeapFile
+}
+
+func (o objectsByRetained) Len() int      { return len(o.objects) }
+func (o objectsByRetained) Swap(i, j int) { o.objects[i], o.objects[j] = o.objects[j], o.objects[i] }
+func (o objectsByRetained) Less(i, j int) bool {
+	return o.heap.RetainedSize(o.objects[i]) > o.heap.RetainedSize(o.objects[j])
+}
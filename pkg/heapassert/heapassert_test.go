@@ -0,0 +1,150 @@
+package heapassert
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Set in the environment of a test binary built by TestMain
+const rebuiltEnv = "HEAPASSERT_REBUILT"
+
+// go test links the test binary without the DWARF information Snapshot needs, so without it
+// the tests run in a copy built with go test -c
+func TestMain(m *testing.M) {
+	if binary, err := os.Executable(); err == nil && os.Getenv(rebuiltEnv) == "" && !hasDWARF(binary) {
+		os.Exit(runRebuilt())
+	}
+	os.Exit(m.Run())
+}
+
+func hasDWARF(binary string) bool {
+	if f, err := elf.Open(binary); err == nil {
+		defer f.Close()
+		return f.Section(".debug_info") != nil || f.Section(".zdebug_info") != nil
+	}
+	if f, err := macho.Open(binary); err == nil {
+		defer f.Close()
+		_, err := f.DWARF()
+		return err == nil
+	}
+	return true
+}
+
+// Builds the test binary with DWARF information and runs it with the same flags, returning
+// its exit status
+func runRebuilt() int {
+	dir, err := ioutil.TempDir("", "heapassert")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	binary := filepath.Join(dir, "heapassert.test")
+	build := exec.Command("go", "test", "-c", "-o", binary, ".")
+	build.Stdout, build.Stderr = os.Stdout, os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Println("Error: building the test binary:", err)
+		return 1
+	}
+
+	test := exec.Command(binary, os.Args[1:]...)
+	test.Stdout, test.Stderr = os.Stdout, os.Stderr
+	test.Env = append(os.Environ(), rebuiltEnv+"=1")
+	if err := test.Run(); err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			return exit.ExitCode()
+		}
+		fmt.Println("Error:", err)
+		return 1
+	}
+	return 0
+}
+
+type leaked struct {
+	id   int
+	data []byte
+}
+
+var leaks []*leaked
+
+// Records failures instead of failing the test, to check that assertions fail
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestObjectAssertions(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		leaks = append(leaks, &leaked{id: i, data: make([]byte, 100)})
+	}
+	heap := Snapshot(t)
+	r := &recorder{TB: t}
+	heap.t = r
+
+	// Anchored, as *heapassert.leaked would also match the slice's backing array
+	const pattern = "github.com/*/heapassert.leaked"
+	if !heap.MaxObjects(pattern, 10) {
+		t.Errorf("MaxObjects(10) failed with 10 objects: %s", r.errors)
+	}
+	if heap.NoObjects(pattern) {
+		t.Error("NoObjects passed with 10 objects")
+	} else if !strings.Contains(r.errors[0], "10 live objects") || !strings.Contains(r.errors[0], "bss -> ") {
+		t.Errorf("NoObjects failure doesn't count the objects or give their root paths:\n%s", r.errors[0])
+	}
+	// Each object retains at least itself. Its data may also be referred to by stale words
+	// elsewhere, which conservative scanning can't tell from pointers.
+	if heap.MaxRetainedBytes(pattern, 10*32-1) {
+		t.Error("MaxRetainedBytes passed with more than 319 bytes retained")
+	}
+	if !heap.MaxRetainedBytes(pattern, 10*1024) {
+		t.Errorf("MaxRetainedBytes failed: %s", r.errors[len(r.errors)-1])
+	}
+
+	leaks = nil
+	heap = Snapshot(t)
+	heap.NoObjects(pattern)
+}
+
+// Not inlined into its go statement's wrapper, so it has a frame of its own
+//
+//go:noinline
+func parked(started *sync.WaitGroup, c chan struct{}) {
+	started.Done()
+	<-c
+}
+
+func TestMaxGoroutines(t *testing.T) {
+	var started sync.WaitGroup
+	c := make(chan struct{})
+	started.Add(2)
+	for i := 0; i < 2; i++ {
+		go parked(&started, c)
+	}
+	started.Wait()
+
+	heap := Snapshot(t)
+	r := &recorder{TB: t}
+	heap.t = r
+	if heap.MaxGoroutines("*heapassert.parked", 1) {
+		t.Error("MaxGoroutines(1) passed with 2 goroutines")
+	}
+	if !heap.MaxGoroutines("*heapassert.parked", 2) {
+		t.Errorf("MaxGoroutines(2) failed: %s", r.errors[len(r.errors)-1])
+	}
+	close(c)
+}
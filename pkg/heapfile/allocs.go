@@ -0,0 +1,145 @@
+package heapfile
+
+import (
+	"sort"
+	"strings"
+)
+
+// Live sampled objects allocated at the same call stack
+type AllocSite struct {
+	Frames   []*Frame
+	Profiles []*Profile   // the profile records for the stack, one per object size
+	Objects  int          // live sampled objects
+	Bytes    int          // size of the live sampled objects
+	Types    []*TypeCount // types of the live sampled objects, most common first
+	Allocs   uint64       // allocations recorded by the profile
+	Frees    uint64       // frees recorded by the profile
+}
+
+// Number and size of the objects of one type
+type TypeCount struct {
+	Name  string
+	Count int
+	Bytes int
+}
+
+// Returns the fraction of the profiled allocations at this site that have not been freed
+func (s *AllocSite) LiveRatio() float64 {
+	if s.Allocs == 0 || s.Frees > s.Allocs {
+		return 0
+	}
+	return float64(s.Allocs-s.Frees) / float64(s.Allocs)
+}
+
+// Returns the innermost function of the site
+func (s *AllocSite) Name() string {
+	if len(s.Frames) == 0 {
+		return "unknown"
+	}
+	return s.Frames[0].Name
+}
+
+// Groups the alloc samples by the stack of their profile record. Samples whose object or
+// profile record is missing from the dump are skipped. Sites are sorted by bytes, largest first.
+func (h *HeapFile) AllocSites() []*AllocSite {
+	h.parse()
+
+	sites := make(map[string]*AllocSite, 0)
+	types := make(map[string]map[string]*TypeCount, 0)
+	site := func(profile *Profile) (*AllocSite, string) {
+		key := strings.Join(profile.Stack(), "\n")
+		s, ok := sites[key]
+		if !ok {
+			s = &AllocSite{Frames: profile.Frames}
+			sites[key] = s
+			types[key] = make(map[string]*TypeCount, 0)
+		}
+		return s, key
+	}
+
+	for _, profile := range h.memProf {
+		s, _ := site(profile)
+		s.Profiles = append(s.Profiles, profile)
+		s.Allocs += profile.Allocs
+		s.Frees += profile.Frees
+	}
+
+	for _, alloc := range h.allocs {
+		object, profile := alloc.Object(), alloc.Profile()
+		if object == nil || profile == nil {
+			continue
+		}
+		s, key := site(profile)
+		s.Objects++
+		s.Bytes += object.Size
+
+		t, ok := types[key][object.Name()]
+		if !ok {
+			t = &TypeCount{Name: object.Name()}
+			types[key][object.Name()] = t
+		}
+		t.Count++
+		t.Bytes += object.Size
+	}
+
+	result := make([]*AllocSite, 0, len(sites))
+	for key, s := range sites {
+		if s.Objects == 0 {
+			continue
+		}
+		for _, t := range types[key] {
+			s.Types = append(s.Types, t)
+		}
+		sort.Sort(typeCountsByCount(s.Types))
+		result = append(result, s)
+	}
+	sort.Sort(AllocSitesByBytes(result))
+	return result
+}
+
+// Sorts alloc sites by live sampled bytes, largest first
+type AllocSitesByBytes []*AllocSite
+
+func (s AllocSitesByBytes) Len() int      { return len(s) }
+func (s AllocSitesByBytes) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s AllocSitesByBytes) Less(i, j int) bool {
+	if s[i].Bytes != s[j].Bytes {
+		return s[i].Bytes > s[j].Bytes
+	}
+	return s[i].Objects > s[j].Objects
+}
+
+// Sorts alloc sites by live sampled objects, most first
+type AllocSitesByObjects []*AllocSite
+
+func (s AllocSitesByObjects) Len() int      { return len(s) }
+func (s AllocSitesByObjects) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s AllocSitesByObjects) Less(i, j int) bool {
+	if s[i].Objects != s[j].Objects {
+		return s[i].Objects > s[j].Objects
+	}
+	return s[i].Bytes > s[j].Bytes
+}
+
+// Sorts alloc sites by the fraction of their allocations still live, highest first
+type AllocSitesByLiveRatio []*AllocSite
+
+func (s AllocSitesByLiveRatio) Len() int      { return len(s) }
+func (s AllocSitesByLiveRatio) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s AllocSitesByLiveRatio) Less(i, j int) bool {
+	if s[i].LiveRatio() != s[j].LiveRatio() {
+		return s[i].LiveRatio() > s[j].LiveRatio()
+	}
+	return s[i].Bytes > s[j].Bytes
+}
+
+type typeCountsByCount []*TypeCount
+
+func (t typeCountsByCount) Len() int      { return len(t) }
+func (t typeCountsByCount) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t typeCountsByCount) Less(i, j int) bool {
+	if t[i].Count != t[j].Count {
+		return t[i].Count > t[j].Count
+	}
+	return t[i].Name < t[j].Name
+}
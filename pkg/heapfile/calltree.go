@@ -0,0 +1,150 @@
+package heapfile
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A node in the call tree of the alloc samples. Function nodes are named after a frame of
+// the allocation stacks, outermost at the top of the tree; each stack ends in a node for
+// every type allocated there.
+type CallTreeNode struct {
+	Name          string
+	IsType        bool            // whether the node is a type of the sampled objects rather than a function
+	Objects       int             // sampled objects allocated at or below this node
+	ShallowBytes  uint64          // size of the sampled objects
+	RetainedBytes uint64          // bytes retained by the sampled objects
+	Children      []*CallTreeNode // sorted by retained bytes, largest first
+
+	children map[string]*CallTreeNode
+}
+
+// Builds the call tree of the alloc samples, with the bytes retained by the sampled objects.
+// Each reachable object is attributed to its closest dominator, itself included, that was
+// sampled, so no byte is counted twice. Objects with no sampled dominator are not counted.
+func (h *HeapFile) RetainedCallTree() *CallTreeNode {
+	h.parse()
+	h.findDominators()
+
+	sampled := make(map[uint64]*Alloc, len(h.allocs))
+	for _, alloc := range h.allocs {
+		if alloc.Object() != nil && alloc.Profile() != nil {
+			sampled[alloc.objectAddress] = alloc
+		}
+	}
+
+	// The closest sampled dominator of each object, found by walking up the dominator tree
+	owners := make(map[uint64]*Object, len(h.retained))
+	var owner func(o *Object) *Object
+	owner = func(o *Object) *Object {
+		if o == nil {
+			return nil
+		}
+		if _, ok := sampled[o.Address]; ok {
+			return o
+		}
+		if result, ok := owners[o.Address]; ok {
+			return result
+		}
+		result := owner(h.dominators[o.Address])
+		owners[o.Address] = result
+		return result
+	}
+
+	retained := make(map[uint64]uint64, len(sampled))
+	for _, object := range h.sortedObjects {
+		if _, ok := h.retained[object.Address]; !ok {
+			continue // unreachable
+		}
+		if o := owner(object); o != nil {
+			retained[o.Address] += uint64(object.Size)
+		}
+	}
+
+	root := &CallTreeNode{Name: "root"}
+	for _, alloc := range h.allocs {
+		if _, ok := sampled[alloc.objectAddress]; !ok {
+			continue
+		}
+		object, frames := alloc.Object(), alloc.Profile().Frames
+
+		node := root
+		node.add(object, retained[object.Address])
+		for i := len(frames) - 1; i >= 0; i-- {
+			node = node.child(frames[i].Name, false)
+			node.add(object, retained[object.Address])
+		}
+		node = node.child(object.Name(), true)
+		node.add(object, retained[object.Address])
+	}
+	root.sort()
+	return root
+}
+
+func (n *CallTreeNode) child(name string, isType bool) *CallTreeNode {
+	key := name
+	if isType {
+		key = "type " + name
+	}
+	if n.children == nil {
+		n.children = make(map[string]*CallTreeNode, 0)
+	}
+	child, ok := n.children[key]
+	if !ok {
+		child = &CallTreeNode{Name: name, IsType: isType}
+		n.children[key] = child
+		n.Children = append(n.Children, child)
+	}
+	return child
+}
+
+func (n *CallTreeNode) add(object *Object, retained uint64) {
+	n.Objects++
+	n.ShallowBytes += uint64(object.Size)
+	n.RetainedBytes += retained
+}
+
+func (n *CallTreeNode) sort() {
+	sort.Sort(callTreeByRetained(n.Children))
+	for _, child := range n.Children {
+		child.sort()
+	}
+}
+
+// Writes the tree as folded stacks, one line per allocation stack and type with the retained
+// bytes, as used by flamegraph.pl and similar tools. The root node is not included.
+func (n *CallTreeNode) WriteFolded(w io.Writer) error {
+	for _, child := range n.Children {
+		if err := child.writeFolded(w, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *CallTreeNode) writeFolded(w io.Writer, path []string) error {
+	path = append(path, strings.Replace(n.Name, ";", ",", -1))
+	if len(n.Children) == 0 {
+		_, err := fmt.Fprintf(w, "%s %d\n", strings.Join(path, ";"), n.RetainedBytes)
+		return err
+	}
+	for _, child := range n.Children {
+		if err := child.writeFolded(w, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type callTreeByRetained []*CallTreeNode
+
+func (c callTreeByRetained) Len() int      { return len(c) }
+func (c callTreeByRetained) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c callTreeByRetained) Less(i, j int) bool {
+	if c[i].RetainedBytes != c[j].RetainedBytes {
+		return c[i].RetainedBytes > c[j].RetainedBytes
+	}
+	return c[i].Name < c[j].Name
+}
@@ -0,0 +1,160 @@
+package heapfile
+
+import (
+	"sort"
+)
+
+// A channel decoded from its header and buffer
+type Channel struct {
+	Object      *Object
+	ElemType    *Type  // element type, if known
+	Count       uint64 // number of buffered elements
+	Capacity    uint64 // size of the buffer in elements
+	ElemSize    uint64 // size of an element
+	Closed      bool
+	SendIndex   uint64
+	RecvIndex   uint64
+	Buffer      []*Value     // buffered elements, in the order they will be received
+	RecvWaiters []*Goroutine // goroutines blocked receiving
+	SendWaiters []*Goroutine // goroutines blocked sending
+}
+
+// Bytes used by the channel's buffer
+func (c *Channel) BufferBytes() uint64 {
+	return c.Capacity * c.ElemSize
+}
+
+// Fraction of the buffer in use
+func (c *Channel) FillRatio() float64 {
+	if c.Capacity == 0 {
+		return 0
+	}
+	return float64(c.Count) / float64(c.Capacity)
+}
+
+// Decodes the channel o. Buffered elements are decoded following pointers depth levels deep.
+// Returns nil if o is not a channel.
+//
+// The header is the runtime's Hchan: qcount, dataqsiz, elemsize (uint16), pad (uint16),
+// closed (bool), elemalg, sendx, recvx, recvq and sendq (each a first and last SudoG),
+// and a lock. The buffer follows at DumpParams.ChHdrSize.
+func (h *HeapFile) Channel(o *Object, depth int) *Channel {
+	h.parse()
+	ptrSize := h.dumpParams.PtrSize
+	if o.kind != 2 || uint64(o.Size) < h.dumpParams.ChHdrSize {
+		return nil
+	}
+
+	c := &Channel{Object: o, ElemType: o.Type}
+	c.Count = h.readPtr(o.Content, 0)
+	c.Capacity = h.readPtr(o.Content, 1)
+	c.ElemSize = h.readUint(o.Content[2*ptrSize : 2*ptrSize+2])
+	c.Closed = o.Content[2*ptrSize+4] != 0
+
+	// elemalg is pointer aligned after closed
+	words := (2*ptrSize+5+ptrSize-1)/ptrSize + 1
+	c.SendIndex = h.readPtr(o.Content, words)
+	c.RecvIndex = h.readPtr(o.Content, words+1)
+	c.RecvWaiters = h.waitQueue(h.readPtr(o.Content, words+2))
+	c.SendWaiters = h.waitQueue(h.readPtr(o.Content, words+4))
+
+	// The buffer follows the header, so a capacity that doesn't fit in the object is garbage
+	if c.Capacity == 0 || c.ElemSize == 0 || c.Capacity > (uint64(o.Size)-h.dumpParams.ChHdrSize)/c.ElemSize {
+		return c
+	}
+	for i := uint64(0); i < c.Count && i < c.Capacity; i++ {
+		if i == maxValueElements {
+			break
+		}
+		start := h.dumpParams.ChHdrSize + ((c.RecvIndex+i)%c.Capacity)*c.ElemSize
+		end := start + c.ElemSize
+		if end > uint64(o.Size) {
+			break
+		}
+		elem := o.Content[start:end]
+		if c.ElemType != nil && c.ElemType.Size == c.ElemSize {
+			c.Buffer = append(c.Buffer, h.decodeType(c.ElemType, elem, depth))
+		} else {
+			c.Buffer = append(c.Buffer, &Value{heap: h, Kind: ValueRaw, Raw: elem, Len: c.ElemSize})
+		}
+	}
+	return c
+}
+
+// Returns all of the channels on the heap, sorted by buffer size and then fill ratio, largest first
+func (h *HeapFile) Channels() []*Channel {
+	h.parse()
+	channels := make([]*Channel, 0)
+	for _, object := range h.objectList {
+		if c := h.Channel(object, 0); c != nil {
+			channels = append(channels, c)
+		}
+	}
+	sort.Sort(channelsByBuffer(channels))
+	return channels
+}
+
+// Follows a list of SudoGs (g, selectdone, link, ...) to the goroutines waiting on them.
+// SudoGs usually live on the waiting goroutine's stack.
+func (h *HeapFile) waitQueue(sudog uint64) []*Goroutine {
+	waiters := make([]*Goroutine, 0)
+	seen := make(map[uint64]bool, 0)
+	for sudog != 0 && !seen[sudog] {
+		seen[sudog] = true
+		content, ok := h.readMemory(sudog, 3*h.dumpParams.PtrSize)
+		if !ok {
+			break
+		}
+		if g := h.goroutineAt(h.readPtr(content, 0)); g != nil {
+			waiters = append(waiters, g)
+		}
+		sudog = h.readPtr(content, 2)
+	}
+	return waiters
+}
+
+// Reads n bytes at addr from the heap, a stack frame, the data segment or the bss
+func (h *HeapFile) readMemory(addr, n uint64) (string, bool) {
+	if object := h.objectContaining(addr); object != nil {
+		return readRange(object.Address, object.Content, addr, n)
+	}
+	for _, frame := range h.stackFrames {
+		if content, ok := readRange(frame.StackPointer, frame.Content, addr, n); ok {
+			return content, true
+		}
+	}
+	for _, segment := range []*Segment{h.dataSegment, h.bss} {
+		if content, ok := readRange(segment.Address, segment.Content, addr, n); ok {
+			return content, true
+		}
+	}
+	return "", false
+}
+
+// Reads n bytes at addr from content starting at base
+func readRange(base uint64, content string, addr, n uint64) (string, bool) {
+	if addr < base || addr+n > base+uint64(len(content)) {
+		return "", false
+	}
+	return content[addr-base : addr-base+n], true
+}
+
+func (h *HeapFile) goroutineAt(addr uint64) *Goroutine {
+	for _, g := range h.goroutines {
+		if g.Address == addr {
+			return g
+		}
+	}
+	return nil
+}
+
+type channelsByBuffer []*Channel
+
+func (c channelsByBuffer) Len() int      { return len(c) }
+func (c channelsByBuffer) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c channelsByBuffer) Less(i, j int) bool {
+	if c[i].BufferBytes() == c[j].BufferBytes() {
+		return c[i].FillRatio() > c[j].FillRatio()
+	}
+	return c[i].BufferBytes() > c[j].BufferBytes()
+}
@@ -0,0 +1,180 @@
+package heapfile
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Thresholds for a heap, as read from a rules file. Unset thresholds aren't checked.
+type Rules struct {
+	Types                   []*TypeRule       `yaml:"types"`
+	Goroutines              *GoroutineRule    `yaml:"goroutines"`
+	MaxGarbageBytes         *uint64           `yaml:"max_garbage_bytes"`
+	MaxFragmentedBytes      *uint64           `yaml:"max_fragmented_bytes"`
+	MaxFragmentation        *float64          `yaml:"max_fragmentation"` // fraction of HeapInuse that no object occupies
+	MaxDuplicateStringWaste *uint64           `yaml:"max_duplicate_string_waste"`
+	MemStats                map[string]uint64 `yaml:"memstats"` // maximum of each named MemStatsFields field
+}
+
+// Limits for the objects whose type names match Pattern, a glob or /regex/
+type TypeRule struct {
+	Pattern    string  `yaml:"pattern"`
+	MaxObjects *uint64 `yaml:"max_objects"`
+	MaxBytes   *uint64 `yaml:"max_bytes"`
+}
+
+// Limits for the goroutines, in total and in each group with identical stacks
+type GoroutineRule struct {
+	MaxTotal    *uint64 `yaml:"max_total"`
+	MaxPerStack *uint64 `yaml:"max_per_stack"`
+}
+
+// The outcome of checking one threshold
+type CheckResult struct {
+	Rule   string
+	Limit  float64
+	Actual float64
+	Passed bool
+	Detail string // what exceeded the limit, for some failures
+}
+
+// Returns an error if a rule is invalid
+func (r *Rules) Validate() error {
+	for _, rule := range r.Types {
+		if _, err := CompileNamePattern(rule.Pattern); err != nil {
+			return fmt.Errorf("types %q: %s", rule.Pattern, err)
+		}
+	}
+	for _, name := range sortedKeys(r.MemStats) {
+		if !isMemStatsField(name) {
+			return fmt.Errorf("memstats: unknown statistic %q, expected one of %s", name, strings.Join(MemStatsFields, ", "))
+		}
+	}
+	return nil
+}
+
+func isMemStatsField(name string) bool {
+	for _, field := range MemStatsFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the heap against each threshold that is set, in the order they appear in Rules.
+// Returns an error if a rule is invalid or the heap file lacks the memstats a rule needs.
+func (h *HeapFile) Check(rules *Rules) ([]*CheckResult, error) {
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+	h.parse()
+	if h.memStats == nil && (rules.MaxFragmentation != nil || len(rules.MemStats) > 0) {
+		return nil, errors.New("the heap file has no memstats")
+	}
+	results := make([]*CheckResult, 0)
+	add := func(rule string, limit, actual float64, detail string) {
+		results = append(results, &CheckResult{rule, limit, actual, actual <= limit, detail})
+	}
+
+	for _, rule := range rules.Types {
+		pattern, _ := CompileNamePattern(rule.Pattern)
+		count, bytes := uint64(0), uint64(0)
+		for _, object := range h.sortedObjects {
+			if pattern.MatchString(object.Name()) {
+				count++
+				bytes += uint64(object.Size)
+			}
+		}
+		if rule.MaxObjects != nil {
+			add(fmt.Sprintf("types[%s].max_objects", rule.Pattern), float64(*rule.MaxObjects), float64(count), "")
+		}
+		if rule.MaxBytes != nil {
+			add(fmt.Sprintf("types[%s].max_bytes", rule.Pattern), float64(*rule.MaxBytes), float64(bytes), "")
+		}
+	}
+
+	if rule := rules.Goroutines; rule != nil {
+		if rule.MaxTotal != nil {
+			add("goroutines.max_total", float64(*rule.MaxTotal), float64(len(h.goroutines)), "")
+		}
+		if rule.MaxPerStack != nil {
+			stacks := make(map[string]int, 0)
+			largest := ""
+			for _, g := range h.goroutines {
+				names := make([]string, 0)
+				for _, frame := range g.StackFrames() {
+					names = append(names, frame.Name)
+				}
+				stack := strings.Join(names, " <- ")
+				if stack == "" {
+					stack = "<no stack frames>"
+				}
+				stacks[stack]++
+				if stacks[stack] > stacks[largest] {
+					largest = stack
+				}
+			}
+			add("goroutines.max_per_stack", float64(*rule.MaxPerStack), float64(stacks[largest]), largest)
+		}
+	}
+
+	if rules.MaxGarbageBytes != nil {
+		bytes := uint64(0)
+		for _, object := range h.Garbage() {
+			bytes += uint64(object.Size)
+		}
+		add("max_garbage_bytes", float64(*rules.MaxGarbageBytes), float64(bytes), "")
+	}
+
+	if rules.MaxFragmentedBytes != nil {
+		fragmented := uint64(0)
+		for _, fragment := range h.Fragments() {
+			fragmented += fragment.Size()
+		}
+		add("max_fragmented_bytes", float64(*rules.MaxFragmentedBytes), float64(fragmented), "")
+	}
+
+	// The heap's address range spans unused arenas in go1.7 dumps, so the space objects don't
+	// occupy is measured within the spans in use
+	if rules.MaxFragmentation != nil {
+		used := uint64(0)
+		for _, object := range h.sortedObjects {
+			used += uint64(object.Size)
+		}
+		fraction := 0.0
+		if inuse := h.memStats.HeapInuse; inuse > used {
+			fraction = float64(inuse-used) / float64(inuse)
+		}
+		add("max_fragmentation", *rules.MaxFragmentation, fraction, "")
+	}
+
+	if rules.MaxDuplicateStringWaste != nil {
+		waste := uint64(0)
+		for _, dup := range h.DuplicateStrings(0) {
+			waste += dup.WastedBytes
+		}
+		add("max_duplicate_string_waste", float64(*rules.MaxDuplicateStringWaste), float64(waste), "")
+	}
+
+	if len(rules.MemStats) > 0 {
+		memstats := reflect.Indirect(reflect.ValueOf(h.memStats))
+		for _, name := range sortedKeys(rules.MemStats) {
+			add("memstats."+name, float64(rules.MemStats[name]), float64(memstats.FieldByName(name).Uint()), "")
+		}
+	}
+
+	return results, nil
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,56 @@
+package heapfile
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCheckFragmentationAndMemStats(t *testing.T) {
+	h := openTestDump(t, dumpHeader13, testParams13,
+		testMemStats(&runtime.MemStats{HeapInuse: 0x400, HeapObjects: 2, NumGC: 3}),
+		testRecord{1, 0xc000, 0, 0, strings.Repeat("a", 0x100)},
+		testRecord{1, 0xc200, 0, 0, strings.Repeat("b", 0x200)},
+	)
+	defer os.Remove(h.path)
+
+	fragmentation := 0.2
+	rules := &Rules{MaxFragmentation: &fragmentation, MemStats: map[string]uint64{"NumGC": 3, "HeapObjects": 1}}
+	results, err := h.Check(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		rule   string
+		actual float64
+		passed bool
+	}{
+		{"max_fragmentation", 0.25, false}, // 0x100 of the 0x400 bytes in use hold no object
+		{"memstats.HeapObjects", 2, false},
+		{"memstats.NumGC", 3, true},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("%d results, expected %d", len(results), len(expected))
+	}
+	for i, result := range results {
+		if result.Rule != expected[i].rule || result.Actual != expected[i].actual || result.Passed != expected[i].passed {
+			t.Errorf("result %+v, expected %+v", result, expected[i])
+		}
+	}
+}
+
+func TestCheckRejectsUnknownMemStats(t *testing.T) {
+	for _, name := range []string{"EnableGC", "PauseNs", "BySize", "heapinuse"} {
+		rules := &Rules{MemStats: map[string]uint64{name: 1}}
+		if err := rules.Validate(); err == nil {
+			t.Errorf("memstats.%s is valid, expected an error", name)
+		}
+	}
+	for _, name := range MemStatsFields {
+		rules := &Rules{MemStats: map[string]uint64{name: 1}}
+		if err := rules.Validate(); err != nil {
+			t.Errorf("memstats.%s: %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package heapfile
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Differences between two heap dumps of the same program. Objects move between dumps, so
+// nothing is matched by address; objects are grouped by type, allocation site and root instead,
+// and paired with Match to count the ones that survived, were freed or were allocated.
+type HeapDiff struct {
+	Old        string            `json:"old"`
+	New        string            `json:"new"`
+	Survived   int64             `json:"survived"`
+	Freed      int64             `json:"freed"`
+	Allocated  int64             `json:"allocated"`
+	Types      []*TypeDelta      `json:"types"`
+	Sites      []*SiteDelta      `json:"sites"`
+	Roots      []*RootDelta      `json:"roots"`
+	Goroutines []*GoroutineDelta `json:"goroutines"`
+	MemStats   []*MemStatDelta   `json:"memstats"`
+}
+
+// Change in the number and size of the objects of a type
+type TypeDelta struct {
+	Name       string `json:"name"`
+	OldCount   int64  `json:"old_count"`
+	NewCount   int64  `json:"new_count"`
+	CountDelta int64  `json:"count_delta"`
+	OldBytes   int64  `json:"old_bytes"`
+	NewBytes   int64  `json:"new_bytes"`
+	BytesDelta int64  `json:"bytes_delta"`
+	Survived   int64  `json:"survived"`
+	Freed      int64  `json:"freed"`
+	Allocated  int64  `json:"allocated"`
+}
+
+// Change in the memory profile of an allocation site. A site is a call stack and an object
+// size. InUse counts come from the profile; Samples counts the sampled objects still in the heap.
+type SiteDelta struct {
+	Stack        []string `json:"stack"`
+	Size         uint64   `json:"size"`
+	OldInUse     int64    `json:"old_inuse"`
+	NewInUse     int64    `json:"new_inuse"`
+	InUseDelta   int64    `json:"inuse_delta"`
+	BytesDelta   int64    `json:"bytes_delta"`
+	OldSamples   int64    `json:"old_samples"`
+	NewSamples   int64    `json:"new_samples"`
+	SamplesDelta int64    `json:"samples_delta"`
+}
+
+// Growth in the number of objects of a type held directly by a kind of root
+type RootDelta struct {
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	OldCount    int64  `json:"old_count"`
+	NewCount    int64  `json:"new_count"`
+	CountDelta  int64  `json:"count_delta"`
+}
+
+// Change in the number of goroutines with the same stack
+type GoroutineDelta struct {
+	Stack      []string `json:"stack"`
+	OldCount   int64    `json:"old_count"`
+	NewCount   int64    `json:"new_count"`
+	CountDelta int64    `json:"count_delta"`
+}
+
+// Change in a runtime.MemStats counter
+type MemStatDelta struct {
+	Name  string `json:"name"`
+	Old   uint64 `json:"old"`
+	New   uint64 `json:"new"`
+	Delta int64  `json:"delta"`
+}
+
+// Compares two heap dumps. Only entries that changed are reported. Types, sites, roots
+// and goroutines are sorted by growth, largest first; memstats are in struct order.
+func Diff(older, newer *HeapFile) *HeapDiff {
+	older.parse()
+	newer.parse()
+
+	matching := Match(older, newer)
+	return &HeapDiff{
+		Old:        older.Name,
+		New:        newer.Name,
+		Survived:   int64(len(matching.Survived)),
+		Freed:      int64(len(matching.Freed)),
+		Allocated:  int64(len(matching.Allocated)),
+		Types:      diffTypes(older, newer, matching),
+		Sites:      diffSites(older, newer),
+		Roots:      diffRoots(older, newer),
+		Goroutines: diffGoroutines(older, newer),
+		MemStats:   diffMemStats(older.memStats, newer.memStats),
+	}
+}
+
+func diffTypes(older, newer *HeapFile, matching *Matching) []*TypeDelta {
+	deltas := make(map[string]*TypeDelta, 0)
+	delta := func(object *Object) *TypeDelta {
+		name := object.Name()
+		d, ok := deltas[name]
+		if !ok {
+			d = &TypeDelta{Name: name}
+			deltas[name] = d
+		}
+		return d
+	}
+	for _, object := range older.objectList {
+		d := delta(object)
+		d.OldCount++
+		d.OldBytes += int64(object.Size)
+	}
+	for _, object := range newer.objectList {
+		d := delta(object)
+		d.NewCount++
+		d.NewBytes += int64(object.Size)
+	}
+	for _, match := range matching.Survived {
+		delta(match.Old).Survived++
+	}
+	for _, object := range matching.Freed {
+		delta(object).Freed++
+	}
+	for _, object := range matching.Allocated {
+		delta(object).Allocated++
+	}
+
+	types := make([]*TypeDelta, 0, len(deltas))
+	for _, d := range deltas {
+		d.CountDelta = d.NewCount - d.OldCount
+		d.BytesDelta = d.NewBytes - d.OldBytes
+		if d.CountDelta != 0 || d.BytesDelta != 0 || d.Freed != 0 || d.Allocated != 0 {
+			types = append(types, d)
+		}
+	}
+	sort.Sort(typesByGrowth(types))
+	return types
+}
+
+func diffSites(older, newer *HeapFile) []*SiteDelta {
+	deltas := make(map[string]*SiteDelta, 0)
+	site := func(profile *Profile) *SiteDelta {
+		key, stack := profileSite(profile)
+		delta, ok := deltas[key]
+		if !ok {
+			delta = &SiteDelta{Stack: stack, Size: profile.Size}
+			deltas[key] = delta
+		}
+		return delta
+	}
+	count := func(h *HeapFile, old bool) {
+		for _, profile := range h.memProf {
+			delta := site(profile)
+			inUse := int64(profile.Allocs) - int64(profile.Frees)
+			if old {
+				delta.OldInUse += inUse
+			} else {
+				delta.NewInUse += inUse
+			}
+		}
+		for _, alloc := range h.allocs {
+			profile := alloc.Profile()
+			if profile == nil || alloc.Object() == nil {
+				continue
+			}
+			delta := site(profile)
+			if old {
+				delta.OldSamples++
+			} else {
+				delta.NewSamples++
+			}
+		}
+	}
+	count(older, true)
+	count(newer, false)
+
+	sites := make([]*SiteDelta, 0, len(deltas))
+	for _, delta := range deltas {
+		delta.InUseDelta = delta.NewInUse - delta.OldInUse
+		delta.BytesDelta = delta.InUseDelta * int64(delta.Size)
+		delta.SamplesDelta = delta.NewSamples - delta.OldSamples
+		if delta.InUseDelta != 0 || delta.SamplesDelta != 0 {
+			sites = append(sites, delta)
+		}
+	}
+	sort.Sort(sitesByGrowth(sites))
+	return sites
+}
+
+// Returns a key identifying the allocation site of a profile record across dumps, and its
+// stack as "function file:line" strings. Record identifiers differ between dumps.
+func profileSite(profile *Profile) (string, []string) {
+	stack := profile.Stack()
+	return fmt.Sprintf("%d\n%s", profile.Size, strings.Join(stack, "\n")), stack
+}
+
+// Roots are matched by description and the type of the object they point to, since
+// the objects themselves will have moved. Only roots that grew are reported.
+func diffRoots(older, newer *HeapFile) []*RootDelta {
+	type rootKey struct {
+		description string
+		typeName    string
+	}
+	deltas := make(map[rootKey]*RootDelta, 0)
+	count := func(h *HeapFile, old bool) {
+		h.forEachRoot(func(description string, object *Object) {
+			if object == nil {
+				return
+			}
+			key := rootKey{description, object.Name()}
+			delta, ok := deltas[key]
+			if !ok {
+				delta = &RootDelta{Description: description, Type: object.Name()}
+				deltas[key] = delta
+			}
+			if old {
+				delta.OldCount++
+			} else {
+				delta.NewCount++
+			}
+		})
+	}
+	count(older, true)
+	count(newer, false)
+
+	roots := make([]*RootDelta, 0)
+	for _, delta := range deltas {
+		delta.CountDelta = delta.NewCount - delta.OldCount
+		if delta.CountDelta > 0 {
+			roots = append(roots, delta)
+		}
+	}
+	sort.Sort(rootsByGrowth(roots))
+	return roots
+}
+
+// Goroutines are grouped by the function names on their stacks, innermost first. Goroutines
+// without stack frames are grouped by the location that created them.
+func diffGoroutines(older, newer *HeapFile) []*GoroutineDelta {
+	deltas := make(map[string]*GoroutineDelta, 0)
+	count := func(h *HeapFile, old bool) {
+		for _, g := range h.goroutines {
+			stack := make([]string, 0)
+			for _, frame := range g.StackFrames() {
+				stack = append(stack, frame.Name)
+			}
+			if len(stack) == 0 {
+				created := h.FunctionAt(g.Location)
+				if created == "" {
+					created = fmt.Sprintf("0x%x", g.Location)
+				}
+				stack = append(stack, "created by "+created)
+			}
+			key := strings.Join(stack, "\n")
+			delta, ok := deltas[key]
+			if !ok {
+				delta = &GoroutineDelta{Stack: stack}
+				deltas[key] = delta
+			}
+			if old {
+				delta.OldCount++
+			} else {
+				delta.NewCount++
+			}
+		}
+	}
+	count(older, true)
+	count(newer, false)
+
+	goroutines := make([]*GoroutineDelta, 0, len(deltas))
+	for _, delta := range deltas {
+		delta.CountDelta = delta.NewCount - delta.OldCount
+		if delta.CountDelta != 0 {
+			goroutines = append(goroutines, delta)
+		}
+	}
+	sort.Sort(goroutinesByGrowth(goroutines))
+	return goroutines
+}
+
+// Compares the counters of two MemStats that dumps record. The PauseNs ring buffer is
+// skipped.
+func diffMemStats(older, newer *runtime.MemStats) []*MemStatDelta {
+	stats := make([]*MemStatDelta, 0)
+	o := reflect.Indirect(reflect.ValueOf(older))
+	n := reflect.Indirect(reflect.ValueOf(newer))
+	if !o.IsValid() || !n.IsValid() {
+		return stats
+	}
+	for _, name := range MemStatsFields {
+		oldValue := o.FieldByName(name).Uint()
+		newValue := n.FieldByName(name).Uint()
+		if oldValue != newValue {
+			stats = append(stats, &MemStatDelta{name, oldValue, newValue, int64(newValue - oldValue)})
+		}
+	}
+	return stats
+}
+
+type typesByGrowth []*TypeDelta
+
+func (t typesByGrowth) Len() int      { return len(t) }
+func (t typesByGrowth) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t typesByGrowth) Less(i, j int) bool {
+	if t[i].BytesDelta != t[j].BytesDelta {
+		return t[i].BytesDelta > t[j].BytesDelta
+	}
+	if t[i].CountDelta != t[j].CountDelta {
+		return t[i].CountDelta > t[j].CountDelta
+	}
+	return t[i].Name < t[j].Name
+}
+
+type sitesByGrowth []*SiteDelta
+
+func (s sitesByGrowth) Len() int      { return len(s) }
+func (s sitesByGrowth) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sitesByGrowth) Less(i, j int) bool {
+	if s[i].BytesDelta != s[j].BytesDelta {
+		return s[i].BytesDelta > s[j].BytesDelta
+	}
+	if s[i].SamplesDelta != s[j].SamplesDelta {
+		return s[i].SamplesDelta > s[j].SamplesDelta
+	}
+	return strings.Join(s[i].Stack, "\n") < strings.Join(s[j].Stack, "\n")
+}
+
+type rootsByGrowth []*RootDelta
+
+func (r rootsByGrowth) Len() int      { return len(r) }
+func (r rootsByGrowth) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r rootsByGrowth) Less(i, j int) bool {
+	if r[i].CountDelta != r[j].CountDelta {
+		return r[i].CountDelta > r[j].CountDelta
+	}
+	if r[i].Description != r[j].Description {
+		return r[i].Description < r[j].Description
+	}
+	return r[i].Type < r[j].Type
+}
+
+type goroutinesByGrowth []*GoroutineDelta
+
+func (g goroutinesByGrowth) Len() int      { return len(g) }
+func (g goroutinesByGrowth) Swap(i, j int) { g[i], g[j] = g[j], g[i] }
+func (g goroutinesByGrowth) Less(i, j int) bool {
+	if g[i].CountDelta != g[j].CountDelta {
+		return g[i].CountDelta > g[j].CountDelta
+	}
+	return strings.Join(g[i].Stack, "\n") < strings.Join(g[j].Stack, "\n")
+}
@@ -0,0 +1,142 @@
+package heapfile
+
+// Returns the immediate dominator of the object: the closest object that every path from
+// the GC roots to it passes through. Returns nil if only the roots dominate the object, or
+// if it is unreachable.
+func (h *HeapFile) Dominator(o *Object) *Object {
+	h.parse()
+	h.findDominators()
+	return h.dominators[o.Address]
+}
+
+// Returns the number of bytes that would be freed if the object were freed: its own size
+// plus the size of every object it dominates. Unreachable objects retain nothing.
+func (h *HeapFile) RetainedSize(o *Object) uint64 {
+	h.parse()
+	h.findDominators()
+	return h.retained[o.Address]
+}
+
+// Builds the dominator tree of the object graph with the iterative algorithm from Cooper,
+// Harvey and Kennedy's "A Simple, Fast Dominance Algorithm". Node 0 is a virtual root that
+// points to every object a GC root points to; object i is node i+1 in address order.
+func (h *HeapFile) findDominators() {
+	if h.dominators != nil {
+		return
+	}
+
+	objects := h.sortedObjects
+	index := make(map[uint64]int, len(objects))
+	for i, object := range objects {
+		index[object.Address] = i + 1
+	}
+
+	successors := make([][]int, len(objects)+1)
+	h.forEachRoot(func(description string, object *Object) {
+		if object != nil {
+			successors[0] = append(successors[0], index[object.Address])
+		}
+	})
+	for i, object := range objects {
+		for _, child := range object.Children() {
+			successors[i+1] = append(successors[i+1], index[child.Address])
+		}
+	}
+
+	// Depth first search from the root, numbering nodes in postorder
+	const unvisited = -1
+	postorder := make([]int, len(objects)+1)
+	for i := range postorder {
+		postorder[i] = unvisited
+	}
+	visited := make([]bool, len(objects)+1)
+	predecessors := make([][]int, len(objects)+1)
+	order := make([]int, 0, len(objects)+1) // nodes in postorder
+
+	type dfsFrame struct {
+		node int
+		next int
+	}
+	stack := []dfsFrame{{0, 0}}
+	visited[0] = true
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.next < len(successors[top.node]) {
+			succ := successors[top.node][top.next]
+			top.next++
+			predecessors[succ] = append(predecessors[succ], top.node)
+			if !visited[succ] {
+				visited[succ] = true
+				stack = append(stack, dfsFrame{succ, 0})
+			}
+			continue
+		}
+		postorder[top.node] = len(order)
+		order = append(order, top.node)
+		stack = stack[:len(stack)-1]
+	}
+
+	idom := make([]int, len(objects)+1)
+	for i := range idom {
+		idom[i] = unvisited
+	}
+	idom[0] = 0
+
+	intersect := func(a, b int) int {
+		for a != b {
+			for postorder[a] < postorder[b] {
+				a = idom[a]
+			}
+			for postorder[b] < postorder[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		// Reverse postorder, skipping the root
+		for i := len(order) - 2; i >= 0; i-- {
+			node := order[i]
+			newIdom := unvisited
+			for _, pred := range predecessors[node] {
+				if idom[pred] == unvisited {
+					continue
+				}
+				if newIdom == unvisited {
+					newIdom = pred
+				} else {
+					newIdom = intersect(pred, newIdom)
+				}
+			}
+			if idom[node] != newIdom {
+				idom[node] = newIdom
+				changed = true
+			}
+		}
+	}
+
+	// Children come before their dominators in postorder, so sizes can be summed in one pass
+	retained := make([]uint64, len(objects)+1)
+	for _, node := range order {
+		if node == 0 {
+			continue
+		}
+		retained[node] += uint64(objects[node-1].Size)
+		retained[idom[node]] += retained[node]
+	}
+
+	h.dominators = make(map[uint64]*Object, len(order))
+	h.retained = make(map[uint64]uint64, len(order))
+	for _, node := range order {
+		if node == 0 {
+			continue
+		}
+		object := objects[node-1]
+		if idom[node] != 0 {
+			h.dominators[object.Address] = objects[idom[node]-1]
+		}
+		h.retained[object.Address] = retained[node]
+	}
+}
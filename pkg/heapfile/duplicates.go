@@ -0,0 +1,212 @@
+package heapfile
+
+import (
+	"crypto/sha1"
+	"sort"
+)
+
+// Maximum number of referrers kept for each duplicate
+const maxDuplicateReferrers = 5
+
+// Identical string contents stored in more than one heap object
+type DuplicateString struct {
+	Text        string    // contents of the string
+	Count       int       // number of copies
+	WastedBytes uint64    // bytes used by all copies but one
+	Copies      []*Object // heap objects holding the copies
+	Referrers   []*Object // sample of objects whose string headers point at the copies
+}
+
+// Finds strings whose contents are stored in more than one heap object. String headers are
+// found in string fields of objects, the data segment, the bss and stack frames. Only
+// duplicates wasting at least minWaste bytes are returned, most wasteful first.
+func (h *HeapFile) DuplicateStrings(minWaste uint64) []*DuplicateString {
+	h.parse()
+	groups := make(map[string]*DuplicateString, 0)
+
+	add := func(referrer *Object, content string) {
+		value := h.decodeField(FieldStr, content, 0)
+		if value.Object == nil || value.Len == 0 {
+			return
+		}
+
+		text := value.FullText()
+		dup, ok := groups[text]
+		if !ok {
+			dup = &DuplicateString{Text: text}
+			groups[text] = dup
+		}
+		if !containsObject(dup.Copies, value.Object) {
+			dup.Copies = append(dup.Copies, value.Object)
+		}
+		if referrer != nil && len(dup.Referrers) < maxDuplicateReferrers && !containsObject(dup.Referrers, referrer) {
+			dup.Referrers = append(dup.Referrers, referrer)
+		}
+	}
+
+	for _, object := range h.objectList {
+		object.forEachField(FieldStr, func(offset uint64) {
+			add(object, object.Content[offset:offset+h.fieldSize(FieldStr)])
+		})
+	}
+	for _, segment := range []*Segment{h.dataSegment, h.bss} {
+		h.forEachFieldIn(segment.Fields, segment.Content, FieldStr, func(offset uint64) {
+			add(nil, segment.Content[offset:offset+h.fieldSize(FieldStr)])
+		})
+	}
+	for _, frame := range h.stackFrames {
+		h.forEachFieldIn(frame.FieldList, frame.Content, FieldStr, func(offset uint64) {
+			add(nil, frame.Content[offset:offset+h.fieldSize(FieldStr)])
+		})
+	}
+
+	dups := make([]*DuplicateString, 0)
+	for _, dup := range groups {
+		if len(dup.Copies) < 2 {
+			continue
+		}
+		dup.Count = len(dup.Copies)
+		dup.WastedBytes = uint64(len(dup.Text)) * uint64(dup.Count-1)
+		if dup.WastedBytes >= minWaste {
+			dups = append(dups, dup)
+		}
+	}
+	sort.Sort(stringsByWaste(dups))
+	return dups
+}
+
+// Objects of the same type and size with identical contents
+type DuplicateObjects struct {
+	Type        *Type     // type of the objects, or nil if unknown
+	Size        int       // size of each object
+	Objects     []*Object // the identical objects
+	WastedBytes uint64    // bytes used by all copies but one
+}
+
+// Finds objects of the same type and size whose contents are identical. If ignorePointers
+// is true, pointer words (as described by the type's field list) are not compared, so
+// objects with identical values pointing at different copies of the same data match.
+// Only clusters wasting at least minWaste bytes are returned, most wasteful first.
+func (h *HeapFile) DuplicateObjects(ignorePointers bool, minWaste uint64) []*DuplicateObjects {
+	h.parse()
+
+	type duplicateKey struct {
+		typeAddress uint64
+		size        int
+		hash        [sha1.Size]byte
+	}
+	groups := make(map[duplicateKey]*DuplicateObjects, 0)
+
+	for _, object := range h.objectList {
+		if object.Size == 0 {
+			continue
+		}
+
+		content := object.Content
+		if ignorePointers {
+			content = object.maskPointers()
+		}
+
+		key := duplicateKey{object.TypeAddress, object.Size, sha1.Sum([]byte(content))}
+		dup, ok := groups[key]
+		if !ok {
+			dup = &DuplicateObjects{Type: object.Type, Size: object.Size}
+			groups[key] = dup
+		}
+		dup.Objects = append(dup.Objects, object)
+	}
+
+	dups := make([]*DuplicateObjects, 0)
+	for _, dup := range groups {
+		if len(dup.Objects) < 2 {
+			continue
+		}
+		dup.WastedBytes = uint64(dup.Size) * uint64(len(dup.Objects)-1)
+		if dup.WastedBytes >= minWaste {
+			sort.Sort(objectsByAddress(dup.Objects))
+			dups = append(dups, dup)
+		}
+	}
+	sort.Sort(objectsByWaste(dups))
+	return dups
+}
+
+// Returns the object's contents with the pointer word of each pointer containing field zeroed.
+// The type word of interfaces and the length and capacity of strings and slices are kept.
+func (o *Object) maskPointers() string {
+	content := []byte(o.Content)
+	zero := func(offset uint64) {
+		for i := offset; i < offset+o.heap.dumpParams.PtrSize; i++ {
+			content[i] = 0
+		}
+	}
+
+	for _, kind := range []uint64{FieldPtr, FieldStr, FieldSlice} {
+		o.forEachField(kind, zero)
+	}
+	for _, kind := range []uint64{FieldIface, FieldEface} {
+		o.forEachField(kind, func(offset uint64) {
+			zero(offset + o.heap.dumpParams.PtrSize)
+		})
+	}
+	return string(content)
+}
+
+// Calls fn with the offset of each field of the given kind in the object. Arrays have
+// their element type's fields repeated for each element.
+func (o *Object) forEachField(kind uint64, fn func(offset uint64)) {
+	if o.Type == nil || o.Type.Size == 0 || o.kind == 2 {
+		return
+	}
+
+	elements := uint64(1)
+	if o.kind == 1 {
+		elements = uint64(o.Size) / o.Type.Size
+	}
+	for i := uint64(0); i < elements; i++ {
+		base := i * o.Type.Size
+		o.heap.forEachFieldIn(o.Type.FieldList, o.Content[base:], kind, func(offset uint64) {
+			fn(base + offset)
+		})
+	}
+}
+
+func containsObject(objects []*Object, o *Object) bool {
+	for _, object := range objects {
+		if object == o {
+			return true
+		}
+	}
+	return false
+}
+
+// Calls fn with the offset of each field of the given kind that fits in content
+func (h *HeapFile) forEachFieldIn(fields []*Field, content string, kind uint64, fn func(offset uint64)) {
+	for _, field := range fields {
+		if field.Kind == kind && field.Offset+h.fieldSize(kind) <= uint64(len(content)) {
+			fn(field.Offset)
+		}
+	}
+}
+
+type stringsByWaste []*DuplicateString
+
+func (s stringsByWaste) Len() int      { return len(s) }
+func (s stringsByWaste) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s stringsByWaste) Less(i, j int) bool {
+	if s[i].WastedBytes == s[j].WastedBytes {
+		return s[i].Text < s[j].Text
+	}
+	return s[i].WastedBytes > s[j].WastedBytes
+}
+
+type objectsByWaste []*DuplicateObjects
+
+func (o objectsByWaste) Len() int      { return len(o) }
+func (o objectsByWaste) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+func (o objectsByWaste) Less(i, j int) bool {
+	if o[i].WastedBytes == o[j].WastedBytes {
+		return o[i].Objects[0].Address < o[j].Objects[0].Address
+	}
+	return o[i].WastedBytes > o[j].WastedBytes
+}
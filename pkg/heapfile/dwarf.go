@@ -0,0 +1,573 @@
+package heapfile
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"debug/macho"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Attribute the Go linker adds to DWARF types, locating their runtime type descriptor. Recent
+// linkers write it as an offset from runtime.types, older ones as an address.
+const attrGoRuntimeType dwarf.Attr = 0x2904
+
+// DWARF location expression operations used by the Go compiler
+const (
+	opAddr  = 0x03 // absolute address, for global variables
+	opFbreg = 0x91 // offset from the frame base, the CFA, for stack variables
+)
+
+// The DWARF information of the binary that wrote a dump
+type binaryInfo struct {
+	data         *dwarf.Data
+	symbols      map[string]uint64
+	runtimeTypes map[uint64]dwarf.Offset // DWARF type of each runtime type descriptor address
+	typeAddrs    map[dwarf.Type]uint64   // the reverse
+	globals      []*variable             // global variables
+	locals       map[uint64][]*variable  // stack variables, by function entry pc
+	fields       map[dwarf.Type][]*Field // field lists of the types seen so far
+}
+
+// A variable with a DWARF type. Globals have an address; stack variables have an offset
+// from their frame's CFA.
+type variable struct {
+	location uint64
+	typ      dwarf.Offset
+}
+
+var errNoDWARF = errors.New("it has no DWARF information, it was linked with -w")
+
+// Opens the DWARF information and symbols of an ELF or Mach-O executable
+func openBinary(path string) (*binaryInfo, error) {
+	info := &binaryInfo{symbols: make(map[string]uint64, 0)}
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		if f.Section(".debug_info") == nil && f.Section(".zdebug_info") == nil {
+			return nil, errNoDWARF
+		}
+		if info.data, err = f.DWARF(); err != nil {
+			return nil, err
+		}
+		symbols, _ := f.Symbols()
+		for _, symbol := range symbols {
+			info.symbols[symbol.Name] = symbol.Value
+		}
+		return info, nil
+	}
+
+	f, err := macho.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not an ELF or Mach-O executable", path)
+	}
+	defer f.Close()
+	if info.data, err = f.DWARF(); err != nil {
+		return nil, err
+	}
+	if f.Symtab != nil {
+		for _, symbol := range f.Symtab.Syms {
+			info.symbols[strings.TrimPrefix(symbol.Name, "_")] = symbol.Value
+		}
+	}
+	return info, nil
+}
+
+// Reads the variables and the runtime type descriptors of the DWARF types. Addresses are
+// moved by slide, the difference between where the binary was loaded and its link address.
+func (b *binaryInfo) read(h *HeapFile, slide uint64) error {
+	b.runtimeTypes = make(map[uint64]dwarf.Offset, 0)
+	b.typeAddrs = make(map[dwarf.Type]uint64, 0)
+	b.globals = make([]*variable, 0)
+	b.locals = make(map[uint64][]*variable, 0)
+	b.fields = make(map[dwarf.Type][]*Field, 0)
+	types := b.symbols["runtime.types"]
+
+	r := b.data.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			break
+		}
+
+		switch e.Tag {
+		case dwarf.TagCompileUnit:
+			continue
+		case dwarf.TagVariable:
+			loc, _ := e.Val(dwarf.AttrLocation).([]byte)
+			typ, ok := e.Val(dwarf.AttrType).(dwarf.Offset)
+			if ok && uint64(len(loc)) == 1+h.dumpParams.PtrSize && loc[0] == opAddr {
+				b.globals = append(b.globals, &variable{h.readUint(string(loc[1:])) + slide, typ})
+			}
+		case dwarf.TagSubprogram:
+			entry, ok := e.Val(dwarf.AttrLowpc).(uint64)
+			if e.Children {
+				locals := readLocals(r)
+				if ok {
+					b.locals[entry+slide] = locals
+				}
+			}
+			continue
+		default:
+			if addr, ok := e.Val(attrGoRuntimeType).(uint64); ok && addr != 0 {
+				if addr < types {
+					addr += types
+				}
+				b.runtimeTypes[addr+slide] = e.Offset
+			}
+		}
+		if e.Children {
+			r.SkipChildren()
+		}
+	}
+
+	// The data caches types, so each type has one value to look up
+	for addr, offset := range b.runtimeTypes {
+		if t, err := b.data.Type(offset); err == nil {
+			b.typeAddrs[t] = addr
+		}
+	}
+	return nil
+}
+
+// Reads the frame base relative variables of a function, skipping inlined functions
+func readLocals(r *dwarf.Reader) []*variable {
+	locals := make([]*variable, 0)
+	for depth := 1; depth > 0; {
+		e, err := r.Next()
+		if err != nil || e == nil {
+			return locals
+		}
+		switch e.Tag {
+		case 0:
+			depth--
+			continue
+		case dwarf.TagInlinedSubroutine:
+			if e.Children {
+				r.SkipChildren()
+			}
+			continue
+		case dwarf.TagVariable, dwarf.TagFormalParameter:
+			loc, _ := e.Val(dwarf.AttrLocation).([]byte)
+			typ, ok := e.Val(dwarf.AttrType).(dwarf.Offset)
+			if ok && len(loc) > 1 && loc[0] == opFbreg {
+				if offset, n := readSleb128(loc[1:]); n == len(loc)-1 {
+					locals = append(locals, &variable{uint64(offset), typ})
+				}
+			}
+		}
+		if e.Children {
+			depth++
+		}
+	}
+	return locals
+}
+
+func readSleb128(b []byte) (int64, int) {
+	var v int64
+	var shift uint
+	for i, c := range b {
+		v |= int64(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			if shift < 64 && c&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// Returns the address of the runtime type descriptor of t, looking through typedefs
+func (b *binaryInfo) typeAddress(t dwarf.Type) uint64 {
+	for t != nil {
+		if addr, ok := b.typeAddrs[t]; ok {
+			return addr
+		}
+		typedef, ok := t.(*dwarf.TypedefType)
+		if !ok {
+			return 0
+		}
+		t = typedef.Type
+	}
+	return 0
+}
+
+// Returns the DWARF type of a runtime type descriptor, or nil if it isn't known
+func (b *binaryInfo) runtimeType(addr uint64) dwarf.Type {
+	offset, ok := b.runtimeTypes[addr]
+	if !ok {
+		return nil
+	}
+	t, err := b.data.Type(offset)
+	if err != nil {
+		return nil
+	}
+	return t
+}
+
+// Returns the pointer containing fields of t, in the kinds of go1.3 field lists
+func (b *binaryInfo) fieldList(t dwarf.Type) []*Field {
+	if fields, ok := b.fields[t]; ok {
+		return fields
+	}
+	fields := b.appendFields(make([]*Field, 0), t, 0)
+	b.fields[t] = fields
+	return fields
+}
+
+func (b *binaryInfo) appendFields(fields []*Field, t dwarf.Type, offset uint64) []*Field {
+	switch t := t.(type) {
+	case *dwarf.TypedefType:
+		return b.appendFields(fields, t.Type, offset)
+	case *dwarf.PtrType:
+		return append(fields, &Field{FieldPtr, offset, ""})
+	case *dwarf.StructType:
+		switch {
+		case isStringType(t):
+			return append(fields, &Field{FieldStr, offset, ""})
+		case isSliceType(t):
+			return append(fields, &Field{FieldSlice, offset, ""})
+		case t.StructName == "runtime.eface":
+			return append(fields, &Field{FieldEface, offset, ""})
+		case t.StructName == "runtime.iface":
+			return append(fields, &Field{FieldIface, offset, ""})
+		}
+		for _, field := range t.Field {
+			fields = b.appendFields(fields, field.Type, offset+uint64(field.ByteOffset))
+		}
+	case *dwarf.ArrayType:
+		elem := b.fieldList(t.Type)
+		if len(elem) == 0 {
+			return fields
+		}
+		for i := int64(0); i < t.Count; i++ {
+			for _, field := range elem {
+				fields = append(fields, &Field{field.Kind, offset + uint64(i*t.Type.Size()) + field.Offset, ""})
+			}
+		}
+	}
+	return fields
+}
+
+func isStringType(t *dwarf.StructType) bool {
+	return t.StructName == "string" && len(t.Field) == 2
+}
+
+func isSliceType(t *dwarf.StructType) bool {
+	return strings.HasPrefix(t.StructName, "[]") && len(t.Field) == 3
+}
+
+// Returns true if values of type t are stored directly in an interface's data word, as
+// pointer shaped types are
+func (b *binaryInfo) isDirectIface(h *HeapFile, t dwarf.Type) bool {
+	fields := b.fieldList(t)
+	return t.Size() == int64(h.dumpParams.PtrSize) && len(fields) == 1 && fields[0].Kind == FieldPtr
+}
+
+// Returns the Type of the runtime type descriptor at addr, whose DWARF type is t
+func (b *binaryInfo) newType(h *HeapFile, addr uint64, t dwarf.Type) *Type {
+	return &Type{Address: addr, Size: uint64(t.Size()), Name: typeName(t),
+		IsPtr: !b.isDirectIface(h, t) || len(b.fieldList(t)) > 0}
+}
+
+// Memory whose layout is known from a variable or an object's type
+type region struct {
+	content string
+	live    map[uint64]bool // offsets of the live pointers in a stack frame, nil elsewhere
+}
+
+// An object's type, inferred from a pointer to it
+type inferredType struct {
+	t     dwarf.Type
+	count uint64 // number of elements that fit in a slice's backing array, 0 for a single value
+}
+
+func (i *inferredType) size() uint64 {
+	if i.count > 0 {
+		return i.count * uint64(i.t.Size())
+	}
+	return uint64(i.t.Size())
+}
+
+type typeInference struct {
+	heap       *HeapFile
+	binary     *binaryInfo
+	inferred   map[uint64]*inferredType
+	ifaceTypes map[uint64]dwarf.Type // dynamic types of the interfaces visited, by type address
+	queue      []*Object
+}
+
+// Infers the types of a go1.7 dump's objects from the DWARF information of the binary named
+// in the dump's metadata or set with SetBinary. Starting from global variables, stack
+// variables and finalizers, typed pointers, slices and interfaces give the types of the
+// objects they point to, whose own fields are followed in turn. Objects only reachable
+// through untyped memory, and objects holding string data or arrays of basic types, stay
+// unknown.
+func (h *HeapFile) inferTypes() error {
+	if h.binary == "" {
+		if h.metadataError != nil {
+			return fmt.Errorf("object types are unknown, %s", h.metadataError)
+		}
+		return errors.New("the binary that wrote the dump isn't known, so object types are unknown")
+	}
+	binary := h.binary
+	if h.metadata != nil && h.metadata.BuildID != "" && readBuildID(binary) != h.metadata.BuildID {
+		return fmt.Errorf("%s is not the binary that wrote the dump, its build ID differs", binary)
+	}
+
+	info, err := openBinary(binary)
+	if err != nil {
+		return fmt.Errorf("reading types from %s: %s", binary, err)
+	}
+	var slide uint64
+	if data, ok := info.symbols["runtime.data"]; ok && h.dataSegment.Address != 0 {
+		slide = h.dataSegment.Address - data
+	}
+	if err := info.read(h, slide); err != nil {
+		return fmt.Errorf("reading types from %s: %s", binary, err)
+	}
+
+	in := &typeInference{heap: h, binary: info, inferred: make(map[uint64]*inferredType, 0),
+		ifaceTypes: make(map[uint64]dwarf.Type, 0)}
+	in.visitRoots()
+	for len(in.queue) > 0 {
+		object := in.queue[len(in.queue)-1]
+		in.queue = in.queue[:len(in.queue)-1]
+
+		inferred := in.inferred[object.Address]
+		reg := &region{content: object.Content}
+		for i := uint64(0); i == 0 || i < inferred.count; i++ {
+			in.visit(reg, i*uint64(inferred.t.Size()), inferred.t)
+		}
+	}
+
+	for addr, inferred := range in.inferred {
+		typeAddr := info.typeAddress(inferred.t)
+		if typeAddr == 0 {
+			continue
+		}
+		t := h.typeList[typeAddr]
+		if t == nil {
+			t = info.newType(h, typeAddr, inferred.t)
+			h.typeList[typeAddr] = t
+		}
+
+		object := h.objectList[addr]
+		object.Type = t
+		object.TypeAddress = typeAddr
+		object.kind = 0
+		if inferred.count > 0 {
+			object.kind = 1
+		}
+	}
+
+	// Interfaces can hold types no object has, such as pointer types
+	for typeAddr, dt := range in.ifaceTypes {
+		if h.typeList[typeAddr] == nil {
+			h.typeList[typeAddr] = info.newType(h, typeAddr, dt)
+		}
+	}
+
+	// Types from itab records have no field lists, and the runtime names pointer types to
+	// named types by their package alone
+	for addr, t := range h.typeList {
+		if dt := info.runtimeType(addr); dt != nil {
+			if t.FieldList == nil {
+				t.FieldList = info.fieldList(dt)
+			}
+			if strings.HasSuffix(t.Name, ".") {
+				t.Name = typeName(dt)
+			}
+		}
+	}
+	return nil
+}
+
+func (in *typeInference) visitRoots() {
+	h := in.heap
+	for _, global := range in.binary.globals {
+		t, err := in.binary.data.Type(global.typ)
+		if err != nil {
+			continue
+		}
+		for _, segment := range []*Segment{h.dataSegment, h.bss} {
+			if global.location >= segment.Address && global.location < segment.Address+uint64(len(segment.Content)) {
+				in.visit(&region{content: segment.Content}, global.location-segment.Address, t)
+			}
+		}
+	}
+
+	for _, frame := range h.stackFrames {
+		locals := in.binary.locals[frame.EntryPC]
+		if len(locals) == 0 {
+			continue
+		}
+		cfa := frame.StackPointer + uint64(len(frame.Content))
+		for _, local := range locals {
+			t, err := in.binary.data.Type(local.typ)
+			if err != nil {
+				continue
+			}
+			// Arguments are in the caller's frame, above the CFA
+			addr := cfa + local.location
+			for f := frame; f != nil; f = h.frameParents[f.StackPointer] {
+				if addr >= f.StackPointer && addr < f.StackPointer+uint64(len(f.Content)) {
+					in.visit(in.frameRegion(f), addr-f.StackPointer, t)
+					break
+				}
+				if addr < f.StackPointer {
+					break
+				}
+			}
+		}
+	}
+
+	for _, finalizers := range [][]*Finalizer{h.finalizers, h.queuedFinalizers} {
+		for _, f := range finalizers {
+			if t, ok := in.binary.runtimeType(f.ObjectType).(*dwarf.PtrType); ok {
+				in.assign(f.ObjectAddress, t.Type, false)
+			}
+		}
+	}
+}
+
+// Returns a stack frame's memory. Only the words the frame's pointer map says are live
+// are followed, as dead variables can hold stale pointers.
+func (in *typeInference) frameRegion(frame *StackFrame) *region {
+	live := make(map[uint64]bool, len(frame.FieldList))
+	for _, field := range frame.FieldList {
+		live[field.Offset] = true
+	}
+	return &region{content: frame.Content, live: live}
+}
+
+// Returns the pointer sized word at offset, and whether it is in the region
+func (in *typeInference) word(reg *region, offset uint64) (uint64, bool) {
+	if offset+in.heap.dumpParams.PtrSize > uint64(len(reg.content)) {
+		return 0, false
+	}
+	return in.heap.readPtr(reg.content[offset:], 0), true
+}
+
+// Returns the pointer at offset, and whether it is in the region and live
+func (in *typeInference) pointer(reg *region, offset uint64) (uint64, bool) {
+	if reg.live != nil && !reg.live[offset] {
+		return 0, false
+	}
+	return in.word(reg, offset)
+}
+
+// Follows the pointers in a value of type t at offset in the region
+func (in *typeInference) visit(reg *region, offset uint64, t dwarf.Type) {
+	switch t := t.(type) {
+	case *dwarf.TypedefType:
+		in.visit(reg, offset, t.Type)
+
+	case *dwarf.PtrType:
+		if addr, ok := in.pointer(reg, offset); ok {
+			in.assign(addr, t.Type, false)
+		}
+
+	case *dwarf.StructType:
+		switch {
+		case isStringType(t):
+			// String data has no type
+		case isSliceType(t):
+			// The whole backing array is typed, not only the slice's capacity, as the runtime
+			// did when it recorded types
+			array, ok := in.pointer(reg, offset)
+			if elem, isPtr := t.Field[0].Type.(*dwarf.PtrType); ok && isPtr {
+				in.assign(array, elem.Type, true)
+			}
+		case t.StructName == "runtime.eface":
+			if typeAddr, ok := in.word(reg, offset); ok {
+				in.visitInterface(reg, offset, typeAddr)
+			}
+		case t.StructName == "runtime.iface":
+			if tab, ok := in.word(reg, offset); ok {
+				in.visitInterface(reg, offset, in.heap.itabs[tab])
+			}
+		default:
+			for _, field := range t.Field {
+				in.visit(reg, offset+uint64(field.ByteOffset), field.Type)
+			}
+		}
+
+	case *dwarf.ArrayType:
+		if len(in.binary.fieldList(t.Type)) == 0 {
+			return
+		}
+		for i := int64(0); i < t.Count; i++ {
+			in.visit(reg, offset+uint64(i*t.Type.Size()), t.Type)
+		}
+	}
+}
+
+// Follows the data word of an interface at offset, whose dynamic type is at typeAddr
+func (in *typeInference) visitInterface(reg *region, offset, typeAddr uint64) {
+	t := in.binary.runtimeType(typeAddr)
+	if t == nil {
+		return
+	}
+	in.ifaceTypes[typeAddr] = t
+	data := offset + in.heap.dumpParams.PtrSize
+	if in.binary.isDirectIface(in.heap, t) {
+		in.visit(reg, data, t)
+	} else if addr, ok := in.pointer(reg, data); ok {
+		in.assign(addr, t, false)
+	}
+}
+
+// Records that the object at addr holds a value of type t, or if array is true as many as fit.
+// Types that are smaller than an earlier inference, or too big for the object, are ignored,
+// as are arrays of basic types.
+func (in *typeInference) assign(addr uint64, t dwarf.Type, array bool) {
+	object := in.heap.objectList[addr]
+	if object == nil || t == nil || t.Size() <= 0 {
+		return
+	}
+	size := uint64(t.Size())
+	if size > uint64(object.Size) {
+		return
+	}
+	var count uint64
+	if array {
+		if _, basic := resolveTypedefs(t).(interface{ Basic() *dwarf.BasicType }); basic {
+			return
+		}
+		count = uint64(object.Size) / size
+	}
+
+	inferred := &inferredType{t, count}
+	if previous, ok := in.inferred[addr]; ok && previous.size() >= inferred.size() {
+		return
+	}
+	in.inferred[addr] = inferred
+	in.queue = append(in.queue, object)
+}
+
+// Returns the Go name of a type, as the runtime would print it
+func typeName(t dwarf.Type) string {
+	if s, ok := t.(*dwarf.StructType); ok && s.StructName != "" {
+		return s.StructName
+	}
+	if name := t.Common().Name; name != "" {
+		return name
+	}
+	return t.String()
+}
+
+func resolveTypedefs(t dwarf.Type) dwarf.Type {
+	for {
+		typedef, ok := t.(*dwarf.TypedefType)
+		if !ok {
+			return t
+		}
+		t = typedef.Type
+	}
+}
@@ -0,0 +1,227 @@
+package heapfile
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// An item in a listing of objects, types, stack frames or histogram buckets, with the
+// attributes that ListOptions filter and sort on
+type ListEntry struct {
+	Name    string      // type name, or function name for stack frames
+	Kind    string      // object kind, empty for other entries, which the kind filter ignores
+	Address uint64      // object, type or stack frame address
+	Size    uint64      // object, type or frame size, or total bytes for histogram buckets
+	Count   uint64      // number of objects of the type, 1 for objects and frames
+	Value   interface{} // the *Object, *Type, *StackFrame or *HistogramBucket
+}
+
+// Objects of one type in a histogram
+type HistogramBucket struct {
+	Name  string
+	Count uint64
+	Bytes uint64
+}
+
+// Filters, sorts and limits listings
+type ListOptions struct {
+	Name         *regexp.Regexp // matches the entry's name, see CompileNamePattern
+	Kind         string
+	MinSize      uint64
+	MaxSize      uint64 // 0 for no limit
+	StartAddress uint64
+	EndAddress   uint64 // exclusive, 0 for no limit
+	Sort         string // size, address, count or name; empty keeps the listing's order
+	Reverse      bool   // reverses the sort order, or the listing's order if Sort is empty
+	Top          int    // 0 for all
+}
+
+var listSorts = []string{"size", "address", "count", "name"}
+
+// Compiles a name pattern. Patterns wrapped in slashes, like /^main\./, are regular
+// expressions; anything else is a glob where * matches any run of characters, ? matches one
+// character and everything else, including brackets and dots, is literal.
+func CompileNamePattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		return regexp.Compile(pattern[1 : len(pattern)-1])
+	}
+
+	expr := make([]string, 0, len(pattern)+2)
+	expr = append(expr, "^")
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			expr = append(expr, ".*")
+		case '?':
+			expr = append(expr, ".")
+		default:
+			expr = append(expr, regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr = append(expr, "$")
+	return regexp.Compile(strings.Join(expr, ""))
+}
+
+// Returns an error if the options can't be applied
+func (o *ListOptions) Validate() error {
+	if o.Sort == "" {
+		return nil
+	}
+	for _, s := range listSorts {
+		if o.Sort == s {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown sort %q, expected one of %s", o.Sort, strings.Join(listSorts, ", "))
+}
+
+// Returns true if the entry passes the filters
+func (o *ListOptions) Match(e *ListEntry) bool {
+	if o.Name != nil && !o.Name.MatchString(e.Name) {
+		return false
+	}
+	if o.Kind != "" && e.Kind != "" && o.Kind != e.Kind {
+		return false
+	}
+	if e.Size < o.MinSize || (o.MaxSize != 0 && e.Size > o.MaxSize) {
+		return false
+	}
+	if e.Address < o.StartAddress || (o.EndAddress != 0 && e.Address >= o.EndAddress) {
+		return false
+	}
+	return true
+}
+
+// Returns the entries that pass the filters, sorted and limited
+func (o *ListOptions) Apply(entries []*ListEntry) []*ListEntry {
+	result := make([]*ListEntry, 0, len(entries))
+	for _, e := range entries {
+		if o.Match(e) {
+			result = append(result, e)
+		}
+	}
+
+	var s sort.Interface
+	switch o.Sort {
+	case "size":
+		s = entriesBySize(result)
+	case "address":
+		s = entriesByAddress(result)
+	case "count":
+		s = entriesByCount(result)
+	case "name":
+		s = entriesByName(result)
+	}
+	if s != nil {
+		if o.Reverse {
+			s = sort.Reverse(s)
+		}
+		sort.Stable(s)
+	} else if o.Reverse {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	}
+
+	if o.Top > 0 && len(result) > o.Top {
+		result = result[:o.Top]
+	}
+	return result
+}
+
+// Sizes and counts sort largest first, addresses and names lowest first
+type entriesBySize []*ListEntry
+
+func (e entriesBySize) Len() int           { return len(e) }
+func (e entriesBySize) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e entriesBySize) Less(i, j int) bool { return e[i].Size > e[j].Size }
+
+type entriesByAddress []*ListEntry
+
+func (e entriesByAddress) Len() int           { return len(e) }
+func (e entriesByAddress) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e entriesByAddress) Less(i, j int) bool { return e[i].Address < e[j].Address }
+
+type entriesByCount []*ListEntry
+
+func (e entriesByCount) Len() int           { return len(e) }
+func (e entriesByCount) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e entriesByCount) Less(i, j int) bool { return e[i].Count > e[j].Count }
+
+type entriesByName []*ListEntry
+
+func (e entriesByName) Len() int           { return len(e) }
+func (e entriesByName) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e entriesByName) Less(i, j int) bool { return e[i].Name < e[j].Name }
+
+// Returns listing entries for objects
+func ObjectEntries(objects []*Object) []*ListEntry {
+	entries := make([]*ListEntry, 0, len(objects))
+	for _, object := range objects {
+		entries = append(entries, &ListEntry{object.Name(), object.Kind(), object.Address, uint64(object.Size), 1, object})
+	}
+	return entries
+}
+
+// Returns listing entries for the types, counting the objects of each type
+func (h *HeapFile) TypeEntries() []*ListEntry {
+	h.parse()
+	counts := make(map[uint64]uint64, len(h.typeList))
+	for _, object := range h.objectList {
+		counts[object.TypeAddress]++
+	}
+
+	types := h.Types()
+	entries := make([]*ListEntry, 0, len(types))
+	for _, t := range types {
+		entries = append(entries, &ListEntry{t.Name, "", t.Address, t.Size, counts[t.Address], t})
+	}
+	return entries
+}
+
+// Returns listing entries for stack frames. Their size is the size of the frame's contents.
+func FrameEntries(frames []*StackFrame) []*ListEntry {
+	entries := make([]*ListEntry, 0, len(frames))
+	for _, frame := range frames {
+		entries = append(entries, &ListEntry{frame.Name, "", frame.StackPointer, uint64(len(frame.Content)), 1, frame})
+	}
+	return entries
+}
+
+// Counts the objects by type name, returning an entry for each histogram bucket. Objects whose
+// type is unknown are counted under "unknown". The kind and address filters apply to the
+// objects before they are counted; the other options apply to the buckets, whose size is the
+// total bytes of their objects.
+func (o *ListOptions) Histogram(objects []*Object) []*ListEntry {
+	buckets := make(map[string]*HistogramBucket, 0)
+	entries := make([]*ListEntry, 0)
+	for _, object := range objects {
+		if o.Kind != "" && o.Kind != object.Kind() {
+			continue
+		}
+		if object.Address < o.StartAddress || (o.EndAddress != 0 && object.Address >= o.EndAddress) {
+			continue
+		}
+
+		name := object.Name()
+		bucket, ok := buckets[name]
+		if !ok {
+			bucket = &HistogramBucket{Name: name}
+			buckets[name] = bucket
+			entries = append(entries, &ListEntry{Name: name, Value: bucket})
+		}
+		bucket.Count++
+		bucket.Bytes += uint64(object.Size)
+	}
+	for _, e := range entries {
+		bucket := e.Value.(*HistogramBucket)
+		e.Size, e.Count = bucket.Bytes, bucket.Count
+	}
+
+	bucketOptions := *o
+	bucketOptions.Kind = ""
+	bucketOptions.StartAddress, bucketOptions.EndAddress = 0, 0
+	return bucketOptions.Apply(entries)
+}
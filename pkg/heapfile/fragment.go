@@ -0,0 +1,35 @@
+package heapfile
+
+// A range of the heap between objects, or after the last object, that no object occupies
+type Fragment struct {
+	Start uint64
+	End   uint64 // exclusive
+}
+
+func (f *Fragment) Size() uint64 {
+	return f.End - f.Start
+}
+
+// Returns the gaps between the objects, in address order, including any space between the
+// last object and the end of the heap
+func (h *HeapFile) Fragments() []*Fragment {
+	h.parse()
+	fragments := make([]*Fragment, 0)
+	objects := h.sortedObjects
+	if len(objects) == 0 {
+		return fragments
+	}
+
+	for i := 0; i < len(objects)-1; i++ {
+		end := objects[i].Address + uint64(objects[i].Size)
+		if next := objects[i+1].Address; end < next {
+			fragments = append(fragments, &Fragment{end, next})
+		}
+	}
+
+	last := objects[len(objects)-1]
+	if end := last.Address + uint64(last.Size); end < h.dumpParams.EndAddress {
+		fragments = append(fragments, &Fragment{end, h.dumpParams.EndAddress})
+	}
+	return fragments
+}
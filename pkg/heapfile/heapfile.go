@@ -3,22 +3,57 @@ package heapfile
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 )
 
 var (
 	ErrInvalidHeapFile = errors.New("invalid heap file")
 )
 
-var dumpHeader = "go1.3 heap dump\n"
+// The header of each dump format version. go1.7 dumps, written by every runtime since, have
+// no type or kind for objects and only record the types of itabs.
+const (
+	dumpHeader13 = "go1.3 heap dump\n"
+	dumpHeader17 = "go1.7 heap dump\n"
+)
 
 type HeapFile struct {
-	Name       string
-	memStats   *runtime.MemStats
-	byteReader *bufio.Reader
-	parsed     bool
+	Name          string
+	path          string
+	header        string
+	binary        string // executable that wrote the dump, for the types of go1.7 dumps
+	metadata      *Metadata
+	metadataError error
+	memStats      *runtime.MemStats
+	byteReader    *bufio.Reader
+	parsed        bool
+
+	typeList         map[uint64]*Type
+	typeNames        map[string]*Type
+	objectList       map[uint64]*Object
+	sortedObjects    []*Object
+	dumpParams       *DumpParams
+	itabs            map[uint64]uint64 // type address of each itab, in go1.7 dumps
+	typeError        error
+	memProf          map[uint64]*Profile
+	allocs           []*Alloc
+	goroutines       []*Goroutine
+	roots            []*Root
+	stackFrames      map[uint64]*StackFrame
+	frameParents     map[uint64]*StackFrame
+	dataSegment      *Segment
+	bss              *Segment
+	finalizers       []*Finalizer
+	queuedFinalizers []*Finalizer
+	pathParents      map[uint64]*Object
+	pathRoots        map[uint64]string
+	dominators       map[uint64]*Object
+	retained         map[uint64]uint64
+	referrers        map[uint64][]*Object
 }
 
 func New(file string) (*HeapFile, error) {
@@ -27,9 +62,9 @@ func New(file string) (*HeapFile, error) {
 		return nil, err
 	}
 
-	header := make([]byte, len(dumpHeader))
+	header := make([]byte, len(dumpHeader13))
 	dumpFile.Read(header)
-	if string(header) != dumpHeader {
+	if string(header) != dumpHeader13 && string(header) != dumpHeader17 {
 		return nil, ErrInvalidHeapFile
 	}
 
@@ -37,17 +72,58 @@ func New(file string) (*HeapFile, error) {
 
 	name := filepath.Base(file)
 
-	return &HeapFile{Name: name, byteReader: byteReader}, nil
+	// A sidecar that can't be read is ignored, as the dump is usable without it
+	metadata, err := readMetadata(file)
+	if err != nil {
+		metadata, err = nil, fmt.Errorf("reading %s: %s", MetadataPath(file), err)
+	}
+
+	h := &HeapFile{Name: name, path: file, header: string(header), metadata: metadata, metadataError: err,
+		byteReader: byteReader}
+	if metadata != nil {
+		h.binary = metadata.Binary
+	}
+	return h, nil
+}
+
+// Returns the dump format version, "go1.3" or "go1.7"
+func (h *HeapFile) Version() string {
+	return h.header[:len(h.header)-len(" heap dump\n")]
+}
+
+// Sets the executable that wrote the dump, in place of the one its metadata names. go1.7
+// dumps don't record the types of objects, so they are inferred from its DWARF information
+// when the dump is parsed.
+func (h *HeapFile) SetBinary(path string) {
+	h.binary = path
+}
+
+// Returns why the types of objects in a go1.7 dump couldn't be inferred from the binary's
+// DWARF information, or nil if they were or the dump records them
+func (h *HeapFile) TypeError() error {
+	h.parse()
+	return h.typeError
+}
+
+// Returns the metadata from the dump's sidecar file, or nil if it has none or it couldn't be
+// read
+func (h *HeapFile) Metadata() *Metadata {
+	return h.metadata
+}
+
+// Returns why the dump's sidecar file couldn't be read, or nil if it was or there is none
+func (h *HeapFile) MetadataError() error {
+	return h.metadataError
 }
 
 func (h *HeapFile) DataSegment() *Segment {
 	h.parse()
-	return dataSegment
+	return h.dataSegment
 }
 
 func (h *HeapFile) BSS() *Segment {
 	h.parse()
-	return bss
+	return h.bss
 }
 
 func (h *HeapFile) MemStats() *runtime.MemStats {
@@ -57,8 +133,8 @@ func (h *HeapFile) MemStats() *runtime.MemStats {
 
 func (h *HeapFile) Objects() []*Object {
 	h.parse()
-	objects := make([]*Object, 0, len(objectList))
-	for _, v := range objectList {
+	objects := make([]*Object, 0, len(h.objectList))
+	for _, v := range h.objectList {
 		objects = append(objects, v)
 	}
 	return objects
@@ -66,7 +142,27 @@ func (h *HeapFile) Objects() []*Object {
 
 func (h *HeapFile) Object(addr uint64) *Object {
 	h.parse()
-	if object, ok := objectList[uint64(addr)]; ok {
+	if object, ok := h.objectList[uint64(addr)]; ok {
+		return object
+	}
+	return nil
+}
+
+// Returns the object whose contents include addr, or nil if addr is not on the heap
+func (h *HeapFile) ObjectContaining(addr uint64) *Object {
+	h.parse()
+	return h.objectContaining(addr)
+}
+
+func (h *HeapFile) objectContaining(addr uint64) *Object {
+	idx := sort.Search(len(h.sortedObjects), func(i int) bool {
+		return h.sortedObjects[i].Address > addr
+	})
+	if idx == 0 {
+		return nil
+	}
+	object := h.sortedObjects[idx-1]
+	if addr < object.Address+uint64(object.Size) {
 		return object
 	}
 	return nil
@@ -144,8 +240,8 @@ func mark(object *Object, seen *map[uint64]bool) {
 
 func (h *HeapFile) Types() []*Type {
 	h.parse()
-	types := make([]*Type, 0, len(typeList))
-	for _, t := range typeList {
+	types := make([]*Type, 0, len(h.typeList))
+	for _, t := range h.typeList {
 		types = append(types, t)
 	}
 	return types
@@ -153,18 +249,18 @@ func (h *HeapFile) Types() []*Type {
 
 func (h *HeapFile) Type(addr uint64) *Type {
 	h.parse()
-	return typeList[addr]
+	return h.typeList[addr]
 }
 
 func (h *HeapFile) DumpParams() *DumpParams {
 	h.parse()
-	return dumpParams
+	return h.dumpParams
 }
 
 func (h *HeapFile) MemProf() []*Profile {
 	h.parse()
-	profiles := make([]*Profile, 0, len(memProf))
-	for _, p := range memProf {
+	profiles := make([]*Profile, 0, len(h.memProf))
+	for _, p := range h.memProf {
 		profiles = append(profiles, p)
 	}
 	return profiles
@@ -172,23 +268,23 @@ func (h *HeapFile) MemProf() []*Profile {
 
 func (h *HeapFile) Allocs() []*Alloc {
 	h.parse()
-	return allocs
+	return h.allocs
 }
 
 func (h *HeapFile) Goroutines() []*Goroutine {
 	h.parse()
-	return goroutines
+	return h.goroutines
 }
 
 func (h *HeapFile) OtherRoots() []*Root {
 	h.parse()
-	return roots
+	return h.roots
 }
 
 func (h *HeapFile) StackFrames() []*StackFrame {
 	h.parse()
-	frames := make([]*StackFrame, 0, len(stackFrames))
-	for _, f := range stackFrames {
+	frames := make([]*StackFrame, 0, len(h.stackFrames))
+	for _, f := range h.stackFrames {
 		frames = append(frames, f)
 	}
 	return frames
@@ -196,17 +292,45 @@ func (h *HeapFile) StackFrames() []*StackFrame {
 
 func (h *HeapFile) StackFrame(address uint64) *StackFrame {
 	h.parse()
-	return stackFrames[address]
+	return h.stackFrames[address]
+}
+
+// Returns the name of the function containing pc, as "name+0xoffset". Functions are only
+// known from stack frames, which give a function's entry point and the pcs it was stopped
+// at, so pc must lie between the entry point and the highest pc seen in the function.
+// Returns an empty string if no known function contains pc.
+func (h *HeapFile) FunctionAt(pc uint64) string {
+	h.parse()
+	names := make(map[uint64]string, 0)
+	highest := make(map[uint64]uint64, 0)
+	for _, frame := range h.stackFrames {
+		names[frame.EntryPC] = frame.Name
+		for _, framePC := range []uint64{frame.CurrentPC, frame.ContinuationPC} {
+			if framePC > highest[frame.EntryPC] {
+				highest[frame.EntryPC] = framePC
+			}
+		}
+	}
+
+	for entry, name := range names {
+		if entry <= pc && pc <= highest[entry] {
+			if pc == entry {
+				return name
+			}
+			return fmt.Sprintf("%s+0x%x", name, pc-entry)
+		}
+	}
+	return ""
 }
 
 func (h *HeapFile) QueuedFinalizers() []*Finalizer {
 	h.parse()
-	return queuedFinalizers
+	return h.queuedFinalizers
 }
 
 func (h *HeapFile) Finalizers() []*Finalizer {
 	h.parse()
-	return finalizers
+	return h.finalizers
 }
 
 func (h *HeapFile) DataSegmentObjects() []*Object {
@@ -221,8 +345,8 @@ func (h *HeapFile) BSSObjects() []*Object {
 
 func (h *HeapFile) FinalizerObjects() []*Object {
 	h.parse()
-	objects := make([]*Object, 0, len(finalizers))
-	for _, finalizer := range finalizers {
+	objects := make([]*Object, 0, len(h.finalizers))
+	for _, finalizer := range h.finalizers {
 		if object := h.Object(finalizer.ObjectAddress); object != nil {
 			objects = append(objects, object)
 		}
@@ -232,8 +356,8 @@ func (h *HeapFile) FinalizerObjects() []*Object {
 
 func (h *HeapFile) QueuedFinalizerObjects() []*Object {
 	h.parse()
-	objects := make([]*Object, 0, len(queuedFinalizers))
-	for _, finalizer := range queuedFinalizers {
+	objects := make([]*Object, 0, len(h.queuedFinalizers))
+	for _, finalizer := range h.queuedFinalizers {
 		if object := h.Object(finalizer.ObjectAddress); object != nil {
 			objects = append(objects, object)
 		}
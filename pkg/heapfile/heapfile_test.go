@@ -0,0 +1,87 @@
+package heapfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+)
+
+// A dump record: its kind, then its fields as uint64s (uvarints), strings and field lists
+type testRecord []interface{}
+
+// Writes a heap dump of the records to a temporary file and opens it. The caller removes
+// the file.
+func openTestDump(t *testing.T, header string, records ...testRecord) *HeapFile {
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	for _, record := range records {
+		for _, field := range record {
+			switch field := field.(type) {
+			case int:
+				writeTestUvarint(&buf, uint64(field))
+			case uint64:
+				writeTestUvarint(&buf, field)
+			case string:
+				writeTestUvarint(&buf, uint64(len(field)))
+				buf.WriteString(field)
+			case []*Field:
+				for _, f := range field {
+					writeTestUvarint(&buf, f.Kind)
+					writeTestUvarint(&buf, f.Offset)
+				}
+				writeTestUvarint(&buf, 0)
+			default:
+				t.Fatalf("can't write %T to a dump", field)
+			}
+		}
+	}
+	writeTestUvarint(&buf, 0)
+
+	f, err := ioutil.TempFile("", "heapfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(buf.Bytes())
+	f.Close()
+
+	h, err := New(f.Name())
+	if err != nil {
+		os.Remove(f.Name())
+		t.Fatal(err)
+	}
+	h.parse()
+	return h
+}
+
+func writeTestUvarint(buf *bytes.Buffer, v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	buf.Write(b[:binary.PutUvarint(b, v)])
+}
+
+// Returns v as a little endian 64-bit word
+func testWord(v uint64) string {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return string(b)
+}
+
+// Dump params of a little endian 64-bit go1.3 and go1.7 dump, with the heap at 0xc000-0xd000
+var (
+	testParams13 = testRecord{6, 0, 8, 0, 0xc000, 0xd000, 6, "", 4}
+	testParams17 = testRecord{6, 0, 8, 0xc000, 0xd000, "amd64", "go1.22.1", 4}
+)
+
+// Returns a memstats record of m
+func testMemStats(m *runtime.MemStats) testRecord {
+	record := testRecord{10, m.Alloc, m.TotalAlloc, m.Sys, m.Lookups, m.Mallocs, m.Frees, m.HeapAlloc,
+		m.HeapSys, m.HeapIdle, m.HeapInuse, m.HeapReleased, m.HeapObjects, m.StackInuse, m.StackSys,
+		m.MSpanInuse, m.MSpanSys, m.MCacheInuse, m.MCacheSys, m.BuckHashSys, m.GCSys, m.OtherSys,
+		m.NextGC, m.LastGC, m.PauseTotalNs}
+	for _, pause := range m.PauseNs {
+		record = append(record, pause)
+	}
+	return append(record, uint64(m.NumGC))
+}
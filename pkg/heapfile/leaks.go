@@ -0,0 +1,122 @@
+package heapfile
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// A goroutine that has been waiting longer than the leak threshold
+type BlockedGoroutine struct {
+	Goroutine *Goroutine
+	Waiting   time.Duration // time spent waiting, relative to the goroutine that started waiting last
+	BlockedOn []*Object     // channels or other objects referenced by the blocking frames
+}
+
+// Blocked goroutines that were created at the same location and are waiting for the same reason
+type GoroutineLeak struct {
+	Location   uint64 // the location of the go statement that created the goroutines
+	Function   string // the function containing Location, if it could be resolved
+	Reason     string // reason the goroutines are waiting
+	Goroutines []*BlockedGoroutine
+}
+
+// Finds waiting goroutines that started waiting more than threshold before the goroutine
+// that started waiting last, grouped by creation location and wait reason. The dump records
+// when goroutines started waiting on the runtime's monotonic clock, which other times in the
+// dump don't use, so that goroutine stands in for the time of the dump. Groups are sorted by
+// size, largest first.
+func (h *HeapFile) GoroutineLeaks(threshold time.Duration) []*GoroutineLeak {
+	h.parse()
+	var now uint64
+	for _, g := range h.Goroutines() {
+		if g.Status().Base() == StatusWaiting && g.LastWaiting > now {
+			now = g.LastWaiting
+		}
+	}
+
+	type leakKey struct {
+		location uint64
+		reason   string
+	}
+	groups := make(map[leakKey]*GoroutineLeak, 0)
+
+	for _, g := range h.Goroutines() {
+		if g.Status().Base() != StatusWaiting || g.LastWaiting == 0 {
+			continue
+		}
+
+		waiting := time.Duration(now - g.LastWaiting)
+		if waiting < threshold {
+			continue
+		}
+
+		key := leakKey{g.Location, g.ReasonWaiting()}
+		leak, ok := groups[key]
+		if !ok {
+			leak = &GoroutineLeak{
+				Location: g.Location,
+				Function: h.FunctionAt(g.Location),
+				Reason:   g.ReasonWaiting(),
+			}
+			groups[key] = leak
+		}
+		leak.Goroutines = append(leak.Goroutines, &BlockedGoroutine{g, waiting, blockedOn(g)})
+	}
+
+	leaks := make([]*GoroutineLeak, 0, len(groups))
+	for _, leak := range groups {
+		sort.Sort(blockedByWait(leak.Goroutines))
+		leaks = append(leaks, leak)
+	}
+	sort.Sort(leaksBySize(leaks))
+	return leaks
+}
+
+// Finds the objects a goroutine is blocked on. Only the runtime frames at the top of
+// the stack and the first non-runtime frame (the caller of the blocking operation) are
+// examined. Channels are preferred; if there are none, every referenced object is returned.
+func blockedOn(g *Goroutine) []*Object {
+	seen := make(map[uint64]bool, 0)
+	objects := make([]*Object, 0)
+	channels := make([]*Object, 0)
+
+	for _, frame := range g.StackFrames() {
+		for _, object := range frame.Objects() {
+			if seen[object.Address] {
+				continue
+			}
+			seen[object.Address] = true
+			objects = append(objects, object)
+			if object.Kind() == "channel" {
+				channels = append(channels, object)
+			}
+		}
+
+		if !strings.HasPrefix(frame.Name, "runtime.") {
+			break
+		}
+	}
+
+	if len(channels) > 0 {
+		return channels
+	}
+	return objects
+}
+
+type blockedByWait []*BlockedGoroutine
+
+func (b blockedByWait) Len() int           { return len(b) }
+func (b blockedByWait) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b blockedByWait) Less(i, j int) bool { return b[i].Waiting > b[j].Waiting }
+
+type leaksBySize []*GoroutineLeak
+
+func (l leaksBySize) Len() int      { return len(l) }
+func (l leaksBySize) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l leaksBySize) Less(i, j int) bool {
+	if len(l[i].Goroutines) == len(l[j].Goroutines) {
+		return l[i].Location < l[j].Location
+	}
+	return len(l[i].Goroutines) > len(l[j].Goroutines)
+}
@@ -0,0 +1,44 @@
+package heapfile
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// A goroutine record with the given status that started waiting at lastWaiting
+func testGoroutine(id, status, lastWaiting uint64) testRecord {
+	return testRecord{4, 0xd000 + id*0x100, 0, id, 0x401000, status, 0, 0, lastWaiting, "chan receive", 0, 0, 0, 0}
+}
+
+func TestGoroutineLeaks(t *testing.T) {
+	// Goroutines started waiting about an hour after the process started, as the runtime's
+	// monotonic clock counts, while the last GC is a wall clock time
+	const hour = uint64(time.Hour)
+	h := openTestDump(t, dumpHeader13, testParams13,
+		testMemStats(&runtime.MemStats{LastGC: uint64(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC).UnixNano())}),
+		testGoroutine(1, uint64(StatusWaiting), hour-uint64(time.Minute)),
+		testGoroutine(2, uint64(StatusWaiting), hour-uint64(time.Second)),
+		testGoroutine(3, uint64(StatusWaiting), hour),
+		testGoroutine(4, uint64(StatusRunning), hour+uint64(time.Hour)),
+		testGoroutine(5, uint64(StatusWaiting), 0),
+	)
+	defer os.Remove(h.path)
+
+	leaks := h.GoroutineLeaks(30 * time.Second)
+	if len(leaks) != 1 || len(leaks[0].Goroutines) != 1 {
+		t.Fatalf("found %d leaks, expected goroutine 1", len(leaks))
+	}
+	blocked := leaks[0].Goroutines[0]
+	if blocked.Goroutine.Id != 1 || blocked.Waiting != time.Minute {
+		t.Errorf("goroutine %d waiting %s, expected goroutine 1 waiting 1m0s", blocked.Goroutine.Id, blocked.Waiting)
+	}
+	if leaks[0].Reason != "chan receive" {
+		t.Errorf("reason %q, expected chan receive", leaks[0].Reason)
+	}
+
+	if leaks := h.GoroutineLeaks(0); len(leaks) != 1 || len(leaks[0].Goroutines) != 3 {
+		t.Errorf("found %d leaks without a threshold, expected 3 waiting goroutines in one", len(leaks))
+	}
+}
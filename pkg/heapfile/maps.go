@@ -0,0 +1,220 @@
+package heapfile
+
+import (
+	"sort"
+	"strings"
+)
+
+// Layout of the runtime's Hmap and Bucket structures
+const (
+	bucketCount      = 8 // entries per bucket
+	minTopHash       = 4 // tophash values below this mark empty or evacuated cells
+	mapIndirectKey   = 1 // flag: keys are stored as pointers
+	mapIndirectValue = 2 // flag: values are stored as pointers
+)
+
+// A key/value pair from a map
+type MapEntry struct {
+	Key   *Value
+	Value *Value
+}
+
+// A map decoded from its header and bucket objects
+type Map struct {
+	Object          *Object // the map header
+	KeyType         string  // name of the key type
+	ValueType       string  // name of the value type
+	Count           uint64  // number of entries according to the header
+	B               uint64  // log_2 of the number of buckets
+	KeySize         uint64  // size of a key cell
+	ValueSize       uint64  // size of a value cell
+	BucketSize      uint64  // size of a bucket
+	Buckets         uint64  // number of buckets
+	OverflowBuckets uint64  // number of overflow buckets
+	Growing         bool    // old buckets are still being evacuated
+	BucketBytes     uint64  // bytes used by all buckets, including overflow and old buckets
+	Entries         []*MapEntry
+	heap            *HeapFile
+}
+
+// Average number of entries per bucket
+func (m *Map) LoadFactor() float64 {
+	if m.Buckets == 0 {
+		return 0
+	}
+	return float64(m.Count) / float64(m.Buckets)
+}
+
+// Bytes of bucket memory not holding live keys and values
+func (m *Map) WastedBytes() uint64 {
+	used := uint64(len(m.Entries)) * (m.KeySize + m.ValueSize)
+	if used > m.BucketBytes {
+		return 0
+	}
+	return m.BucketBytes - used
+}
+
+// Returns true if the object is a map header
+func (o *Object) IsMap() bool {
+	return o.Type != nil && strings.HasPrefix(o.Type.Name, "map.hdr[")
+}
+
+// Decodes the map whose header is o. Keys and values are decoded following pointers
+// depth levels deep. Returns nil if o is not a map header.
+func (h *HeapFile) Map(o *Object, depth int) *Map {
+	h.parse()
+	if !o.IsMap() || uint64(o.Size) < h.dumpParams.PtrSize+16+2*h.dumpParams.PtrSize {
+		return nil
+	}
+
+	m := &Map{Object: o, heap: h}
+	m.KeyType, m.ValueType = splitMapTypeName(strings.TrimPrefix(o.Type.Name, "map.hdr"))
+
+	ptrSize := h.dumpParams.PtrSize
+	m.Count = h.readUint(o.Content[:ptrSize])
+	flags := h.readUint(o.Content[ptrSize : ptrSize+4])
+	m.B = h.readUint(o.Content[ptrSize+8 : ptrSize+9])
+	m.KeySize = h.readUint(o.Content[ptrSize+9 : ptrSize+10])
+	m.ValueSize = h.readUint(o.Content[ptrSize+10 : ptrSize+11])
+	m.BucketSize = h.readUint(o.Content[ptrSize+12 : ptrSize+14])
+	buckets := h.readPtr(o.Content[ptrSize+16:], 0)
+	oldBuckets := h.readPtr(o.Content[ptrSize+16:], 1)
+
+	if m.BucketSize < bucketCount+ptrSize+bucketCount*(m.KeySize+m.ValueSize) {
+		return m
+	}
+
+	m.Buckets = 1 << m.B
+	m.walkBuckets(buckets, m.Buckets, flags, depth)
+	if oldBuckets != 0 {
+		m.Growing = true
+		m.walkBuckets(oldBuckets, m.Buckets/2, flags, depth)
+	}
+	return m
+}
+
+// Returns all of the maps on the heap, sorted by wasted bytes, most wasteful first
+func (h *HeapFile) Maps() []*Map {
+	h.parse()
+	maps := make([]*Map, 0)
+	for _, object := range h.objectList {
+		if m := h.Map(object, 0); m != nil {
+			maps = append(maps, m)
+		}
+	}
+	sort.Sort(mapsByWaste(maps))
+	return maps
+}
+
+// Reads n buckets from the bucket array at addr, following their overflow chains
+func (m *Map) walkBuckets(addr, n, flags uint64, depth int) {
+	array := m.heap.objectList[addr]
+	if array == nil {
+		return
+	}
+
+	for i := uint64(0); i < n; i++ {
+		start := i * m.BucketSize
+		if start+m.BucketSize > uint64(array.Size) {
+			return
+		}
+		m.BucketBytes += m.BucketSize
+		bucket := array.Content[start : start+m.BucketSize]
+
+		seen := make(map[uint64]bool, 0)
+		for {
+			m.readBucket(bucket, flags, depth)
+
+			overflow := m.heap.readPtr(bucket[bucketCount:], 0)
+			object := m.heap.objectList[overflow]
+			if object == nil || seen[overflow] || uint64(object.Size) < m.BucketSize {
+				break
+			}
+			seen[overflow] = true
+			m.OverflowBuckets++
+			m.BucketBytes += uint64(object.Size)
+			bucket = object.Content[:m.BucketSize]
+		}
+	}
+}
+
+func (m *Map) readBucket(bucket string, flags uint64, depth int) {
+	keys := bucketCount + m.heap.dumpParams.PtrSize
+	values := keys + bucketCount*m.KeySize
+	for i := uint64(0); i < bucketCount; i++ {
+		if bucket[i] < minTopHash {
+			continue
+		}
+
+		key := bucket[keys+i*m.KeySize : keys+(i+1)*m.KeySize]
+		value := bucket[values+i*m.ValueSize : values+(i+1)*m.ValueSize]
+		m.Entries = append(m.Entries, &MapEntry{
+			Key:   m.heap.decodeCell(m.KeyType, key, flags&mapIndirectKey != 0, depth),
+			Value: m.heap.decodeCell(m.ValueType, value, flags&mapIndirectValue != 0, depth),
+		})
+	}
+}
+
+// Decodes a key or value cell of the named type
+func (h *HeapFile) decodeCell(name, content string, indirect bool, depth int) *Value {
+	if indirect {
+		addr := h.readPtr(content, 0)
+		if t := h.typeNames[name]; t != nil {
+			if object := h.objectList[addr]; object != nil && uint64(object.Size) >= t.Size {
+				return h.decodeType(t, object.Content[:t.Size], depth)
+			}
+		}
+		return h.decodePointer(addr, depth)
+	}
+	return h.decodeNamed(name, content, depth)
+}
+
+// Decodes a value of the named type. Types of values that were not on the heap are not in
+// the dump, so values of builtin types are decoded by name.
+func (h *HeapFile) decodeNamed(name, content string, depth int) *Value {
+	if t := h.typeNames[name]; t != nil && t.Size == uint64(len(content)) {
+		return h.decodeType(t, content, depth)
+	}
+
+	size := uint64(len(content))
+	switch {
+	case name == "string" && size == h.fieldSize(FieldStr):
+		return h.decodeField(FieldStr, content, depth)
+	case strings.HasPrefix(name, "[]") && size == h.fieldSize(FieldSlice):
+		return h.decodeField(FieldSlice, content, depth)
+	case name == "interface {}" && size == h.fieldSize(FieldEface):
+		return h.decodeField(FieldEface, content, depth)
+	case strings.HasPrefix(name, "interface {") && size == h.fieldSize(FieldIface):
+		return h.decodeField(FieldIface, content, depth)
+	case (strings.HasPrefix(name, "*") || strings.HasPrefix(name, "map[") ||
+		strings.HasPrefix(name, "chan ")) && size == h.fieldSize(FieldPtr):
+		return h.decodeField(FieldPtr, content, depth)
+	}
+	return &Value{heap: h, Kind: ValueRaw, Type: &Type{Name: name, Size: size}, Raw: content, Len: size}
+}
+
+// Splits "[K]V" into K and V
+func splitMapTypeName(name string) (string, string) {
+	if !strings.HasPrefix(name, "[") {
+		return "", name
+	}
+	nesting := 0
+	for i, c := range name {
+		switch c {
+		case '[':
+			nesting++
+		case ']':
+			nesting--
+			if nesting == 0 {
+				return name[1:i], name[i+1:]
+			}
+		}
+	}
+	return "", name
+}
+
+type mapsByWaste []*Map
+
+func (m mapsByWaste) Len() int           { return len(m) }
+func (m mapsByWaste) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m mapsByWaste) Less(i, j int) bool { return m[i].WastedBytes() > m[j].WastedBytes() }
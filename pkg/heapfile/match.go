@@ -0,0 +1,223 @@
+package heapfile
+
+import (
+	"crypto/sha1"
+	"sort"
+)
+
+// How a pair of objects was matched
+const (
+	MatchRoot    = "root"    // pointed to by the same global, root or stack slot
+	MatchGraph   = "graph"   // pointed to from the same offset of a matched object
+	MatchContent = "content" // the only object of its type, size and contents in both dumps
+)
+
+// An object found in both heap dumps
+type ObjectMatch struct {
+	Old     *Object
+	New     *Object
+	How     string // MatchRoot, MatchGraph or MatchContent
+	Changed bool   // whether the contents changed, not counting pointers
+}
+
+// The objects of one heap dump paired with their counterparts in a later dump
+type Matching struct {
+	Survived  []*ObjectMatch
+	Freed     []*Object // objects only in the old dump
+	Allocated []*Object // objects only in the new dump
+
+	older, newer *HeapFile
+	oldToNew     map[uint64]*ObjectMatch
+	newToOld     map[uint64]*ObjectMatch
+	queue        []*ObjectMatch
+}
+
+// Pairs the objects of older with the objects they became in newer. Addresses are not
+// compared, since objects move between dumps. Pairs are anchored on globals in the data
+// and bss segments, other roots, and the stack frames of goroutines with the same id, then
+// extended by following pointers at the same offsets of matched objects. Objects left over
+// are paired when they are the only object with their type, size and contents, ignoring
+// pointers, in both dumps, or when identical objects share an address. Objects are only
+// paired if they have the same type name and size.
+func Match(older, newer *HeapFile) *Matching {
+	older.parse()
+	newer.parse()
+
+	m := &Matching{
+		older:    older,
+		newer:    newer,
+		oldToNew: make(map[uint64]*ObjectMatch, len(older.objectList)),
+		newToOld: make(map[uint64]*ObjectMatch, len(newer.objectList)),
+	}
+
+	m.matchSegment(older.dataSegment, newer.dataSegment)
+	m.matchSegment(older.bss, newer.bss)
+	m.matchOtherRoots()
+	m.matchGoroutines()
+	m.propagate()
+
+	m.matchContent()
+	m.propagate()
+
+	for _, object := range older.objectList {
+		if _, ok := m.oldToNew[object.Address]; !ok {
+			m.Freed = append(m.Freed, object)
+		}
+	}
+	for _, object := range newer.objectList {
+		if _, ok := m.newToOld[object.Address]; !ok {
+			m.Allocated = append(m.Allocated, object)
+		}
+	}
+	sort.Sort(matchesByAddress(m.Survived))
+	sort.Sort(objectsByAddress(m.Freed))
+	sort.Sort(objectsByAddress(m.Allocated))
+	return m
+}
+
+// Returns the object in the new dump that the object from the old dump became, or nil if it was freed
+func (m *Matching) Counterpart(o *Object) *Object {
+	if match, ok := m.oldToNew[o.Address]; ok {
+		return match.New
+	}
+	return nil
+}
+
+// Returns the object in the old dump that the object from the new dump was, or nil if it is new
+func (m *Matching) Origin(o *Object) *Object {
+	if match, ok := m.newToOld[o.Address]; ok {
+		return match.Old
+	}
+	return nil
+}
+
+// Pairs two objects if neither is already paired and they look like the same object
+func (m *Matching) pair(a, b *Object, how string) {
+	if a == nil || b == nil || a.Size != b.Size || a.Name() != b.Name() {
+		return
+	}
+	if _, ok := m.oldToNew[a.Address]; ok {
+		return
+	}
+	if _, ok := m.newToOld[b.Address]; ok {
+		return
+	}
+
+	match := &ObjectMatch{Old: a, New: b, How: how, Changed: a.maskPointers() != b.maskPointers()}
+	m.oldToNew[a.Address] = match
+	m.newToOld[b.Address] = match
+	m.Survived = append(m.Survived, match)
+	m.queue = append(m.queue, match)
+}
+
+// Pairs the objects pointed to by words at the same offsets of two blocks of memory
+func (m *Matching) pairPointers(a, b string, how string) {
+	words := uint64(len(a))
+	if uint64(len(b)) < words {
+		words = uint64(len(b))
+	}
+	words /= m.older.dumpParams.PtrSize
+	for i := uint64(0); i < words; i++ {
+		m.pair(m.older.objectList[m.older.readPtr(a, i)], m.newer.objectList[m.newer.readPtr(b, i)], how)
+	}
+}
+
+func (m *Matching) matchSegment(a, b *Segment) {
+	m.pairPointers(a.Content, b.Content, MatchRoot)
+}
+
+// Other roots are matched by description when the description is unique in both dumps
+func (m *Matching) matchOtherRoots() {
+	count := func(roots []*Root) map[string][]*Root {
+		byDescription := make(map[string][]*Root, len(roots))
+		for _, root := range roots {
+			byDescription[root.Description] = append(byDescription[root.Description], root)
+		}
+		return byDescription
+	}
+	oldRoots := count(m.older.roots)
+	newRoots := count(m.newer.roots)
+	for _, root := range m.older.roots {
+		a, b := oldRoots[root.Description], newRoots[root.Description]
+		if len(a) == 1 && len(b) == 1 {
+			m.pair(m.older.objectList[a[0].Pointer], m.newer.objectList[b[0].Pointer], MatchRoot)
+		}
+	}
+}
+
+// Goroutines are matched by id, and their stacks frame by frame while the functions agree
+func (m *Matching) matchGoroutines() {
+	goroutines := make(map[uint64]*Goroutine, len(m.newer.goroutines))
+	for _, g := range m.newer.goroutines {
+		goroutines[g.Id] = g
+	}
+	for _, a := range m.older.goroutines {
+		b, ok := goroutines[a.Id]
+		if !ok {
+			continue
+		}
+		oldFrames, newFrames := a.StackFrames(), b.StackFrames()
+		for i := 0; i < len(oldFrames) && i < len(newFrames); i++ {
+			if oldFrames[i].Name != newFrames[i].Name {
+				break
+			}
+			m.pairPointers(oldFrames[i].Content, newFrames[i].Content, MatchRoot)
+		}
+	}
+}
+
+// Follows the pointers of every newly matched pair until no more pairs are found
+func (m *Matching) propagate() {
+	for len(m.queue) > 0 {
+		match := m.queue[0]
+		m.queue = m.queue[1:]
+		m.pairPointers(match.Old.Content, match.New.Content, MatchGraph)
+	}
+}
+
+// Pairs unmatched objects whose type, size and contents, ignoring pointers, are unique in both
+// dumps, or that have the same address and contents in both
+func (m *Matching) matchContent() {
+	type contentKey struct {
+		name string
+		size int
+		hash [sha1.Size]byte
+	}
+	index := func(h *HeapFile, matched map[uint64]*ObjectMatch) (map[contentKey][]*Object, map[uint64]contentKey) {
+		objects := make(map[contentKey][]*Object, 0)
+		keys := make(map[uint64]contentKey, 0)
+		for _, object := range h.sortedObjects {
+			if _, ok := matched[object.Address]; ok || object.Size == 0 {
+				continue
+			}
+			key := contentKey{object.Name(), object.Size, sha1.Sum([]byte(object.maskPointers()))}
+			objects[key] = append(objects[key], object)
+			keys[object.Address] = key
+		}
+		return objects, keys
+	}
+	oldObjects, oldKeys := index(m.older, m.oldToNew)
+	newObjects, newKeys := index(m.newer, m.newToOld)
+
+	for _, object := range m.older.sortedObjects {
+		key, ok := oldKeys[object.Address]
+		if !ok {
+			continue
+		}
+		a, b := oldObjects[key], newObjects[key]
+		if len(a) == 1 && len(b) == 1 {
+			m.pair(a[0], b[0], MatchContent)
+			continue
+		}
+		// Identical objects can't be told apart, but one that kept its address is most likely the same object
+		if other, ok := newKeys[object.Address]; ok && other == key {
+			m.pair(object, m.newer.objectList[object.Address], MatchContent)
+		}
+	}
+}
+
+type matchesByAddress []*ObjectMatch
+
+func (m matchesByAddress) Len() int           { return len(m) }
+func (m matchesByAddress) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m matchesByAddress) Less(i, j int) bool { return m[i].Old.Address < m[j].Old.Address }
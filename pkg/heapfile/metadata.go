@@ -0,0 +1,152 @@
+package heapfile
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Where a dump came from. The dump format doesn't record it, so it is kept in a JSON sidecar
+// file named after the dump with .json appended, which New reads if it exists.
+type Metadata struct {
+	Binary      string            `json:"binary"`   // path of the executable
+	BuildID     string            `json:"build_id"` // Go build ID of the executable
+	GoVersion   string            `json:"go_version"`
+	Path        string            `json:"path"`     // import path of the main package
+	Main        *Module           `json:"main"`     // the main module
+	Modules     []*Module         `json:"modules"`  // dependencies
+	Settings    map[string]string `json:"settings"` // build settings, such as vcs.revision
+	CommandLine []string          `json:"command_line"`
+	Hostname    string            `json:"hostname"`
+	Time        time.Time         `json:"time"`
+	Reason      string            `json:"reason"` // why the dump was taken
+}
+
+// A module the binary was built with
+type Module struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Sum     string `json:"sum,omitempty"`
+}
+
+// Returns the name of the sidecar file for a dump
+func MetadataPath(dumpPath string) string {
+	return dumpPath + ".json"
+}
+
+// Describes the running process, for a dump taken now
+func CurrentMetadata(reason string) *Metadata {
+	m := &Metadata{
+		GoVersion:   runtime.Version(),
+		Settings:    make(map[string]string, 0),
+		CommandLine: os.Args,
+		Time:        time.Now(),
+		Reason:      reason,
+	}
+	m.Hostname, _ = os.Hostname()
+	if binary, err := os.Executable(); err == nil {
+		m.Binary = binary
+		m.BuildID = readBuildID(binary)
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		m.Path = info.Path
+		m.Main = &Module{info.Main.Path, info.Main.Version, info.Main.Sum}
+		for _, dep := range info.Deps {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			m.Modules = append(m.Modules, &Module{dep.Path, dep.Version, dep.Sum})
+		}
+		for _, setting := range info.Settings {
+			m.Settings[setting.Key] = setting.Value
+		}
+	}
+	return m
+}
+
+// Writes the metadata as the sidecar of the dump
+func (m *Metadata) Write(dumpPath string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(MetadataPath(dumpPath), data, 0644)
+}
+
+// Reads the sidecar of the dump. Returns nil if there isn't one.
+func readMetadata(dumpPath string) (*Metadata, error) {
+	data, err := ioutil.ReadFile(MetadataPath(dumpPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	m := &Metadata{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Outside ELF executables the Go linker writes the build ID near the start of the text
+// segment as \xff Go build ID: "<id>"\n \xff
+var buildIDPrefix = []byte("\xff Go build ID: \"")
+
+// Returns the Go build ID of an executable, or "" if it can't be found
+func readBuildID(path string) string {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		return readELFBuildID(f)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	data := make([]byte, 64*1024)
+	n, err := io.ReadFull(f, data)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return ""
+	}
+	data = data[:n]
+
+	start := bytes.Index(data, buildIDPrefix)
+	if start < 0 {
+		return ""
+	}
+	data = data[start+len(buildIDPrefix):]
+	end := bytes.IndexByte(data, '"')
+	if end < 0 {
+		return ""
+	}
+	return string(data[:end])
+}
+
+// ELF executables keep the build ID in a note: name size, description size and type, each
+// 4 bytes, then the name "Go\x00\x00" and the ID as the description
+func readELFBuildID(f *elf.File) string {
+	section := f.Section(".note.go.buildid")
+	if section == nil {
+		return ""
+	}
+	data, err := section.Data()
+	if err != nil || len(data) < 16 {
+		return ""
+	}
+	nameSize := f.ByteOrder.Uint32(data[0:4])
+	descSize := f.ByteOrder.Uint32(data[4:8])
+	if nameSize != 4 || string(data[12:16]) != "Go\x00\x00" || uint32(len(data)-16) < descSize {
+		return ""
+	}
+	return string(data[16 : 16+descSize])
+}
@@ -6,37 +6,33 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"sort"
 )
 
-var typeList map[uint64]*Type
-var objectList map[uint64]*Object
-var dumpParams *DumpParams
-var memProf map[uint64]*Profile
-var allocs []*Alloc
-var goroutines []*Goroutine
-var roots []*Root
-var stackFrames map[uint64]*StackFrame
-var dataSegment *Segment
-var bss *Segment
-var finalizers []*Finalizer
-var queuedFinalizers []*Finalizer
-
 func (h *HeapFile) parse() {
 	if h.parsed {
 		return
 	}
 
-	typeList = make(map[uint64]*Type, 0)
-	objectList = make(map[uint64]*Object, 0)
-	memProf = make(map[uint64]*Profile, 0)
-	allocs = make([]*Alloc, 0)
-	goroutines = make([]*Goroutine, 0)
-	roots = make([]*Root, 0)
-	stackFrames = make(map[uint64]*StackFrame, 0)
-	dataSegment = &Segment{}
-	bss = &Segment{}
-	finalizers = make([]*Finalizer, 0)
-	queuedFinalizers = make([]*Finalizer, 0)
+	h.typeList = make(map[uint64]*Type, 0)
+	h.objectList = make(map[uint64]*Object, 0)
+	h.memProf = make(map[uint64]*Profile, 0)
+	h.allocs = make([]*Alloc, 0)
+	h.goroutines = make([]*Goroutine, 0)
+	h.roots = make([]*Root, 0)
+	h.stackFrames = make(map[uint64]*StackFrame, 0)
+	h.frameParents = make(map[uint64]*StackFrame, 0)
+	h.dataSegment = &Segment{heap: h}
+	h.bss = &Segment{heap: h}
+	h.finalizers = make([]*Finalizer, 0)
+	h.queuedFinalizers = make([]*Finalizer, 0)
+	h.itabs = make(map[uint64]uint64, 0)
+	h.typeError = nil
+	h.pathParents = nil
+	h.pathRoots = nil
+	h.dominators = nil
+	h.retained = nil
+	h.referrers = nil
 
 	for {
 		// From here on out is a series of records, starting with a uvarint
@@ -48,46 +44,54 @@ func (h *HeapFile) parse() {
 
 		switch kind {
 		case 0:
+			h.sortObjects()
+			if h.header == dumpHeader17 {
+				h.typeError = h.inferTypes()
+			}
+			h.indexTypeNames()
 			h.parsed = true
 			return
 		case 1:
-			o := readObject(h.byteReader)
-			objectList[o.Address] = o
+			o := h.readObject(h.byteReader)
+			h.objectList[o.Address] = o
 		case 2:
-			roots = append(roots, readOtherRoot(h.byteReader))
+			h.roots = append(h.roots, readOtherRoot(h.byteReader))
 		case 3:
-			t := readType(h.byteReader)
-			typeList[t.Address] = t
+			t := h.readType(h.byteReader)
+			h.typeList[t.Address] = t
 		case 4:
-			goroutines = append(goroutines, readGoroutine(h.byteReader))
+			h.goroutines = append(h.goroutines, h.readGoroutine(h.byteReader))
 		case 5:
-			stackFrame := readStackFrame(h.byteReader)
-			stackFrames[stackFrame.StackPointer] = stackFrame
+			stackFrame := h.readStackFrame(h.byteReader)
+			h.stackFrames[stackFrame.StackPointer] = stackFrame
+			if stackFrame.ChildFramePointer != 0 {
+				h.frameParents[stackFrame.ChildFramePointer] = stackFrame
+			}
 		case 6:
-			dumpParams = readDumpParams(h.byteReader)
+			h.dumpParams = h.readDumpParams(h.byteReader)
 		case 7:
-			readFinalizer(h.byteReader)
+			h.readFinalizer(h.byteReader)
 		case 8:
-			readiTab(h.byteReader)
+			h.readiTab(h.byteReader)
 		case 9:
 			readOSThread(h.byteReader)
 		case 10:
 			h.memStats = readMemStats(h.byteReader)
 		case 11:
-			readQueuedFinalizer(h.byteReader)
+			h.readQueuedFinalizer(h.byteReader)
 		case 12:
-			readDataSegment(h.byteReader)
+			h.readDataSegment(h.byteReader)
 		case 13:
-			readBSS(h.byteReader)
+			h.readBSS(h.byteReader)
 		case 14:
 			readDeferRecord(h.byteReader)
 		case 15:
 			readPanicRecord(h.byteReader)
 		case 16:
 			profile := readAllocFree(h.byteReader)
-			memProf[profile.Record] = profile
+			h.memProf[profile.Record] = profile
 		case 17:
-			allocs = append(allocs, readAllocSampleRecord(h.byteReader))
+			h.allocs = append(h.allocs, h.readAllocSampleRecord(h.byteReader))
 		default:
 			fmt.Println("Unknown object kind")
 			os.Exit(1)
@@ -95,16 +99,43 @@ func (h *HeapFile) parse() {
 	}
 }
 
-// (1) object: uvarint uvarint uvarint string
-func readObject(r io.ByteReader) *Object {
-	o := &Object{}
+// Builds the address ordered object list used to find objects containing an address
+func (h *HeapFile) sortObjects() {
+	h.sortedObjects = make([]*Object, 0, len(h.objectList))
+	for _, object := range h.objectList {
+		h.sortedObjects = append(h.sortedObjects, object)
+	}
+	sort.Sort(objectsByAddress(h.sortedObjects))
+}
+
+// Builds the type name index. Type names are not unique; the type with the lowest address wins.
+func (h *HeapFile) indexTypeNames() {
+	h.typeNames = make(map[string]*Type, len(h.typeList))
+	for _, t := range h.typeList {
+		if other, ok := h.typeNames[t.Name]; !ok || t.Address < other.Address {
+			h.typeNames[t.Name] = t
+		}
+	}
+}
+
+// (1) object: uvarint uvarint uvarint string, or in go1.7 uvarint string fieldlist
+func (h *HeapFile) readObject(r io.ByteReader) *Object {
+	o := &Object{heap: h}
 	o.Address = readUvarint(r)
+	if h.header == dumpHeader17 {
+		o.kind = kindUnknown
+		o.Content = readString(r)
+		o.Size = len(o.Content)
+		o.fields = readFieldList(r)
+		return o
+	}
+
 	o.TypeAddress = readUvarint(r)
 	o.kind = readUvarint(r)
 	o.Content = readString(r)
 	o.Size = len(o.Content)
 	if o.TypeAddress != 0 {
-		o.Type = typeList[o.TypeAddress]
+		o.Type = h.typeList[o.TypeAddress]
 	}
 	return o
 }
@@ -117,20 +148,22 @@ func readOtherRoot(r io.ByteReader) *Root {
 	return root
 }
 
-// (3) type: uvarint uvarint string bool fieldlist
-func readType(r io.ByteReader) *Type {
+// (3) type: uvarint uvarint string bool fieldlist, without the fieldlist in go1.7
+func (h *HeapFile) readType(r io.ByteReader) *Type {
 	t := &Type{}
 	t.Address = readUvarint(r)
 	t.Size = readUvarint(r)
 	t.Name = readString(r)
 	t.IsPtr = readUvarint(r) == 1
-	t.FieldList = readFieldList(r)
+	if h.header != dumpHeader17 {
+		t.FieldList = readFieldList(r)
+	}
 	return t
 }
 
 // (4) goroutine
-func readGoroutine(r io.ByteReader) *Goroutine {
-	g := &Goroutine{}
+func (h *HeapFile) readGoroutine(r io.ByteReader) *Goroutine {
+	g := &Goroutine{heap: h}
 	g.Address = readUvarint(r)
 	g.Top = readUvarint(r)
 	g.Id = readUvarint(r)
@@ -148,8 +181,8 @@ func readGoroutine(r io.ByteReader) *Goroutine {
 }
 
 // (5) stackframe
-func readStackFrame(r io.ByteReader) *StackFrame {
-	sf := &StackFrame{}
+func (h *HeapFile) readStackFrame(r io.ByteReader) *StackFrame {
+	sf := &StackFrame{heap: h}
 	sf.StackPointer = readUvarint(r)      // stack pointer (lowest address in frame)
 	sf.DepthInStack = readUvarint(r)      // depth in stack (0 = top of stack)
 	sf.ChildFramePointer = readUvarint(r) // stack pointer of child frame (or 0 if none)
@@ -162,11 +195,21 @@ func readStackFrame(r io.ByteReader) *StackFrame {
 	return sf
 }
 
-// (6) dump params: bool uvarint uvarint uvarint uvarint uvarint string varint
-func readDumpParams(r io.ByteReader) *DumpParams {
+// (6) dump params: bool uvarint uvarint uvarint uvarint uvarint string varint, or in go1.7
+// bool uvarint uvarint uvarint string string uvarint
+func (h *HeapFile) readDumpParams(r io.ByteReader) *DumpParams {
 	dumpParams := &DumpParams{}
-	dumpParams.BigEndian = (readUvarint(r) == 0)
+	dumpParams.BigEndian = (readUvarint(r) == 1)
 	dumpParams.PtrSize = readUvarint(r)
+	if h.header == dumpHeader17 {
+		dumpParams.StartAddress = readUvarint(r)
+		dumpParams.EndAddress = readUvarint(r)
+		dumpParams.GoArch = readString(r)
+		dumpParams.GoVersion = readString(r)
+		dumpParams.NCPU = readUvarint(r)
+		return dumpParams
+	}
+
 	dumpParams.ChHdrSize = readUvarint(r)
 	dumpParams.StartAddress = readUvarint(r)
 	dumpParams.EndAddress = readUvarint(r)
@@ -178,19 +221,23 @@ func readDumpParams(r io.ByteReader) *DumpParams {
 }
 
 // (7) registered finalizer
-func readFinalizer(r io.ByteReader) {
+func (h *HeapFile) readFinalizer(r io.ByteReader) {
 	f := &Finalizer{}
 	f.ObjectAddress = readUvarint(r)
 	f.FuncValPtr = readUvarint(r)
 	f.PC = readUvarint(r)
 	f.ArgType = readUvarint(r)
 	f.ObjectType = readUvarint(r)
-	finalizers = append(finalizers, f)
+	h.finalizers = append(h.finalizers, f)
 }
 
-// (8) itab: uvarint bool
-func readiTab(r io.ByteReader) {
-	readUvarint(r) // Itab address
+// (8) itab: uvarint bool, or in go1.7 uvarint uvarint
+func (h *HeapFile) readiTab(r io.ByteReader) {
+	address := readUvarint(r) // Itab address
+	if h.header == dumpHeader17 {
+		h.itabs[address] = readUvarint(r) // address of the type of values with this itab
+		return
+	}
 	readUvarint(r) // (bool) whether the data field of an Iface with this itab is a pointer
 }
 
@@ -201,6 +248,15 @@ func readOSThread(r io.ByteReader) {
 	readUvarint(r) // os's id for thread
 }
 
+// The runtime.MemStats counters a dump records, in the order they are written. The PauseNs
+// ring buffer follows PauseTotalNs.
+var MemStatsFields = []string{
+	"Alloc", "TotalAlloc", "Sys", "Lookups", "Mallocs", "Frees",
+	"HeapAlloc", "HeapSys", "HeapIdle", "HeapInuse", "HeapReleased", "HeapObjects",
+	"StackInuse", "StackSys", "MSpanInuse", "MSpanSys", "MCacheInuse", "MCacheSys",
+	"BuckHashSys", "GCSys", "OtherSys", "NextGC", "LastGC", "PauseTotalNs", "NumGC",
+}
+
 // (10) memstats
 func readMemStats(r io.ByteReader) *runtime.MemStats {
 	var memStats runtime.MemStats
@@ -236,30 +292,30 @@ func readMemStats(r io.ByteReader) *runtime.MemStats {
 }
 
 // (11) queued finalizer
-func readQueuedFinalizer(r io.ByteReader) {
+func (h *HeapFile) readQueuedFinalizer(r io.ByteReader) {
 	f := &Finalizer{}
 	f.ObjectAddress = readUvarint(r)
 	f.FuncValPtr = readUvarint(r)
 	f.PC = readUvarint(r)
 	f.ArgType = readUvarint(r)
 	f.ObjectType = readUvarint(r)
-	queuedFinalizers = append(queuedFinalizers, f)
+	h.queuedFinalizers = append(h.queuedFinalizers, f)
 }
 
 // (12) data segment
-func readDataSegment(r io.ByteReader) {
-	dataSegment.Address = readUvarint(r)
-	dataSegment.Content = readString(r)
-	dataSegment.Fields = readFieldList(r)
-	populateFieldContent(dataSegment.Fields, dataSegment.Content)
+func (h *HeapFile) readDataSegment(r io.ByteReader) {
+	h.dataSegment.Address = readUvarint(r)
+	h.dataSegment.Content = readString(r)
+	h.dataSegment.Fields = readFieldList(r)
+	populateFieldContent(h.dataSegment.Fields, h.dataSegment.Content)
 }
 
 // (13) bss
-func readBSS(r io.ByteReader) {
-	bss.Address = readUvarint(r)
-	bss.Content = readString(r)
-	bss.Fields = readFieldList(r)
-	populateFieldContent(bss.Fields, bss.Content)
+func (h *HeapFile) readBSS(r io.ByteReader) {
+	h.bss.Address = readUvarint(r)
+	h.bss.Content = readString(r)
+	h.bss.Fields = readFieldList(r)
+	populateFieldContent(h.bss.Fields, h.bss.Content)
 }
 
 // (14) defer record
@@ -307,8 +363,8 @@ func readAllocFree(r io.ByteReader) *Profile {
 }
 
 // (17) alloc stack trace sample
-func readAllocSampleRecord(r io.ByteReader) *Alloc {
-	alloc := &Alloc{}
+func (h *HeapFile) readAllocSampleRecord(r io.ByteReader) *Alloc {
+	alloc := &Alloc{heap: h}
 	alloc.objectAddress = readUvarint(r)
 	alloc.profileRecord = readUvarint(r)
 	return alloc
@@ -0,0 +1,139 @@
+package heapfile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A chain of references from a GC root to an object
+type RootPath struct {
+	Root    string    // description of the root
+	Objects []*Object // objects from the one the root points to, through to the target
+}
+
+func (p *RootPath) String() string {
+	parts := make([]string, 0, len(p.Objects)+1)
+	parts = append(parts, p.Root)
+	for _, object := range p.Objects {
+		parts = append(parts, fmt.Sprintf("0x%x %s", object.Address, object.Name()))
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// Returns a shortest path from a GC root to the object, or nil if it is unreachable
+func (h *HeapFile) RootPath(o *Object) *RootPath {
+	h.parse()
+	h.findRootPaths()
+
+	path := &RootPath{}
+	for object := o; object != nil; object = h.pathParents[object.Address] {
+		path.Objects = append(path.Objects, object)
+		if root, ok := h.pathRoots[object.Address]; ok {
+			path.Root = root
+			break
+		}
+	}
+	if path.Root == "" {
+		return nil
+	}
+
+	for i, j := 0, len(path.Objects)-1; i < j; i, j = i+1, j-1 {
+		path.Objects[i], path.Objects[j] = path.Objects[j], path.Objects[i]
+	}
+	return path
+}
+
+// Does a breadth first walk from the roots, recording the first root or object to reach each object
+func (h *HeapFile) findRootPaths() {
+	if h.pathParents != nil {
+		return
+	}
+	h.pathParents = make(map[uint64]*Object, len(h.objectList))
+	h.pathRoots = make(map[uint64]string, 0)
+
+	queue := make([]*Object, 0)
+	addRoot := func(description string, object *Object) {
+		if object == nil {
+			return
+		}
+		if _, ok := h.pathRoots[object.Address]; ok {
+			return
+		}
+		h.pathRoots[object.Address] = description
+		queue = append(queue, object)
+	}
+
+	h.forEachRoot(addRoot)
+
+	for len(queue) > 0 {
+		object := queue[0]
+		queue = queue[1:]
+		for _, child := range object.Children() {
+			if _, ok := h.pathRoots[child.Address]; ok {
+				continue
+			}
+			if _, ok := h.pathParents[child.Address]; ok {
+				continue
+			}
+			h.pathParents[child.Address] = object
+			queue = append(queue, child)
+		}
+	}
+}
+
+// Returns the heap objects that point to the object, in address order
+func (h *HeapFile) Referrers(o *Object) []*Object {
+	h.parse()
+	h.findReferrers()
+	return h.referrers[o.Address]
+}
+
+func (h *HeapFile) findReferrers() {
+	if h.referrers != nil {
+		return
+	}
+	h.referrers = make(map[uint64][]*Object, len(h.objectList))
+	for _, object := range h.sortedObjects {
+		seen := make(map[uint64]bool, 0)
+		for _, child := range object.Children() {
+			if seen[child.Address] {
+				continue
+			}
+			seen[child.Address] = true
+			h.referrers[child.Address] = append(h.referrers[child.Address], object)
+		}
+	}
+}
+
+// Calls fn with a description of each GC root and the object it points to. Roots are
+// visited in a stable order: data, bss, stack frames, other roots and then finalizers.
+func (h *HeapFile) forEachRoot(fn func(description string, object *Object)) {
+	for _, object := range h.dataSegment.Objects() {
+		fn("data", object)
+	}
+	for _, object := range h.bss.Objects() {
+		fn("bss", object)
+	}
+
+	frames := make([]*StackFrame, 0, len(h.stackFrames))
+	for _, frame := range h.stackFrames {
+		frames = append(frames, frame)
+	}
+	sort.Sort(framesByAddress(frames))
+	for _, frame := range frames {
+		for _, object := range frame.Objects() {
+			fn("frame "+frame.Name, object)
+		}
+	}
+
+	for _, root := range h.roots {
+		fn(root.Description, h.objectList[root.Pointer])
+	}
+	for _, f := range h.finalizers {
+		fn("finalizer", h.objectList[f.ObjectAddress])
+	}
+	for _, f := range h.queuedFinalizers {
+		fn("queued finalizer", h.objectList[f.ObjectAddress])
+	}
+}
@@ -0,0 +1,164 @@
+package heapfile
+
+import (
+	"fmt"
+	"github.com/google/pprof/profile"
+	"io"
+	"sort"
+)
+
+// The default runtime.MemProfileRate, used as the profile's sampling period. Counts are
+// written as they were sampled; the dump doesn't record the rate so they are not scaled.
+const memProfileRate = 512 * 1024
+
+// Builds a pprof heap profile. By default the samples are the memory profile records, with
+// alloc_objects, alloc_space, inuse_objects and inuse_space values. If liveObjects is true the
+// samples are synthesized from the live objects instead, with a frame for each type on top of
+// the allocation stack when the object was sampled; their alloc and inuse values are the same.
+func (h *HeapFile) Pprof(liveObjects bool) *profile.Profile {
+	h.parse()
+
+	b := &pprofBuilder{
+		profile: &profile.Profile{
+			SampleType: []*profile.ValueType{
+				{Type: "alloc_objects", Unit: "count"},
+				{Type: "alloc_space", Unit: "bytes"},
+				{Type: "inuse_objects", Unit: "count"},
+				{Type: "inuse_space", Unit: "bytes"},
+			},
+			DefaultSampleType: "inuse_space",
+			PeriodType:        &profile.ValueType{Type: "space", Unit: "bytes"},
+			Period:            memProfileRate,
+			Comments:          []string{"generated by gohat from " + h.Name},
+		},
+		functions: make(map[string]*profile.Function, 0),
+		locations: make(map[string]*profile.Location, 0),
+	}
+	if h.memStats != nil {
+		b.profile.TimeNanos = int64(h.memStats.LastGC)
+	}
+
+	if liveObjects {
+		h.pprofLiveObjects(b)
+	} else {
+		h.pprofMemProf(b)
+	}
+	return b.profile
+}
+
+// Writes the pprof heap profile to w, gzip compressed
+func (h *HeapFile) WritePprof(w io.Writer, liveObjects bool) error {
+	p := h.Pprof(liveObjects)
+	if err := p.CheckValid(); err != nil {
+		return err
+	}
+	return p.Write(w)
+}
+
+func (h *HeapFile) pprofMemProf(b *pprofBuilder) {
+	profiles := h.MemProf()
+	sort.Sort(profilesByRecord(profiles))
+	for _, p := range profiles {
+		inUse := int64(p.Allocs) - int64(p.Frees)
+		if inUse < 0 {
+			inUse = 0
+		}
+		b.profile.Sample = append(b.profile.Sample, &profile.Sample{
+			Location: b.stack("", p.Frames),
+			Value:    []int64{int64(p.Allocs), int64(p.Allocs * p.Size), inUse, inUse * int64(p.Size)},
+			NumLabel: map[string][]int64{"bytes": {int64(p.Size)}},
+		})
+	}
+}
+
+func (h *HeapFile) pprofLiveObjects(b *pprofBuilder) {
+	sampled := make(map[uint64]*Profile, len(h.allocs))
+	for _, alloc := range h.allocs {
+		if p := alloc.Profile(); p != nil {
+			sampled[alloc.objectAddress] = p
+		}
+	}
+
+	type liveKey struct {
+		name   string
+		record uint64 // profile record of the sampled objects, or 0
+	}
+	samples := make(map[liveKey]*profile.Sample, 0)
+	for _, object := range h.sortedObjects {
+		p := sampled[object.Address]
+		key := liveKey{name: object.Name()}
+		var frames []*Frame
+		if p != nil {
+			key.record = p.Record
+			frames = p.Frames
+		}
+
+		sample, ok := samples[key]
+		if !ok {
+			sample = &profile.Sample{
+				Location: b.stack(key.name, frames),
+				Value:    make([]int64, 4),
+			}
+			samples[key] = sample
+			b.profile.Sample = append(b.profile.Sample, sample)
+		}
+		sample.Value[0]++
+		sample.Value[1] += int64(object.Size)
+		sample.Value[2]++
+		sample.Value[3] += int64(object.Size)
+	}
+}
+
+// Deduplicates the functions and locations of a profile as it is built. The dump has no
+// program counters, so locations are identified by function, file and line.
+type pprofBuilder struct {
+	profile   *profile.Profile
+	functions map[string]*profile.Function
+	locations map[string]*profile.Location
+}
+
+// Returns the locations for frames, innermost first, with a synthetic frame named
+// typeName on top if it is not empty
+func (b *pprofBuilder) stack(typeName string, frames []*Frame) []*profile.Location {
+	locations := make([]*profile.Location, 0, len(frames)+1)
+	if typeName != "" {
+		locations = append(locations, b.location(typeName, "", 0))
+	}
+	for _, frame := range frames {
+		locations = append(locations, b.location(frame.Name, frame.File, int64(frame.Line)))
+	}
+	return locations
+}
+
+func (b *pprofBuilder) location(name, file string, line int64) *profile.Location {
+	key := fmt.Sprintf("%s\n%s\n%d", name, file, line)
+	if location, ok := b.locations[key]; ok {
+		return location
+	}
+
+	function, ok := b.functions[name+"\n"+file]
+	if !ok {
+		function = &profile.Function{
+			ID:         uint64(len(b.profile.Function) + 1),
+			Name:       name,
+			SystemName: name,
+			Filename:   file,
+		}
+		b.functions[name+"\n"+file] = function
+		b.profile.Function = append(b.profile.Function, function)
+	}
+
+	location := &profile.Location{
+		ID:   uint64(len(b.profile.Location) + 1),
+		Line: []profile.Line{{Function: function, Line: line}},
+	}
+	b.locations[key] = location
+	b.profile.Location = append(b.profile.Location, location)
+	return location
+}
+
+type profilesByRecord []*Profile
+
+func (p profilesByRecord) Len() int           { return len(p) }
+func (p profilesByRecord) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p profilesByRecord) Less(i, j int) bool { return p[i].Record < p[j].Record }
@@ -0,0 +1,391 @@
+package heapfile
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+type RedactMode int
+
+const (
+	RedactZero     RedactMode = iota // non-pointer bytes are zeroed
+	RedactScramble                   // non-pointer bytes are replaced with keyed pseudo-random bytes
+)
+
+// Selects what is redacted. With no types and Strings false, every object, stack frame,
+// the data segment and the bss are redacted.
+type RedactOptions struct {
+	Mode    RedactMode
+	Types   []*regexp.Regexp // redact objects whose type name matches one of these
+	Strings bool             // redact objects holding string data
+}
+
+// Record layouts of go1.3 dumps, used to copy records without interpreting them. Each
+// letter is a uvarint (u), a string (s), a field list (f), or the variable length frame
+// list of a memprof record (m).
+var recordLayouts = map[uint64]string{
+	1:  "uuus",
+	2:  "su",
+	3:  "uusuf",
+	4:  "uuuuuuuusuuuu",
+	5:  "uuusuuusf",
+	6:  "uuuuuusu",
+	7:  "uuuuu",
+	8:  "uu",
+	9:  "uuu",
+	10: strings.Repeat("u", 24+256+1), // general statistics, pauses and NumGC
+	11: "uuuuu",
+	12: "usf",
+	13: "usf",
+	14: "uuuuuuu",
+	15: "uuuuuu",
+	16: "uumuu",
+	17: "uu",
+}
+
+// Record layouts of go1.7 dumps that differ from go1.3
+var recordLayouts17 = map[uint64]string{
+	1: "usf",
+	3: "uusu",
+	6: "uuuussu",
+}
+
+// Returns the layout of a record kind in the heap file's format version
+func (h *HeapFile) recordLayout(kind uint64) string {
+	if layout, ok := recordLayouts17[kind]; ok && h.header == dumpHeader17 {
+		return layout
+	}
+	return recordLayouts[kind]
+}
+
+// Writes a copy of the heap file with the non-pointer contents of objects redacted. Pointer
+// containing fields from the type's field list and any words that point at heap objects
+// are kept, so the object graph, sizes, types, goroutines and profiles are unchanged. The
+// types of go1.7 dumps are inferred again when the copy is opened, from what is kept, so a
+// few objects may no longer be typed.
+func (h *HeapFile) Redact(w io.Writer, options *RedactOptions) error {
+	h.parse()
+
+	in, err := os.Open(h.path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r := bufio.NewReader(in)
+	if _, err := r.Discard(len(h.header)); err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(w)
+	out.WriteString(h.header)
+
+	redactor := &redactor{heap: h, options: options, all: len(options.Types) == 0 && !options.Strings}
+	if options.Mode == RedactScramble {
+		if _, err := rand.Read(redactor.key[:]); err != nil {
+			return err
+		}
+	}
+	if options.Strings {
+		redactor.stringData = h.stringDataObjects()
+	}
+
+	for {
+		kind, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		writeUvarint(out, kind)
+		if kind == 0 {
+			return out.Flush()
+		}
+
+		layout := h.recordLayout(kind)
+		if layout == "" {
+			return ErrInvalidHeapFile
+		}
+
+		values := make([]interface{}, 0, len(layout))
+		for _, c := range layout {
+			var v interface{}
+			switch c {
+			case 'u':
+				v, err = binary.ReadUvarint(r)
+			case 's':
+				v, err = readStringChecked(r)
+			case 'f':
+				v, err = readFieldListChecked(r)
+			case 'm':
+				v, err = readProfileFramesChecked(r)
+			}
+			if err != nil {
+				return err
+			}
+			values = append(values, v)
+		}
+
+		redactor.redactRecord(kind, values)
+
+		for _, v := range values {
+			switch v := v.(type) {
+			case uint64:
+				writeUvarint(out, v)
+			case string:
+				writeString(out, v)
+			case []*Field:
+				for _, field := range v {
+					writeUvarint(out, field.Kind)
+					writeUvarint(out, field.Offset)
+				}
+				writeUvarint(out, 0)
+			case []*Frame:
+				writeUvarint(out, uint64(len(v)))
+				for _, frame := range v {
+					writeString(out, frame.Name)
+					writeString(out, frame.File)
+					writeUvarint(out, frame.Line)
+				}
+			}
+		}
+	}
+}
+
+type redactor struct {
+	heap       *HeapFile
+	options    *RedactOptions
+	all        bool
+	key        [32]byte
+	stringData map[uint64]bool
+}
+
+func (r *redactor) redactRecord(kind uint64, values []interface{}) {
+	switch kind {
+	case 1:
+		address := values[0].(uint64)
+		object := r.heap.objectList[address]
+		if object == nil || !r.selected(object) {
+			return
+		}
+		content := 3
+		if r.heap.header == dumpHeader17 {
+			content = 1
+		}
+		values[content] = r.redactObject(object, values[content].(string))
+	case 5:
+		if r.all {
+			values[3] = r.redact(values[3].(string), values[8].([]*Field), 0, 0)
+		}
+	case 12, 13:
+		if r.all {
+			values[1] = r.redact(values[1].(string), values[2].([]*Field), 0, 0)
+		}
+	}
+}
+
+// Redacts an object's contents. Structural data the runtime keeps in map headers, map
+// bucket tophashes and channel headers is kept so maps and channels can still be decoded.
+func (r *redactor) redactObject(object *Object, content string) string {
+	if object.Type == nil {
+		return r.redact(content, object.fields, 0, 0)
+	}
+	if object.IsMap() {
+		return content
+	}
+
+	switch object.kind {
+	case 1:
+		if strings.HasPrefix(object.Type.Name, "map.bucket[") {
+			tophash := &Field{Kind: 0, Offset: 0}
+			return r.redact(content, append([]*Field{tophash}, object.Type.FieldList...), object.Type.Size, 0)
+		}
+		return r.redact(content, object.Type.FieldList, object.Type.Size, 0)
+	case 2:
+		return r.redact(content, object.Type.FieldList, object.Type.Size, r.heap.dumpParams.ChHdrSize)
+	}
+	return r.redact(content, object.Type.FieldList, 0, 0)
+}
+
+func (r *redactor) selected(object *Object) bool {
+	if r.all {
+		return true
+	}
+	if r.stringData[object.Address] {
+		return true
+	}
+	for _, re := range r.options.Types {
+		if re.MatchString(object.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacts everything in content except the first start bytes, pointer containing fields and
+// words pointing at heap objects, types or itabs. Fields repeat every elemSize bytes after start if elemSize
+// is not 0. The lengths of strings and slices and the type words of interfaces are kept.
+// Fields of kind 0 keep one bucket's worth of tophash bytes.
+func (r *redactor) redact(content string, fields []*Field, elemSize, start uint64) string {
+	keep := make([]bool, len(content))
+	size := uint64(len(content))
+	keepRange := func(from, to uint64) {
+		for i := from; i < to && i < size; i++ {
+			keep[i] = true
+		}
+	}
+
+	keepRange(0, start)
+	if elemSize == 0 {
+		elemSize = size
+	}
+	for base := start; elemSize > 0 && base < size; base += elemSize {
+		for _, field := range fields {
+			offset := base + field.Offset
+			switch field.Kind {
+			case 0:
+				keepRange(offset, offset+bucketCount)
+			case FieldIface, FieldEface:
+				keepRange(offset, offset+r.heap.dumpParams.PtrSize)
+			default:
+				keepRange(offset, offset+r.heap.fieldSize(field.Kind))
+			}
+		}
+	}
+
+	for i := uint64(0); i+r.heap.dumpParams.PtrSize <= size; i += r.heap.dumpParams.PtrSize {
+		word := r.heap.readPtr(content[i:], 0)
+		if r.heap.objectContaining(word) != nil || r.heap.typeList[word] != nil || r.heap.itabs[word] != 0 {
+			keepRange(i, i+r.heap.dumpParams.PtrSize)
+		}
+	}
+
+	redacted := []byte(content)
+	var stream []byte
+	if r.options.Mode == RedactScramble {
+		stream = r.keystream(content, keep)
+	}
+	for i := range redacted {
+		if keep[i] {
+			continue
+		}
+		if stream == nil {
+			redacted[i] = 0
+		} else if redacted[i] >= 0x20 && redacted[i] <= 0x7e {
+			redacted[i] = 'a' + stream[i]%26
+		} else if redacted[i] != 0 {
+			redacted[i] = stream[i]
+		}
+	}
+	return string(redacted)
+}
+
+// Generates pseudo-random bytes from the run's key and the redacted content, so identical
+// contents scramble identically
+func (r *redactor) keystream(content string, keep []bool) []byte {
+	seed := sha256.New()
+	seed.Write(r.key[:])
+	for i := range keep {
+		if !keep[i] {
+			seed.Write([]byte{content[i]})
+		}
+	}
+	sum := seed.Sum(nil)
+
+	stream := make([]byte, 0, len(content)+sha256.Size)
+	for counter := uint64(0); len(stream) < len(content); counter++ {
+		block := sha256.New()
+		block.Write(sum)
+		binary.Write(block, binary.LittleEndian, counter)
+		stream = block.Sum(stream)
+	}
+	return stream
+}
+
+// Returns the addresses of objects that hold string data
+func (h *HeapFile) stringDataObjects() map[uint64]bool {
+	data := make(map[uint64]bool, 0)
+	add := func(content string, offset uint64) {
+		if object := h.objectContaining(h.readPtr(content[offset:], 0)); object != nil && object.Type == nil {
+			data[object.Address] = true
+		}
+	}
+
+	for _, object := range h.objectList {
+		o := object
+		o.forEachField(FieldStr, func(offset uint64) { add(o.Content, offset) })
+	}
+	for _, segment := range []*Segment{h.dataSegment, h.bss} {
+		s := segment
+		h.forEachFieldIn(s.Fields, s.Content, FieldStr, func(offset uint64) { add(s.Content, offset) })
+	}
+	for _, frame := range h.stackFrames {
+		f := frame
+		h.forEachFieldIn(f.FieldList, f.Content, FieldStr, func(offset uint64) { add(f.Content, offset) })
+	}
+	return data
+}
+
+func readStringChecked(r *bufio.Reader) (string, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func readFieldListChecked(r *bufio.Reader) ([]*Field, error) {
+	fields := make([]*Field, 0)
+	for {
+		kind, err := binary.ReadUvarint(r)
+		if err != nil || kind == 0 {
+			return fields, err
+		}
+		offset, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, &Field{kind, offset, ""})
+	}
+}
+
+func readProfileFramesChecked(r *bufio.Reader) ([]*Frame, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	frames := make([]*Frame, 0, n)
+	for i := uint64(0); i < n; i++ {
+		frame := &Frame{}
+		if frame.Name, err = readStringChecked(r); err != nil {
+			return nil, err
+		}
+		if frame.File, err = readStringChecked(r); err != nil {
+			return nil, err
+		}
+		if frame.Line, err = binary.ReadUvarint(r); err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+func writeUvarint(w *bufio.Writer, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	w.Write(buf[:n])
+}
+
+func writeString(w *bufio.Writer, s string) {
+	writeUvarint(w, uint64(len(s)))
+	w.WriteString(s)
+}
@@ -0,0 +1,96 @@
+package heapfile
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// A main.T holding a pointer, a string header and two words of data, and the objects it points to
+func openRedactDump(t *testing.T) *HeapFile {
+	return openTestDump(t, dumpHeader13, testParams13,
+		testRecord{3, 0x100, 32, "main.T", 1, []*Field{{FieldPtr, 0, ""}, {FieldStr, 8, ""}}},
+		testRecord{1, 0xc000, 0x100, 0, testWord(0xc100) + testWord(0xc200) + testWord(5) + testWord(0xdeadbeef)},
+		testRecord{1, 0xc100, 0, 0, "sixteen byte key"},
+		testRecord{1, 0xc200, 0, 0, "hello\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"},
+		testRecord{1, 0xc300, 0, 0, "sixteen byte key"},
+	)
+}
+
+// Redacts h and opens the copy. The caller removes the file.
+func redactTestDump(t *testing.T, h *HeapFile, options *RedactOptions) *HeapFile {
+	f, err := ioutil.TempFile("", "heapfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := h.Redact(f, options); err != nil {
+		os.Remove(f.Name())
+		t.Fatal(err)
+	}
+	redacted, err := New(f.Name())
+	if err != nil {
+		os.Remove(f.Name())
+		t.Fatal(err)
+	}
+	redacted.parse()
+	return redacted
+}
+
+func TestRedactKeepsTheObjectGraph(t *testing.T) {
+	h := openRedactDump(t)
+	defer os.Remove(h.path)
+	redacted := redactTestDump(t, h, &RedactOptions{})
+	defer os.Remove(redacted.path)
+
+	if len(redacted.Objects()) != len(h.Objects()) {
+		t.Fatalf("%d objects after redacting, expected %d", len(redacted.Objects()), len(h.Objects()))
+	}
+	expected := map[uint64]string{
+		// The pointer, string header and length are kept, the data word is zeroed
+		0xc000: testWord(0xc100) + testWord(0xc200) + testWord(5) + testWord(0),
+		0xc100: strings.Repeat("\x00", 16),
+		0xc200: strings.Repeat("\x00", 16),
+		0xc300: strings.Repeat("\x00", 16),
+	}
+	for addr, content := range expected {
+		object := redacted.Object(addr)
+		if object == nil {
+			t.Errorf("object 0x%x is missing", addr)
+			continue
+		}
+		if object.Content != content {
+			t.Errorf("object 0x%x holds %q, expected %q", addr, object.Content, content)
+		}
+		if original := h.Object(addr); object.Name() != original.Name() {
+			t.Errorf("object 0x%x is a %s, expected %s", addr, object.Name(), original.Name())
+		}
+	}
+}
+
+func TestRedactTypesAndScramble(t *testing.T) {
+	h := openRedactDump(t)
+	defer os.Remove(h.path)
+	redacted := redactTestDump(t, h, &RedactOptions{Mode: RedactScramble, Types: []*regexp.Regexp{regexp.MustCompile(`^main\.T$`)}})
+	defer os.Remove(redacted.path)
+
+	object := redacted.Object(0xc000)
+	if object.Content[:24] != h.Object(0xc000).Content[:24] || object.Content[24:] == testWord(0xdeadbeef) {
+		t.Errorf("main.T redacted to %q", object.Content)
+	}
+	for _, addr := range []uint64{0xc100, 0xc200, 0xc300} {
+		if redacted.Object(addr).Content != h.Object(addr).Content {
+			t.Errorf("object 0x%x of another type was redacted", addr)
+		}
+	}
+
+	// Equal contents scramble the same way within a copy
+	redacted = redactTestDump(t, h, &RedactOptions{Mode: RedactScramble})
+	defer os.Remove(redacted.path)
+	first, second := redacted.Object(0xc100).Content, redacted.Object(0xc300).Content
+	if first != second || first == h.Object(0xc100).Content {
+		t.Errorf("equal objects scrambled to %q and %q", first, second)
+	}
+}
@@ -0,0 +1,133 @@
+package heapfile
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+)
+
+// Finds the offsets of matches in content
+type Matcher func(content string) []uint64
+
+// Matches a literal byte sequence
+func BytesMatcher(pattern []byte) Matcher {
+	return func(content string) []uint64 {
+		offsets := make([]uint64, 0)
+		if len(pattern) == 0 {
+			return offsets
+		}
+		data := []byte(content)
+		start := 0
+		for {
+			idx := bytes.Index(data[start:], pattern)
+			if idx < 0 {
+				return offsets
+			}
+			offsets = append(offsets, uint64(start+idx))
+			start += idx + 1
+		}
+	}
+}
+
+// Matches a regular expression
+func RegexpMatcher(re *regexp.Regexp) Matcher {
+	return func(content string) []uint64 {
+		offsets := make([]uint64, 0)
+		for _, match := range re.FindAllStringIndex(content, -1) {
+			offsets = append(offsets, uint64(match[0]))
+		}
+		return offsets
+	}
+}
+
+// Matches a pointer sized word, encoded in the dump's byte order, at pointer aligned offsets.
+// Works for both integers and pointers.
+func (h *HeapFile) WordMatcher(v uint64) Matcher {
+	h.parse()
+	match := BytesMatcher([]byte(h.writePtr(v)))
+	return func(content string) []uint64 {
+		offsets := make([]uint64, 0)
+		for _, offset := range match(content) {
+			if offset%h.dumpParams.PtrSize == 0 {
+				offsets = append(offsets, offset)
+			}
+		}
+		return offsets
+	}
+}
+
+// A match found by Search
+type SearchHit struct {
+	Address uint64      // address of the match
+	Object  *Object     // object containing the match, if on the heap
+	Frame   *StackFrame // stack frame containing the match, if on a stack
+	Segment string      // "data" or "bss" if in one of the segments
+	Offset  uint64      // offset of the match in its object, frame or segment
+}
+
+// Returns the name of what contains the match
+func (s *SearchHit) Location() string {
+	switch {
+	case s.Object != nil:
+		return s.Object.Name()
+	case s.Frame != nil:
+		return "frame " + s.Frame.Name
+	}
+	return s.Segment
+}
+
+// Returns the address of the object, frame or segment containing the match
+func (s *SearchHit) Base() uint64 {
+	return s.Address - s.Offset
+}
+
+// Searches objects, stack frames, the data segment and the bss. Objects are searched in
+// address order. At most limit hits are returned, or all of them if limit is 0.
+func (h *HeapFile) Search(match Matcher, limit int) []*SearchHit {
+	h.parse()
+	hits := make([]*SearchHit, 0)
+	full := func() bool {
+		return limit > 0 && len(hits) >= limit
+	}
+
+	for _, object := range h.sortedObjects {
+		for _, offset := range match(object.Content) {
+			if full() {
+				return hits
+			}
+			hits = append(hits, &SearchHit{Address: object.Address + offset, Object: object, Offset: offset})
+		}
+	}
+
+	frames := make([]*StackFrame, 0, len(h.stackFrames))
+	for _, frame := range h.stackFrames {
+		frames = append(frames, frame)
+	}
+	sort.Sort(framesByAddress(frames))
+	for _, frame := range frames {
+		for _, offset := range match(frame.Content) {
+			if full() {
+				return hits
+			}
+			hits = append(hits, &SearchHit{Address: frame.StackPointer + offset, Frame: frame, Offset: offset})
+		}
+	}
+
+	segments := map[string]*Segment{"data": h.dataSegment, "bss": h.bss}
+	for _, name := range []string{"data", "bss"} {
+		segment := segments[name]
+		for _, offset := range match(segment.Content) {
+			if full() {
+				return hits
+			}
+			hits = append(hits, &SearchHit{Address: segment.Address + offset, Segment: name, Offset: offset})
+		}
+	}
+	return hits
+}
+
+type framesByAddress []*StackFrame
+
+func (f framesByAddress) Len() int           { return len(f) }
+func (f framesByAddress) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f framesByAddress) Less(i, j int) bool { return f[i].StackPointer < f[j].StackPointer }
@@ -0,0 +1,69 @@
+package heapfile
+
+import (
+	"regexp"
+	"sort"
+)
+
+// A pattern for sensitive data
+type SecretPattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+}
+
+// Patterns for common credentials
+var DefaultSecretPatterns = []*SecretPattern{
+	{"private-key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY( BLOCK)?-----`)},
+	{"aws-access-key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"aws-secret-key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`)},
+	{"jwt", regexp.MustCompile(`eyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{"bearer-token", regexp.MustCompile(`(?i)bearer [A-Za-z0-9._~+/-]{16,}=*`)},
+	{"basic-auth", regexp.MustCompile(`(?i)basic [A-Za-z0-9+/]{16,}=*`)},
+	{"github-token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{"url-password", regexp.MustCompile(`[a-z][a-z0-9+.-]*://[^:/@\s]+:[^@/\s]+@`)},
+}
+
+// A match of a secret pattern
+type Secret struct {
+	Pattern *SecretPattern
+	Object  *Object // object containing the match
+	Offset  uint64  // offset of the match in the object
+	Match   string  // the matched text
+}
+
+// Scans object contents for the patterns. Secrets are returned in address order.
+func (h *HeapFile) Secrets(patterns []*SecretPattern) []*Secret {
+	h.parse()
+	secrets := make([]*Secret, 0)
+	for _, object := range h.sortedObjects {
+		found := make([]*Secret, 0)
+		for _, pattern := range patterns {
+			for _, match := range pattern.Regexp.FindAllStringIndex(object.Content, -1) {
+				found = append(found, &Secret{
+					Pattern: pattern,
+					Object:  object,
+					Offset:  uint64(match[0]),
+					Match:   object.Content[match[0]:match[1]],
+				})
+			}
+		}
+		sort.Sort(secretsByOffset(found))
+		secrets = append(secrets, found...)
+	}
+	return secrets
+}
+
+// Returns the match with all but its first few characters hidden
+func (s *Secret) Redacted() string {
+	if len(s.Match) <= 8 {
+		return "****"
+	}
+	return s.Match[:4] + "****"
+}
+
+type secretsByOffset []*Secret
+
+func (s secretsByOffset) Len() int           { return len(s) }
+func (s secretsByOffset) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s secretsByOffset) Less(i, j int) bool { return s[i].Offset < s[j].Offset }
@@ -0,0 +1,188 @@
+package heapfile
+
+import (
+	"sort"
+)
+
+// Growth of a type or allocation site across a series of heap dumps
+type Trend struct {
+	Name       string   `json:"name"`            // type name, or the innermost frame of an allocation site
+	Stack      []string `json:"stack,omitempty"` // stack of an allocation site
+	Counts     []int64  `json:"counts"`          // live objects in each dump
+	Bytes      []int64  `json:"bytes"`           // live bytes in each dump
+	Slope      float64  `json:"slope"`           // least squares growth in bytes per dump
+	CountSlope float64  `json:"count_slope"`     // least squares growth in objects per dump
+	Confidence float64  `json:"confidence"`      // coefficient of determination (r²) of the bytes fit
+	Survivors  int64    `json:"survivors"`       // objects of a type in the first dump still live in the last
+}
+
+// Types and allocation sites that grew across a series of heap dumps
+type TrendReport struct {
+	Snapshots []string `json:"snapshots"`
+	Types     []*Trend `json:"types"`
+	Sites     []*Trend `json:"sites"`
+}
+
+// Finds the types and allocation sites whose live counts and bytes never shrink across the
+// heap dumps, which should be in the order they were taken, and grow overall. Site counts
+// are in use objects from the memory profile. Objects are followed between dumps with Match to
+// count how many of each type survived from the first dump. Trends are sorted by slope, largest first.
+func Trends(heapFiles []*HeapFile) *TrendReport {
+	report := &TrendReport{Snapshots: make([]string, 0, len(heapFiles))}
+	types := make(map[string]*Trend, 0)
+	sites := make(map[string]*Trend, 0)
+
+	trend := func(trends map[string]*Trend, key string) *Trend {
+		t, ok := trends[key]
+		if !ok {
+			t = &Trend{
+				Name:   key,
+				Counts: make([]int64, len(heapFiles)),
+				Bytes:  make([]int64, len(heapFiles)),
+			}
+			trends[key] = t
+		}
+		return t
+	}
+
+	for i, h := range heapFiles {
+		h.parse()
+		report.Snapshots = append(report.Snapshots, h.Name)
+
+		for _, object := range h.objectList {
+			t := trend(types, object.Name())
+			t.Counts[i]++
+			t.Bytes[i] += int64(object.Size)
+		}
+
+		for _, profile := range h.memProf {
+			key, stack := profileSite(profile)
+			t := trend(sites, key)
+			if t.Stack == nil {
+				t.Stack = stack
+				t.Name = "unknown"
+				if len(stack) > 0 {
+					t.Name = stack[0]
+				}
+			}
+			inUse := int64(profile.Allocs) - int64(profile.Frees)
+			t.Counts[i] += inUse
+			t.Bytes[i] += inUse * int64(profile.Size)
+		}
+	}
+
+	for name, survivors := range survivors(heapFiles) {
+		if t, ok := types[name]; ok {
+			t.Survivors = survivors
+		}
+	}
+
+	report.Types = growing(types)
+	report.Sites = growing(sites)
+	return report
+}
+
+// Follows the objects of the first heap dump through each later dump with Match, and
+// counts the ones that are still live in the last dump by type name
+func survivors(heapFiles []*HeapFile) map[string]int64 {
+	counts := make(map[string]int64, 0)
+	if len(heapFiles) < 2 {
+		return counts
+	}
+
+	live := make([]*Object, 0, len(heapFiles[0].objectList))
+	for _, object := range heapFiles[0].sortedObjects {
+		live = append(live, object)
+	}
+	names := make(map[*Object]string, len(live))
+	for _, object := range live {
+		names[object] = object.Name()
+	}
+
+	for i := 1; i < len(heapFiles); i++ {
+		matching := Match(heapFiles[i-1], heapFiles[i])
+		next := make([]*Object, 0, len(live))
+		for _, object := range live {
+			if counterpart := matching.Counterpart(object); counterpart != nil {
+				names[counterpart] = names[object]
+				next = append(next, counterpart)
+			}
+		}
+		live = next
+	}
+
+	for _, object := range live {
+		counts[names[object]]++
+	}
+	return counts
+}
+
+// Returns the trends that never shrink and grow overall, with their slopes filled in
+func growing(trends map[string]*Trend) []*Trend {
+	result := make([]*Trend, 0)
+	for _, t := range trends {
+		if len(t.Counts) < 2 || !nonDecreasing(t.Counts) || !nonDecreasing(t.Bytes) {
+			continue
+		}
+		last := len(t.Counts) - 1
+		if t.Counts[last] == t.Counts[0] && t.Bytes[last] == t.Bytes[0] {
+			continue
+		}
+		t.Slope, t.Confidence = linearFit(t.Bytes)
+		t.CountSlope, _ = linearFit(t.Counts)
+		result = append(result, t)
+	}
+	sort.Sort(trendsBySlope(result))
+	return result
+}
+
+func nonDecreasing(values []int64) bool {
+	for i := 1; i < len(values); i++ {
+		if values[i] < values[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// Fits a line to the values by least squares, using each value's index as x. Returns the
+// slope and the coefficient of determination, which is 1 when the growth is perfectly linear.
+func linearFit(values []int64) (float64, float64) {
+	n := float64(len(values))
+	var sumX, sumY float64
+	for i, v := range values {
+		sumX += float64(i)
+		sumY += float64(v)
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var sxx, sxy, syy float64
+	for i, v := range values {
+		dx, dy := float64(i)-meanX, float64(v)-meanY
+		sxx += dx * dx
+		sxy += dx * dy
+		syy += dy * dy
+	}
+	if sxx == 0 {
+		return 0, 0
+	}
+	slope := sxy / sxx
+	if syy == 0 {
+		return slope, 0
+	}
+	return slope, (sxy * sxy) / (sxx * syy)
+}
+
+type trendsBySlope []*Trend
+
+func (t trendsBySlope) Len() int      { return len(t) }
+func (t trendsBySlope) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t trendsBySlope) Less(i, j int) bool {
+	if t[i].Slope != t[j].Slope {
+		return t[i].Slope > t[j].Slope
+	}
+	if t[i].CountSlope != t[j].CountSlope {
+		return t[i].CountSlope > t[j].CountSlope
+	}
+	return t[i].Name < t[j].Name
+}
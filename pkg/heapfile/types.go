@@ -1,25 +1,24 @@
 package heapfile
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
 )
 
 type Alloc struct {
 	objectAddress uint64 // address of object
 	profileRecord uint64 // alloc/free profile record identifier
+	heap          *HeapFile
 }
 
 func (a *Alloc) Object() *Object {
-	if obj, ok := objectList[a.objectAddress]; ok {
+	if obj, ok := a.heap.objectList[a.objectAddress]; ok {
 		return obj
 	}
 	return nil
 }
 
 func (a *Alloc) Profile() *Profile {
-	if profile, ok := memProf[a.profileRecord]; ok {
+	if profile, ok := a.heap.memProf[a.profileRecord]; ok {
 		return profile
 	}
 	return nil
@@ -30,27 +29,26 @@ type Segment struct {
 	Address uint64   // address of the start of the data segment
 	Content string   // contents of the data segment
 	Fields  []*Field // kind and offset of pointer-containing fields in the data segment.
+	heap    *HeapFile
 }
 
 // Returns objects the stack frame points to that are on the heap
 func (s *Segment) Objects() []*Object {
-	params := dumpParams
-	var addr uint64
-	var lastIndex uint64 = 0
+	params := s.heap.dumpParams
 	contentLength := uint64(len(s.Content))
 	children := make([]*Object, 0)
-	for i := params.PtrSize; i < contentLength+params.PtrSize; i += params.PtrSize {
-		buf := bytes.NewReader([]byte(s.Content[lastIndex:i]))
-		binary.Read(buf, binary.LittleEndian, &addr)
-		lastIndex = i
+	for i := uint64(0); i+params.PtrSize <= contentLength; i += params.PtrSize {
+		addr := s.heap.readPtr(s.Content[i:], 0)
 
-		if obj, ok := objectList[addr]; ok {
+		if obj, ok := s.heap.objectList[addr]; ok {
 			children = append(children, obj)
 		}
 	}
 	return children
 }
 
+// Dump parameters. ChHdrSize, Arch and GoExperiment are only in go1.3 dumps, GoArch and
+// GoVersion only in go1.7 dumps.
 type DumpParams struct {
 	BigEndian    bool   // big endian
 	PtrSize      uint64 // pointer size in bytes
@@ -59,6 +57,8 @@ type DumpParams struct {
 	EndAddress   uint64 // ending address of heap
 	Arch         uint64 // thechar = architecture specifier
 	GoExperiment string // GOEXPERIMENT environment variable value
+	GoArch       string // GOARCH of the runtime
+	GoVersion    string // version of the runtime
 	NCPU         uint64 // runtime.ncpu
 }
 
@@ -102,6 +102,11 @@ type Frame struct {
 	Line uint64 // line number
 }
 
+// Formats the frame as "function file:line"
+func (f *Frame) String() string {
+	return fmt.Sprintf("%s %s:%d", f.Name, f.File, f.Line)
+}
+
 type Goroutine struct {
 	Address       uint64 // address of descriptor
 	Top           uint64 // pointer to the top of the stack (the currently running frame, a.k.a. depth 0)
@@ -110,35 +115,102 @@ type Goroutine struct {
 	status        uint64 // status
 	System        bool   // is a Go routine started by the system
 	Background    bool   // is a background Go routine
-	LastWaiting   uint64 // approximate time the goroutine last started waiting (ns since epoc)
+	LastWaiting   uint64 // time the goroutine last started waiting (ns on the runtime's monotonic clock)
 	reasonWaiting string // textual reason why it is waiting
 	CurrentFrame  uint64 // context pointer of currently running frame
 	OSThread      uint64 // address of os thread descriptor (M)
 	DeferRecord   uint64 // top defer record
 	PanicRecord   uint64 // top panic record
+	heap          *HeapFile
 }
 
-func (g *Goroutine) Status() string {
-	switch g.status {
-	case 0:
-		return "idle"
-	case 1:
-		return "runnable"
-	case 3:
-		return "syscall"
-	case 4:
-		return "waiting"
-	}
-	return ""
+func (g *Goroutine) Status() GoroutineStatus {
+	return GoroutineStatus(g.status)
 }
 
+// Returns the reason the goroutine is waiting, or an empty string if it is not waiting
 func (g *Goroutine) ReasonWaiting() string {
-	if g.status == 4 {
+	if g.Status().Base() == StatusWaiting {
 		return g.reasonWaiting
 	}
 	return ""
 }
 
+// Returns the goroutine's stack frames, starting with the top of the stack
+func (g *Goroutine) StackFrames() []*StackFrame {
+	frames := make([]*StackFrame, 0)
+	frame := g.heap.stackFrames[g.Top]
+	for frame != nil {
+		frames = append(frames, frame)
+		frame = g.heap.frameParents[frame.StackPointer]
+	}
+	return frames
+}
+
+// Goroutine status, matching the runtime's G states. The runtime has only ever appended
+// states since go1.3 (which used idle through dead), so the values are the same for every
+// dump version.
+type GoroutineStatus uint64
+
+const (
+	StatusIdle      GoroutineStatus = 0
+	StatusRunnable  GoroutineStatus = 1
+	StatusRunning   GoroutineStatus = 2
+	StatusSyscall   GoroutineStatus = 3
+	StatusWaiting   GoroutineStatus = 4
+	StatusMoribund  GoroutineStatus = 5
+	StatusDead      GoroutineStatus = 6
+	StatusEnqueue   GoroutineStatus = 7
+	StatusCopystack GoroutineStatus = 8
+	StatusPreempted GoroutineStatus = 9
+
+	// Set in addition to one of the above while the GC is scanning the goroutine's stack
+	StatusScan GoroutineStatus = 0x1000
+)
+
+var goroutineStatusNames = map[GoroutineStatus]string{
+	StatusIdle:      "idle",
+	StatusRunnable:  "runnable",
+	StatusRunning:   "running",
+	StatusSyscall:   "syscall",
+	StatusWaiting:   "waiting",
+	StatusMoribund:  "moribund",
+	StatusDead:      "dead",
+	StatusEnqueue:   "enqueue",
+	StatusCopystack: "copystack",
+	StatusPreempted: "preempted",
+}
+
+// Returns the status without the scan bit
+func (s GoroutineStatus) Base() GoroutineStatus {
+	return s &^ StatusScan
+}
+
+func (s GoroutineStatus) IsScan() bool {
+	return s&StatusScan != 0
+}
+
+func (s GoroutineStatus) String() string {
+	name, ok := goroutineStatusNames[s.Base()]
+	if !ok {
+		name = fmt.Sprintf("unknown(%d)", uint64(s.Base()))
+	}
+	if s.IsScan() {
+		return "scan" + name
+	}
+	return name
+}
+
+// Parses a status name as returned by String, without the scan prefix
+func ParseGoroutineStatus(name string) (GoroutineStatus, error) {
+	for status, statusName := range goroutineStatusNames {
+		if statusName == name {
+			return status, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown goroutine status %q", name)
+}
+
 type Object struct {
 	Address     uint64 // address of object
 	TypeAddress uint64 // address of type descriptor (or 0 if unknown)
@@ -146,8 +218,13 @@ type Object struct {
 	Content     string // contents of object
 	Size        int    // size of contents
 	Type        *Type
+	fields      []*Field // pointer fields recorded with the object, in go1.7 dumps
+	heap        *HeapFile
 }
 
+// Kind of go1.7 objects whose type couldn't be inferred
+const kindUnknown = 255
+
 func (o *Object) Kind() string {
 	switch o.kind {
 	case 0:
@@ -158,13 +235,17 @@ func (o *Object) Kind() string {
 		return "channel"
 	case 127:
 		return "conservatively scanned"
+	case kindUnknown:
+		return "unknown"
 	}
 	return ""
 }
 
+// Returns the fields of the object's type, or if its type is unknown the pointer fields
+// recorded with the object
 func (o *Object) Fields() []*Field {
 	if o.Type == nil {
-		return nil
+		return o.fields
 	}
 	return o.Type.FieldList
 }
@@ -178,8 +259,6 @@ func (o *Object) Name() string {
 
 // Returns objects the object points to that are on the heap
 func (o *Object) Children() []*Object {
-	var lastIndex uint64 = 0
-	var addr uint64
 	var size uint64 = uint64(o.Size)
 	children := make([]*Object, 0)
 
@@ -187,22 +266,26 @@ func (o *Object) Children() []*Object {
 		return children
 	}
 
-	for i := dumpParams.PtrSize; i < size+dumpParams.PtrSize; i += dumpParams.PtrSize {
-		buf := bytes.NewReader([]byte(o.Content[lastIndex:i]))
-		binary.Read(buf, binary.LittleEndian, &addr)
-		lastIndex = i
+	for i := uint64(0); i+o.heap.dumpParams.PtrSize <= size; i += o.heap.dumpParams.PtrSize {
+		addr := o.heap.readPtr(o.Content[i:], 0)
 
 		if addr == o.Address {
 			continue // Don't add ourselves
 		}
 
-		if child, ok := objectList[addr]; ok { // object is on the heap
+		if child, ok := o.heap.objectList[addr]; ok { // object is on the heap
 			children = append(children, child)
 		}
 	}
 	return children
 }
 
+type objectsByAddress []*Object
+
+func (o objectsByAddress) Len() int           { return len(o) }
+func (o objectsByAddress) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o objectsByAddress) Less(i, j int) bool { return o[i].Address < o[j].Address }
+
 type Finalizer struct {
 	ObjectAddress uint64 // address of object that has a finalizer
 	FuncValPtr    uint64 // pointer to FuncVal describing the finalizer
@@ -220,6 +303,15 @@ type Profile struct {
 	Frames    []*Frame
 }
 
+// Returns the profile's stack, with each frame formatted by Frame.String
+func (p *Profile) Stack() []string {
+	stack := make([]string, 0, len(p.Frames))
+	for _, frame := range p.Frames {
+		stack = append(stack, frame.String())
+	}
+	return stack
+}
+
 type Root struct {
 	Description string // textual description of where this root came from
 	Pointer     uint64 // root pointer
@@ -235,23 +327,19 @@ type StackFrame struct {
 	ContinuationPC    uint64   // continuation pc for function (where functin may resume, if anywhere)
 	Name              string   // function name
 	FieldList         []*Field // list of kind and offset of pointer-containing fields in this frame
+	heap              *HeapFile
 }
 
 // Returns objects the stack frame points to that are on the heap
 func (s *StackFrame) Objects() []*Object {
-	params := dumpParams
-	var addr uint64
-	var lastIndex uint64 = 0
-
+	params := s.heap.dumpParams
 	contentLength := uint64(len(s.Content))
 	children := make([]*Object, 0)
 
-	for i := params.PtrSize; i < contentLength+params.PtrSize; i += params.PtrSize {
-		buf := bytes.NewReader([]byte(s.Content[lastIndex:i]))
-		binary.Read(buf, binary.LittleEndian, &addr)
-		lastIndex = i
+	for i := uint64(0); i+params.PtrSize <= contentLength; i += params.PtrSize {
+		addr := s.heap.readPtr(s.Content[i:], 0)
 
-		if obj, ok := objectList[addr]; ok {
+		if obj, ok := s.heap.objectList[addr]; ok {
 			children = append(children, obj)
 		}
 	}
@@ -265,3 +353,36 @@ type Type struct {
 	IsPtr     bool     // whether the data field of an interface containing a value of this type is a pointer
 	FieldList []*Field // a list of the kinds and locations of pointer-containing fields in objects of this type
 }
+
+// Reads the i'th pointer sized word from content
+func (h *HeapFile) readPtr(content string, i uint64) uint64 {
+	return h.readUint(content[i*h.dumpParams.PtrSize : (i+1)*h.dumpParams.PtrSize])
+}
+
+// Reads an unsigned integer of up to 8 bytes in the dump's byte order
+func (h *HeapFile) readUint(b string) uint64 {
+	var v uint64
+	if h.dumpParams.BigEndian {
+		for i := 0; i < len(b); i++ {
+			v = v<<8 | uint64(b[i])
+		}
+		return v
+	}
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
+
+// Encodes a pointer sized word in the dump's byte order
+func (h *HeapFile) writePtr(v uint64) string {
+	b := make([]byte, h.dumpParams.PtrSize)
+	for i := range b {
+		shift := 8 * uint(i)
+		if h.dumpParams.BigEndian {
+			shift = 8 * uint(len(b)-1-i)
+		}
+		b[i] = byte(v >> shift)
+	}
+	return string(b)
+}
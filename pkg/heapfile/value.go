@@ -0,0 +1,482 @@
+package heapfile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxValueElements = 32   // array and slice elements decoded per value
+	maxValueBytes    = 64   // bytes shown for untyped data
+	maxStringLength  = 1024 // bytes of string contents kept per value
+)
+
+type ValueKind int
+
+const (
+	ValueRaw       ValueKind = iota // non-pointer data
+	ValuePointer                    // pointer, with the pointed to value in Elem
+	ValueString                     // string header, with the contents in Text
+	ValueSlice                      // slice header, with the elements in Fields
+	ValueInterface                  // interface, with the dynamic value in Elem
+	ValueStruct                     // typed value, with its fields in Fields
+	ValueArray                      // array of typed values, with the elements in Fields
+)
+
+// A decoded value from an object, stack frame or segment
+type Value struct {
+	Kind        ValueKind
+	Offset      uint64   // offset of the value within its parent
+	Type        *Type    // type of the value, element type of arrays and slices, dynamic type of interfaces
+	Address     uint64   // pointer value, or data pointer of strings, slices and interfaces
+	TypeAddress uint64   // type (empty interfaces) or itab (non-empty interfaces) word of interfaces
+	Object      *Object  // heap object Address points into, if any
+	Raw         string   // contents of raw values
+	Text        string   // contents of strings
+	Len         uint64   // length of strings, slices and arrays
+	Cap         uint64   // capacity of slices
+	Empty       bool     // interface is an empty interface
+	Elem        *Value   // pointed to value or dynamic value of interfaces
+	Fields      []*Value // fields of structs, elements of arrays and slices
+	Truncated   bool     // not all of the value was decoded
+	heap        *HeapFile
+}
+
+// Decodes an object's contents using its type's field list. Pointers are followed depth
+// levels deep; strings are always dereferenced.
+func (h *HeapFile) DecodeObject(o *Object, depth int) *Value {
+	h.parse()
+	return h.decodeObject(o, depth)
+}
+
+// Decodes a single pointer containing field of an object. Returns nil if the field
+// does not fit in the object.
+func (h *HeapFile) DecodeField(o *Object, field *Field, depth int) *Value {
+	h.parse()
+	end := field.Offset + h.fieldSize(field.Kind)
+	if end > uint64(len(o.Content)) {
+		return nil
+	}
+	return h.decodeField(field.Kind, o.Content[field.Offset:end], depth)
+}
+
+func (h *HeapFile) decodeObject(o *Object, depth int) *Value {
+	if o.Type == nil || o.Type.Size == 0 || o.kind == 2 {
+		return &Value{heap: h, Kind: ValueRaw, Raw: o.Content, Len: uint64(o.Size)}
+	}
+
+	if o.kind == 1 {
+		return h.decodeArray(o.Type, o.Content, depth)
+	}
+
+	content := o.Content
+	if uint64(len(content)) > o.Type.Size {
+		content = content[:o.Type.Size]
+	}
+	return h.decodeType(o.Type, content, depth)
+}
+
+// Decodes as many values of type t as are in content
+func (h *HeapFile) decodeArray(t *Type, content string, depth int) *Value {
+	n := uint64(len(content)) / t.Size
+	array := &Value{heap: h, Kind: ValueArray, Type: t, Len: n}
+	for i := uint64(0); i < n; i++ {
+		if i == maxValueElements {
+			array.Truncated = true
+			break
+		}
+		elem := h.decodeType(t, content[i*t.Size:(i+1)*t.Size], depth)
+		elem.Offset = i * t.Size
+		array.Fields = append(array.Fields, elem)
+	}
+	return array
+}
+
+// Decodes a value of type t from content
+func (h *HeapFile) decodeType(t *Type, content string, depth int) *Value {
+	if len(t.FieldList) == 0 {
+		return &Value{heap: h, Kind: ValueRaw, Type: t, Raw: content, Len: uint64(len(content))}
+	}
+
+	// Types that are nothing but a string, slice, pointer or interface
+	if len(t.FieldList) == 1 && t.FieldList[0].Offset == 0 &&
+		h.fieldSize(t.FieldList[0].Kind) == uint64(len(content)) {
+		return h.decodeField(t.FieldList[0].Kind, content, depth)
+	}
+
+	value := &Value{heap: h, Kind: ValueStruct, Type: t}
+	var pos uint64
+	for _, field := range t.FieldList {
+		end := field.Offset + h.fieldSize(field.Kind)
+		if end > uint64(len(content)) {
+			break
+		}
+		value.Fields = append(value.Fields, h.decodeRaw(content[pos:field.Offset], pos)...)
+
+		fieldValue := h.decodeField(field.Kind, content[field.Offset:end], depth)
+		fieldValue.Offset = field.Offset
+		value.Fields = append(value.Fields, fieldValue)
+		pos = end
+	}
+	value.Fields = append(value.Fields, h.decodeRaw(content[pos:], pos)...)
+	return value
+}
+
+// Splits non-pointer data into pointer sized raw values
+func (h *HeapFile) decodeRaw(content string, offset uint64) []*Value {
+	values := make([]*Value, 0)
+	for len(content) > 0 {
+		n := int(h.dumpParams.PtrSize)
+		if n > len(content) {
+			n = len(content)
+		}
+		values = append(values, &Value{heap: h, Kind: ValueRaw, Offset: offset, Raw: content[:n], Len: uint64(n)})
+		content = content[n:]
+		offset += uint64(n)
+	}
+	return values
+}
+
+// Returns the size of a field of the given kind
+func (h *HeapFile) fieldSize(kind uint64) uint64 {
+	switch kind {
+	case FieldStr, FieldIface, FieldEface:
+		return 2 * h.dumpParams.PtrSize
+	case FieldSlice:
+		return 3 * h.dumpParams.PtrSize
+	}
+	return h.dumpParams.PtrSize
+}
+
+// Decodes a pointer containing field from content, which is exactly fieldSize(kind) long
+func (h *HeapFile) decodeField(kind uint64, content string, depth int) *Value {
+	switch kind {
+	case FieldStr:
+		return h.decodeString(h.readPtr(content, 0), h.readPtr(content, 1))
+	case FieldSlice:
+		return h.decodeSlice(h.readPtr(content, 0), h.readPtr(content, 1), h.readPtr(content, 2), depth)
+	case FieldIface, FieldEface:
+		return h.decodeInterface(kind == FieldEface, h.readPtr(content, 0), h.readPtr(content, 1), depth)
+	}
+	return h.decodePointer(h.readPtr(content, 0), depth)
+}
+
+func (h *HeapFile) decodePointer(addr uint64, depth int) *Value {
+	value := &Value{heap: h, Kind: ValuePointer, Address: addr, Object: h.objectContaining(addr)}
+	if value.Object != nil && value.Object.Address == addr {
+		if depth > 0 {
+			value.Elem = h.decodeObject(value.Object, depth-1)
+		} else {
+			value.Truncated = true
+		}
+	}
+	return value
+}
+
+func (h *HeapFile) decodeString(addr, length uint64) *Value {
+	value := &Value{heap: h, Kind: ValueString, Address: addr, Len: length}
+	if object := h.objectContaining(addr); object != nil {
+		start := addr - object.Address
+		if length > uint64(object.Size)-start {
+			return value
+		}
+		end := start + length
+		if length > maxStringLength {
+			end = start + maxStringLength
+			value.Truncated = true
+		}
+		value.Object = object
+		value.Text = object.Content[start:end]
+	}
+	return value
+}
+
+func (h *HeapFile) decodeSlice(addr, length, capacity uint64, depth int) *Value {
+	value := &Value{heap: h, Kind: ValueSlice, Address: addr, Len: length, Cap: capacity}
+	object := h.objectContaining(addr)
+	if object == nil {
+		return value
+	}
+	value.Object = object
+	data := object.Content[addr-object.Address:]
+
+	// Untyped backing arrays (e.g. []byte) are shown as raw bytes
+	if object.Type == nil || object.Type.Size == 0 {
+		n := length
+		if n > maxValueBytes {
+			n = maxValueBytes
+			value.Truncated = true
+		}
+		if n > uint64(len(data)) {
+			n = uint64(len(data))
+		}
+		value.Raw = data[:n]
+		return value
+	}
+
+	value.Type = object.Type
+	if depth == 0 {
+		value.Truncated = length > 0
+		return value
+	}
+
+	size := object.Type.Size
+	for i := uint64(0); i < length; i++ {
+		if i == maxValueElements || (i+1)*size > uint64(len(data)) {
+			value.Truncated = true
+			break
+		}
+		elem := h.decodeType(object.Type, data[i*size:(i+1)*size], depth-1)
+		elem.Offset = i * size
+		value.Fields = append(value.Fields, elem)
+	}
+	return value
+}
+
+// Decodes an interface. The dynamic type of empty interfaces is known from their type word,
+// and in go1.7 dumps that of non-empty interfaces from their itab. go1.3 stored values no
+// bigger than a pointer directly in the data word, later runtimes only pointer shaped
+// values; other values are boxed. Interfaces whose dynamic type is unknown aren't followed.
+func (h *HeapFile) decodeInterface(empty bool, typeAddr, data uint64, depth int) *Value {
+	value := &Value{heap: h, Kind: ValueInterface, Empty: empty, TypeAddress: typeAddr, Address: data}
+	if typeAddr == 0 {
+		return value
+	}
+
+	if !empty {
+		typeAddr = h.itabs[typeAddr]
+	}
+	value.Type = h.typeList[typeAddr]
+	if value.Type == nil {
+		return value
+	}
+
+	if h.isDirectIface(value.Type) {
+		word := h.writePtr(data)
+		value.Elem = h.decodeType(value.Type, word[:value.Type.Size], depth)
+		value.Object = value.Elem.Object
+		return value
+	}
+
+	value.Object = h.objectList[data]
+	if value.Object == nil || uint64(value.Object.Size) < value.Type.Size {
+		return value
+	}
+	value.Elem = h.decodeType(value.Type, value.Object.Content[:value.Type.Size], depth)
+	return value
+}
+
+// Returns true if an interface holding a value of type t stores it in its data word
+func (h *HeapFile) isDirectIface(t *Type) bool {
+	if h.header != dumpHeader17 {
+		return t.Size <= h.dumpParams.PtrSize
+	}
+	return t.Size == h.dumpParams.PtrSize && len(t.FieldList) == 1 && t.FieldList[0].Kind == FieldPtr &&
+		t.FieldList[0].Offset == 0
+}
+
+// Returns all of a string's contents. Text is cut off after maxStringLength bytes.
+func (v *Value) FullText() string {
+	if v.Kind != ValueString || v.Object == nil {
+		return v.Text
+	}
+	start := v.Address - v.Object.Address
+	return v.Object.Content[start : start+v.Len]
+}
+
+// Formats the value as a Go-like literal
+func (v *Value) String() string {
+	return v.Format(nil, nil)
+}
+
+// Formats the value as a Go-like literal. If escape is not nil it is applied to all text
+// other than pointers to heap objects, which are passed to link if it is not nil.
+func (v *Value) Format(escape func(string) string, link func(addr uint64, text string) string) string {
+	f := &valueFormatter{heap: v.heap, escape: escape, link: link}
+	f.format(v, 0)
+	return f.buf.String()
+}
+
+type valueFormatter struct {
+	heap   *HeapFile
+	buf    strings.Builder
+	escape func(string) string
+	link   func(addr uint64, text string) string
+}
+
+func (f *valueFormatter) text(format string, a ...interface{}) {
+	s := fmt.Sprintf(format, a...)
+	if f.escape != nil {
+		s = f.escape(s)
+	}
+	f.buf.WriteString(s)
+}
+
+func (f *valueFormatter) pointer(object *Object, format string, a ...interface{}) {
+	s := fmt.Sprintf(format, a...)
+	if f.link == nil {
+		f.buf.WriteString(s)
+		return
+	}
+	if f.escape != nil {
+		s = f.escape(s)
+	}
+	f.buf.WriteString(f.link(object.Address, s))
+}
+
+func (f *valueFormatter) newline(indent int) {
+	f.text("\n%s", strings.Repeat("\t", indent))
+}
+
+func (f *valueFormatter) format(v *Value, indent int) {
+	switch v.Kind {
+	case ValueRaw:
+		f.formatRaw(v)
+	case ValuePointer:
+		f.formatPointer(v, indent)
+	case ValueString:
+		if v.Object == nil {
+			f.text("string(0x%x, len %d)", v.Address, v.Len)
+			return
+		}
+		f.text("%s", strconv.Quote(v.Text))
+		if v.Truncated {
+			f.text("... /* len=%d */", v.Len)
+		}
+	case ValueSlice:
+		f.formatSlice(v, indent)
+	case ValueInterface:
+		f.formatInterface(v, indent)
+	case ValueStruct:
+		f.text("%s{", v.Type.Name)
+		f.formatFields(v, indent)
+		f.text("}")
+	case ValueArray:
+		f.text("[%d]%s{", v.Len, v.Type.Name)
+		f.formatFields(v, indent)
+		f.text("}")
+	}
+}
+
+func (f *valueFormatter) formatRaw(v *Value) {
+	// Whole values that fit in a word are shown as integers
+	if v.Type != nil && len(v.Raw) > 0 && uint64(len(v.Raw)) <= f.heap.dumpParams.PtrSize {
+		f.text("%s(%d)", v.Type.Name, f.heap.readUint(v.Raw))
+		return
+	}
+	if v.Type == nil && uint64(len(v.Raw)) == f.heap.dumpParams.PtrSize {
+		f.text("0x%x", f.heap.readUint(v.Raw))
+		return
+	}
+
+	raw := v.Raw
+	if len(raw) > maxValueBytes {
+		raw = raw[:maxValueBytes]
+	}
+	name := "byte"
+	if v.Type != nil {
+		name = v.Type.Name
+	}
+	f.text("[%d]%s{", len(v.Raw), name)
+	for i, b := range []byte(raw) {
+		if i > 0 {
+			f.text(", ")
+		}
+		f.text("0x%02x", b)
+	}
+	if len(raw) < len(v.Raw) {
+		f.text(", ...")
+	}
+	f.text("}")
+}
+
+func (f *valueFormatter) formatPointer(v *Value, indent int) {
+	switch {
+	case v.Address == 0:
+		f.text("nil")
+	case v.Object == nil:
+		f.text("0x%x", v.Address)
+	case v.Elem != nil:
+		f.pointer(v.Object, "&")
+		f.format(v.Elem, indent)
+	case v.Address != v.Object.Address:
+		f.pointer(v.Object, "(*%s)(0x%x+0x%x)", v.Object.Name(), v.Object.Address, v.Address-v.Object.Address)
+	default:
+		f.pointer(v.Object, "(*%s)(0x%x)", v.Object.Name(), v.Address)
+	}
+}
+
+func (f *valueFormatter) formatSlice(v *Value, indent int) {
+	if v.Address == 0 {
+		f.text("nil")
+		return
+	}
+
+	switch {
+	case v.Object == nil:
+		f.text("[]?(0x%x)", v.Address)
+	case v.Type == nil:
+		f.pointer(v.Object, "[]byte")
+		f.text("{")
+		for i, b := range []byte(v.Raw) {
+			if i > 0 {
+				f.text(", ")
+			}
+			f.text("0x%02x", b)
+		}
+		if v.Truncated {
+			f.text(", ...")
+		}
+		f.text("}")
+	default:
+		f.pointer(v.Object, "[]%s", v.Type.Name)
+		f.text("{")
+		f.formatFields(v, indent)
+		f.text("}")
+	}
+	f.text(" /* len=%d cap=%d */", v.Len, v.Cap)
+}
+
+func (f *valueFormatter) formatInterface(v *Value, indent int) {
+	if v.TypeAddress == 0 {
+		f.text("nil")
+		return
+	}
+
+	if v.Empty {
+		f.text("interface{}(")
+	} else {
+		f.text("interface{...}(")
+	}
+	if v.Elem != nil {
+		f.format(v.Elem, indent)
+	} else if v.Type != nil {
+		f.text("(%s)(0x%x)", v.Type.Name, v.Address)
+	} else if v.Empty {
+		f.text("(type 0x%x)(0x%x)", v.TypeAddress, v.Address)
+	} else {
+		f.text("(itab 0x%x)(0x%x)", v.TypeAddress, v.Address)
+	}
+	f.text(")")
+}
+
+func (f *valueFormatter) formatFields(v *Value, indent int) {
+	if len(v.Fields) == 0 {
+		if v.Truncated {
+			f.text("...")
+		}
+		return
+	}
+	for _, field := range v.Fields {
+		f.newline(indent + 1)
+		f.text("0x%x: ", field.Offset)
+		f.format(field, indent+1)
+		f.text(",")
+	}
+	if v.Truncated {
+		f.newline(indent + 1)
+		f.text("...")
+	}
+	f.newline(indent)
+}
@@ -0,0 +1,63 @@
+package heapfile
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDecodeInterface13(t *testing.T) {
+	pair := testWord(1) + testWord(2)
+	h := openTestDump(t, dumpHeader13, testParams13,
+		testRecord{3, 0x100, 8, "int", 0, []*Field{}},
+		testRecord{3, 0x200, 16, "main.pair", 1, []*Field{}},
+		testRecord{1, 0xc000, 0x200, 0, pair},
+	)
+	defer os.Remove(h.path)
+
+	// Values that fit in a word are stored in it, larger values are boxed
+	if s := h.decodeInterface(true, 0x100, 42, 1).String(); s != "interface{}(int(42))" {
+		t.Errorf("word sized value decoded as %s", s)
+	}
+	value := h.decodeInterface(true, 0x200, 0xc000, 1)
+	if value.Elem == nil || value.Elem.Raw != pair || value.Object == nil || value.Object.Address != 0xc000 {
+		t.Errorf("boxed value decoded as %s", value)
+	}
+
+	// go1.3 dumps don't record the types of itabs
+	if s := h.decodeInterface(true, 0x300, 0xc000, 1).String(); s != "interface{}((type 0x300)(0xc000))" {
+		t.Errorf("value of an unknown type decoded as %s", s)
+	}
+	if s := h.decodeInterface(false, 0x400, 0xc000, 1).String(); s != "interface{...}((itab 0x400)(0xc000))" {
+		t.Errorf("non-empty interface decoded as %s", s)
+	}
+}
+
+func TestDecodeInterface17(t *testing.T) {
+	h := openTestDump(t, dumpHeader17, testParams17,
+		testRecord{3, 0x100, 8, "int", 1},
+		testRecord{3, 0x180, 8, "*main.T", 1},
+		testRecord{8, 0x500, 0x180},
+		testRecord{1, 0xc000, testWord(42), []*Field{}},
+		testRecord{1, 0xc010, testWord(0) + testWord(0), []*Field{}},
+	)
+	defer os.Remove(h.path)
+	h.typeList[0x180].FieldList = []*Field{{FieldPtr, 0, ""}} // as the binary's DWARF gives it
+
+	// Only pointer shaped values are stored in the data word
+	if s := h.decodeInterface(true, 0x100, 0xc000, 1).String(); s != "interface{}(int(42))" {
+		t.Errorf("boxed word sized value decoded as %s", s)
+	}
+	value := h.decodeInterface(true, 0x180, 0xc010, 0)
+	if value.Elem == nil || value.Elem.Kind != ValuePointer || value.Elem.Address != 0xc010 {
+		t.Errorf("pointer decoded as %s", value)
+	}
+
+	// The dynamic type of non-empty interfaces is that of their itab
+	value = h.decodeInterface(false, 0x500, 0xc010, 0)
+	if value.Type == nil || value.Type.Name != "*main.T" || value.Object == nil || value.Object.Address != 0xc010 {
+		t.Errorf("non-empty interface decoded as %s", value)
+	}
+	if s := h.decodeInterface(false, 0x600, 0xc010, 0).String(); s != "interface{...}((itab 0x600)(0xc010))" {
+		t.Errorf("non-empty interface with an unknown itab decoded as %s", s)
+	}
+}
@@ -0,0 +1,409 @@
+package heapquery
+
+import (
+	"fmt"
+	"github.com/rubyist/gohat/pkg/heapfile"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("%q at %d", t.text, t.pos)
+}
+
+var keywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "AND": true, "OR": true, "NOT": true, "LIKE": true,
+	"ORDER": true, "BY": true, "ASC": true, "DESC": true, "LIMIT": true, "TRUE": true, "FALSE": true, "NULL": true,
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "=~", "<", ">", "="}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// Splits a query into tokens. Identifiers may contain dots, so attributes like
+// referrers.count are a single token. Strings are quoted with " or '.
+func lex(input string) ([]token, error) {
+	tokens := make([]token, 0)
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(input[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{tokenString, input[i+1 : i+1+end], i})
+			i += end + 2
+
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(input) && isIdentChar(input[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, input[start:i], start})
+
+		case isIdentChar(c):
+			start := i
+			for i < len(input) && isIdentChar(input[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, input[start:i], start})
+
+		case strings.IndexByte("(),[]*", c) >= 0:
+			tokens = append(tokens, token{tokenPunct, string(c), i})
+			i++
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(input[i:], op) {
+					tokens = append(tokens, token{tokenOperator, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(input)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// Returns true if the next token is the keyword, case insensitively
+func (p *parser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && strings.ToUpper(t.text) == keyword
+}
+
+// Consumes the keyword if it is next
+func (p *parser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return fmt.Errorf("expected %s, found %s", keyword, p.peek())
+	}
+	return nil
+}
+
+func (p *parser) acceptPunct(punct string) bool {
+	t := p.peek()
+	if t.kind == tokenPunct && t.text == punct {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectPunct(punct string) error {
+	if !p.acceptPunct(punct) {
+		return fmt.Errorf("expected %q, found %s", punct, p.peek())
+	}
+	return nil
+}
+
+// Parses a query:
+//
+//	SELECT <columns> FROM <type pattern> [WHERE <condition>] [ORDER BY <expr> [ASC|DESC], ...] [LIMIT <n>]
+//
+// Columns are * or a comma separated list of expressions. The type pattern is * or a quoted
+// glob or /regex/ matched against type names; unquoted type names are matched exactly.
+func Parse(query string) (*Query, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	q := &Query{Text: query}
+
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	if p.acceptPunct("*") {
+		for _, name := range defaultColumns {
+			q.Columns = append(q.Columns, name)
+			q.columns = append(q.columns, &attribute{name: name})
+		}
+	} else {
+		for {
+			start := p.peek().pos
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			q.Columns = append(q.Columns, strings.TrimSpace(query[start:p.peek().pos]))
+			q.columns = append(q.columns, e)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	source := p.next()
+	switch {
+	case source.kind == tokenPunct && source.text == "*":
+	case source.kind == tokenString:
+		if q.source, err = heapfile.CompileNamePattern(source.text); err != nil {
+			return nil, err
+		}
+	case source.kind == tokenIdent && !keywords[strings.ToUpper(source.text)]:
+		q.source = regexp.MustCompile("^" + regexp.QuoteMeta(source.text) + "$")
+	default:
+		return nil, fmt.Errorf("expected a type pattern, found %s", source)
+	}
+
+	if p.acceptKeyword("WHERE") {
+		if q.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			key := orderKey{expr: e}
+			if p.acceptKeyword("DESC") {
+				key.descending = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			q.order = append(q.order, key)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		t := p.next()
+		limit, err := strconv.Atoi(t.text)
+		if t.kind != tokenNumber || err != nil || limit < 0 {
+			return nil, fmt.Errorf("expected a limit, found %s", t)
+		}
+		q.Limit = limit
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s", t)
+	}
+	return q, nil
+}
+
+// expr := and {OR and}
+func (p *parser) parseExpr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{or: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+// and := not {AND not}
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{left: left, right: right}
+	}
+	return left, nil
+}
+
+// not := NOT not | comparison
+func (p *parser) parseNot() (expr, error) {
+	if p.acceptKeyword("NOT") {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &negation{e}, nil
+	}
+	return p.parseComparison()
+}
+
+// comparison := operand [operator operand]
+func (p *parser) parseComparison() (expr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	op := ""
+	if t := p.peek(); t.kind == tokenOperator {
+		op = p.next().text
+		if op == "=" {
+			op = "=="
+		}
+	} else if p.acceptKeyword("LIKE") {
+		op = "LIKE"
+	} else {
+		return left, nil
+	}
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	c := &comparison{op: op, left: left, right: right}
+
+	// Compile patterns once when they are literals
+	if lit, ok := right.(*literal); ok && (op == "=~" || op == "LIKE") {
+		pattern, ok := lit.value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a string pattern", op)
+		}
+		if c.pattern, err = compilePattern(op, pattern); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func compilePattern(op, pattern string) (*regexp.Regexp, error) {
+	if op == "LIKE" {
+		return heapfile.CompileNamePattern(pattern)
+	}
+	return regexp.Compile(pattern)
+}
+
+// operand := ( expr ) | string | number | TRUE | FALSE | NULL | attribute | field[offset]
+func (p *parser) parseOperand() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenPunct:
+		if t.text == "(" {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(")"); err != nil {
+				return nil, err
+			}
+			return e, nil
+		}
+
+	case tokenString:
+		return &literal{t.text}, nil
+
+	case tokenNumber:
+		n, err := parseNumber(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t)
+		}
+		return &literal{n}, nil
+
+	case tokenIdent:
+		switch strings.ToUpper(t.text) {
+		case "TRUE":
+			return &literal{true}, nil
+		case "FALSE":
+			return &literal{false}, nil
+		case "NULL":
+			return &literal{nil}, nil
+		}
+		if keywords[strings.ToUpper(t.text)] {
+			break
+		}
+
+		name := strings.ToLower(t.text)
+		if name == "field" {
+			if err := p.expectPunct("["); err != nil {
+				return nil, err
+			}
+			offset := p.next()
+			n, err := parseNumber(offset.text)
+			if offset.kind != tokenNumber || err != nil {
+				return nil, fmt.Errorf("expected a field offset, found %s", offset)
+			}
+			if err := p.expectPunct("]"); err != nil {
+				return nil, err
+			}
+			return &attribute{name: name, offset: n}, nil
+		}
+		if _, ok := attributes[name]; !ok {
+			return nil, fmt.Errorf("unknown attribute %s", t)
+		}
+		return &attribute{name: name}, nil
+	}
+	return nil, fmt.Errorf("unexpected %s", t)
+}
+
+// Parses decimal and 0x prefixed hex numbers
+func parseNumber(text string) (uint64, error) {
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+		return strconv.ParseUint(text[2:], 16, 64)
+	}
+	return strconv.ParseUint(text, 10, 64)
+}
@@ -0,0 +1,134 @@
+package heapquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseColumnsSourceAndLimit(t *testing.T) {
+	tests := []struct {
+		query   string
+		columns []string
+		source  string // the source pattern, empty for all types
+		order   int
+		limit   int
+	}{
+		{`SELECT * FROM *`, defaultColumns, "", 0, 0},
+		{`select addr, size from string`, []string{"addr", "size"}, "^string$", 0, 0},
+		{`SELECT addr FROM "map.bucket*" LIMIT 5`, []string{"addr"}, "^map\\.bucket.*$", 0, 5},
+		{`SELECT size > 10, field[0x8] FROM '/^main\./'`, []string{"size > 10", "field[0x8]"}, "^main\\.", 0, 0},
+		{`SELECT addr FROM * ORDER BY size DESC, addr LIMIT 0`, []string{"addr"}, "", 2, 0},
+	}
+
+	for _, test := range tests {
+		q, err := Parse(test.query)
+		if err != nil {
+			t.Errorf("Parse(%q): %s", test.query, err)
+			continue
+		}
+		if strings.Join(q.Columns, "|") != strings.Join(test.columns, "|") {
+			t.Errorf("Parse(%q) columns %q, expected %q", test.query, q.Columns, test.columns)
+		}
+		source := ""
+		if q.source != nil {
+			source = q.source.String()
+		}
+		if source != test.source {
+			t.Errorf("Parse(%q) source %q, expected %q", test.query, source, test.source)
+		}
+		if len(q.order) != test.order {
+			t.Errorf("Parse(%q) has %d order keys, expected %d", test.query, len(q.order), test.order)
+		}
+		if q.Limit != test.limit {
+			t.Errorf("Parse(%q) limit %d, expected %d", test.query, q.Limit, test.limit)
+		}
+	}
+}
+
+// Conditions of literals evaluate without a heap, so the result shows how they were grouped
+func TestParsePrecedence(t *testing.T) {
+	tests := []struct {
+		where    string
+		expected interface{}
+	}{
+		{`TRUE OR FALSE AND FALSE`, true},
+		{`(TRUE OR FALSE) AND FALSE`, false},
+		{`FALSE AND FALSE OR TRUE`, true},
+		{`NOT FALSE AND FALSE`, false},
+		{`NOT (FALSE AND FALSE)`, true},
+		{`NOT NOT TRUE`, true},
+		{`not true or true`, true},
+		{`1 < 2 AND 2 < 1 OR 3 == 3`, true},
+		{`1 < 2 AND (2 < 1 OR 3 != 3)`, false},
+		{`NULL AND TRUE`, false},
+		{`NULL OR TRUE`, true},
+		{`NOT NULL`, true},
+		{`0x10 = 16`, true},
+	}
+
+	for _, test := range tests {
+		q, err := Parse("SELECT * FROM * WHERE " + test.where)
+		if err != nil {
+			t.Errorf("Parse(%q): %s", test.where, err)
+			continue
+		}
+		v, err := q.where.eval(nil, nil)
+		if err != nil {
+			t.Errorf("%s: %s", test.where, err)
+			continue
+		}
+		if v != test.expected {
+			t.Errorf("%s is %v, expected %v", test.where, v, test.expected)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		err   string
+	}{
+		{``, "expected SELECT"},
+		{`SELECT addr`, "expected FROM"},
+		{`SELECT FROM *`, "unexpected"},
+		{`SELECT addr FROM`, "expected a type pattern"},
+		{`SELECT addr FROM WHERE`, "expected a type pattern"},
+		{`SELECT bogus FROM *`, "unknown attribute"},
+		{`SELECT addr FROM * WHERE`, "unexpected end of query"},
+		{`SELECT addr FROM * WHERE (size > 1`, `expected ")"`},
+		{`SELECT addr FROM * WHERE type = "string`, "unterminated string"},
+		{`SELECT addr FROM * WHERE size ! 1`, "unexpected"},
+		{`SELECT addr FROM * WHERE type =~ "("`, "missing closing )"},
+		{`SELECT addr FROM * WHERE type LIKE 1`, "needs a string pattern"},
+		{`SELECT addr FROM * WHERE size > 12z`, "invalid number"},
+		{`SELECT field[size] FROM *`, "expected a field offset"},
+		{`SELECT addr FROM * LIMIT`, "expected a limit"},
+		{`SELECT addr FROM * LIMIT -1`, "unexpected"},
+		{`SELECT addr FROM * LIMIT many`, "expected a limit"},
+		{`SELECT addr FROM * LIMIT 1 2`, "unexpected"},
+		{`SELECT addr FROM * ORDER size`, "expected BY"},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.query)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, expected an error containing %q", test.query, test.err)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Parse(%q) error %q, expected it to contain %q", test.query, err, test.err)
+		}
+	}
+}
+
+// Addresses are printed so that they can be used in a query
+func TestParseFormattedAddress(t *testing.T) {
+	q, err := Parse("SELECT * FROM * WHERE addr == " + FormatValue(Address(0xc2081d4000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	right := q.where.(*comparison).right.(*literal)
+	if right.value != uint64(0xc2081d4000) {
+		t.Errorf("formatted address parsed as %v", right.value)
+	}
+}
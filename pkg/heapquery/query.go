@@ -0,0 +1,479 @@
+// Package heapquery evaluates SQL like queries over the objects of a heap dump:
+//
+//	SELECT addr, size FROM "map.bucket*" WHERE size > 1024 AND referrers.count == 1 ORDER BY retained DESC LIMIT 20
+//
+// Expressions are built from attributes of the object, literals, the comparison operators
+// ==, !=, <, <=, >, >=, =~ (regular expression) and LIKE (glob), and AND, OR and NOT.
+// Attributes are:
+//
+//	addr, address    address of the object
+//	type, name       type name
+//	kind             regular, array, channel, conservatively scanned or unknown
+//	size             size in bytes
+//	retained         bytes retained by the object, see HeapFile.RetainedSize
+//	dominator        address of the immediate dominator, or null
+//	reachable        whether a GC root reaches the object
+//	path             shortest path from a GC root, or null if unreachable
+//	root             the GC root of that path
+//	depth            number of objects on that path
+//	referrers        objects that point to the object
+//	referrers.count  number of referrers
+//	children         objects the object points to
+//	children.count   number of children
+//	fields.count     number of pointer fields of the type
+//	field[offset]    value of the field at the offset: string contents, the address held by
+//	                 pointers, slices and interfaces, or the pointer sized word there
+package heapquery
+
+import (
+	"fmt"
+	"github.com/rubyist/gohat/pkg/heapfile"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// An address, which compares as a number and is formatted in 0x prefixed hex, so it can be
+// pasted back into a query
+type Address uint64
+
+func (a Address) String() string {
+	return fmt.Sprintf("0x%x", uint64(a))
+}
+
+// A parsed query
+type Query struct {
+	Text    string
+	Columns []string // the text of each column's expression
+	Limit   int      // 0 for no limit
+
+	columns []expr
+	source  *regexp.Regexp // nil for all types
+	where   expr
+	order   []orderKey
+}
+
+type orderKey struct {
+	expr       expr
+	descending bool
+}
+
+// The rows a query selected, in order
+type Result struct {
+	Columns []string
+	Rows    []*Row
+}
+
+type Row struct {
+	Object *heapfile.Object
+	Values []interface{} // a value for each column: nil, bool, uint64, Address, string or []*heapfile.Object
+
+	keys []interface{}
+}
+
+var defaultColumns = []string{"addr", "type", "kind", "size"}
+
+var attributes = map[string]func(h *heapfile.HeapFile, o *heapfile.Object) interface{}{
+	"addr":    func(h *heapfile.HeapFile, o *heapfile.Object) interface{} { return Address(o.Address) },
+	"address": func(h *heapfile.HeapFile, o *heapfile.Object) interface{} { return Address(o.Address) },
+	"type":    func(h *heapfile.HeapFile, o *heapfile.Object) interface{} { return o.Name() },
+	"name":    func(h *heapfile.HeapFile, o *heapfile.Object) interface{} { return o.Name() },
+	"kind":    func(h *heapfile.HeapFile, o *heapfile.Object) interface{} { return o.Kind() },
+	"size":    func(h *heapfile.HeapFile, o *heapfile.Object) interface{} { return uint64(o.Size) },
+	"retained": func(h *heapfile.HeapFile, o *heapfile.Object) interface{} {
+		return h.RetainedSize(o)
+	},
+	"dominator": func(h *heapfile.HeapFile, o *heapfile.Object) interface{} {
+		if d := h.Dominator(o); d != nil {
+			return Address(d.Address)
+		}
+		return nil
+	},
+	"reachable": func(h *heapfile.HeapFile, o *heapfile.Object) interface{} {
+		return h.RootPath(o) != nil
+	},
+	"path": func(h *heapfile.HeapFile, o *heapfile.Object) interface{} {
+		if p := h.RootPath(o); p != nil {
+			return p.String()
+		}
+		return nil
+	},
+	"root": func(h *heapfile.HeapFile, o *heapfile.Object) interface{} {
+		if p := h.RootPath(o); p != nil {
+			return p.Root
+		}
+		return nil
+	},
+	"depth": func(h *heapfile.HeapFile, o *heapfile.Object) interface{} {
+		if p := h.RootPath(o); p != nil {
+			return uint64(len(p.Objects))
+		}
+		return nil
+	},
+	"referrers": func(h *heapfile.HeapFile, o *heapfile.Object) interface{} {
+		return h.Referrers(o)
+	},
+	"referrers.count": func(h *heapfile.HeapFile, o *heapfile.Object) interface{} {
+		return uint64(len(h.Referrers(o)))
+	},
+	"children": func(h *heapfile.HeapFile, o *heapfile.Object) interface{} {
+		return o.Children()
+	},
+	"children.count": func(h *heapfile.HeapFile, o *heapfile.Object) interface{} {
+		return uint64(len(o.Children()))
+	},
+	"fields.count": func(h *heapfile.HeapFile, o *heapfile.Object) interface{} {
+		return uint64(len(o.Fields()))
+	},
+}
+
+// Parses and runs a query
+func Run(h *heapfile.HeapFile, query string) (*Result, error) {
+	q, err := Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return q.Run(h)
+}
+
+// Runs the query against the heap file. Objects are visited in address order, so rows that
+// the ORDER BY keys don't distinguish stay in address order.
+func (q *Query) Run(h *heapfile.HeapFile) (*Result, error) {
+	objects := h.Objects()
+	sort.Sort(objectsByAddress(objects))
+
+	rows := make([]*Row, 0)
+	for _, object := range objects {
+		if q.source != nil && !q.source.MatchString(object.Name()) {
+			continue
+		}
+		if q.where != nil {
+			v, err := q.where.eval(h, object)
+			if err != nil {
+				return nil, err
+			}
+			match, ok := v.(bool)
+			if !ok && v != nil {
+				return nil, fmt.Errorf("WHERE condition is a %s, not a boolean", typeName(v))
+			}
+			if !match {
+				continue
+			}
+		}
+
+		row := &Row{Object: object}
+		for _, key := range q.order {
+			v, err := key.expr.eval(h, object)
+			if err != nil {
+				return nil, err
+			}
+			row.keys = append(row.keys, v)
+		}
+		rows = append(rows, row)
+	}
+
+	if len(q.order) > 0 {
+		sort.Stable(rowsByKeys{rows, q.order})
+	}
+	if q.Limit > 0 && len(rows) > q.Limit {
+		rows = rows[:q.Limit]
+	}
+
+	for _, row := range rows {
+		for _, column := range q.columns {
+			v, err := column.eval(h, row.Object)
+			if err != nil {
+				return nil, err
+			}
+			row.Values = append(row.Values, v)
+		}
+	}
+	return &Result{Columns: q.Columns, Rows: rows}, nil
+}
+
+// Formats a value from a row. Lists of objects are formatted as their addresses.
+func FormatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []*heapfile.Object:
+		addresses := make([]string, 0, len(v))
+		for _, object := range v {
+			addresses = append(addresses, Address(object.Address).String())
+		}
+		return strings.Join(addresses, " ")
+	}
+	return fmt.Sprint(v)
+}
+
+type expr interface {
+	eval(h *heapfile.HeapFile, o *heapfile.Object) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (l *literal) eval(h *heapfile.HeapFile, o *heapfile.Object) (interface{}, error) {
+	return l.value, nil
+}
+
+type attribute struct {
+	name   string
+	offset uint64 // of field[offset]
+}
+
+func (a *attribute) eval(h *heapfile.HeapFile, o *heapfile.Object) (interface{}, error) {
+	if a.name != "field" {
+		return attributes[a.name](h, o), nil
+	}
+
+	for _, field := range o.Fields() {
+		if field.Offset != a.offset {
+			continue
+		}
+		value := h.DecodeField(o, field, 0)
+		if value == nil {
+			return nil, nil
+		}
+		switch value.Kind {
+		case heapfile.ValueString:
+			return value.FullText(), nil
+		case heapfile.ValuePointer, heapfile.ValueSlice, heapfile.ValueInterface:
+			return Address(value.Address), nil
+		}
+		return nil, nil
+	}
+
+	params := h.DumpParams()
+	if a.offset+params.PtrSize > uint64(len(o.Content)) {
+		return nil, nil
+	}
+	word := o.Content[a.offset : a.offset+params.PtrSize]
+	n := uint64(0)
+	for i := range word {
+		if params.BigEndian {
+			n = n<<8 | uint64(word[i])
+		} else {
+			n = n<<8 | uint64(word[len(word)-1-i])
+		}
+	}
+	return n, nil
+}
+
+type logical struct {
+	or          bool
+	left, right expr
+}
+
+func (l *logical) eval(h *heapfile.HeapFile, o *heapfile.Object) (interface{}, error) {
+	left, err := evalBool(l.left, h, o)
+	if err != nil {
+		return nil, err
+	}
+	if left == l.or {
+		return left, nil
+	}
+	return evalBool(l.right, h, o)
+}
+
+type negation struct {
+	expr expr
+}
+
+func (n *negation) eval(h *heapfile.HeapFile, o *heapfile.Object) (interface{}, error) {
+	v, err := evalBool(n.expr, h, o)
+	return !v, err
+}
+
+// Evaluates a condition. Null is false.
+func evalBool(e expr, h *heapfile.HeapFile, o *heapfile.Object) (bool, error) {
+	v, err := e.eval(h, o)
+	if err != nil || v == nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected a boolean, got a %s", typeName(v))
+	}
+	return b, nil
+}
+
+type comparison struct {
+	op          string
+	left, right expr
+	pattern     *regexp.Regexp // compiled right hand side of =~ and LIKE, if it is a literal
+}
+
+// Comparisons with null are false, except for == and !=
+func (c *comparison) eval(h *heapfile.HeapFile, o *heapfile.Object) (interface{}, error) {
+	left, err := c.left.eval(h, o)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.right.eval(h, o)
+	if err != nil {
+		return nil, err
+	}
+	left, right = normalize(left), normalize(right)
+
+	switch c.op {
+	case "==", "!=":
+		if isList(left) || isList(right) {
+			return nil, fmt.Errorf("can't compare lists with %s, use .count", c.op)
+		}
+		return (left == right) == (c.op == "=="), nil
+
+	case "=~", "LIKE":
+		if left == nil || right == nil {
+			return false, nil
+		}
+		text, ok := left.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a string, got a %s", c.op, typeName(left))
+		}
+		pattern := c.pattern
+		if pattern == nil {
+			s, ok := right.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s needs a string pattern, got a %s", c.op, typeName(right))
+			}
+			if pattern, err = compilePattern(c.op, s); err != nil {
+				return nil, err
+			}
+		}
+		return pattern.MatchString(text), nil
+	}
+
+	if left == nil || right == nil {
+		return false, nil
+	}
+	var cmp int
+	switch l := left.(type) {
+	case uint64:
+		r, ok := right.(uint64)
+		if !ok {
+			return nil, fmt.Errorf("can't compare a number with a %s", typeName(right))
+		}
+		cmp = compareNumbers(l, r)
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("can't compare a string with a %s", typeName(right))
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return nil, fmt.Errorf("can't order a %s with %s", typeName(left), c.op)
+	}
+
+	switch c.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+// Addresses compare as plain numbers
+func normalize(v interface{}) interface{} {
+	if a, ok := v.(Address); ok {
+		return uint64(a)
+	}
+	return v
+}
+
+func isList(v interface{}) bool {
+	_, ok := v.([]*heapfile.Object)
+	return ok
+}
+
+func compareNumbers(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case uint64, Address:
+		return "number"
+	case string:
+		return "string"
+	}
+	return "list"
+}
+
+// Orders values of any type: null, then booleans, numbers, strings and lists by length
+func orderValues(a, b interface{}) int {
+	a, b = normalize(a), normalize(b)
+	rank := func(v interface{}) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case bool:
+			return 1
+		case uint64:
+			return 2
+		case string:
+			return 3
+		}
+		return 4
+	}
+	if rank(a) != rank(b) {
+		return rank(a) - rank(b)
+	}
+	switch a := a.(type) {
+	case bool:
+		if a == b.(bool) {
+			return 0
+		} else if a {
+			return 1
+		}
+		return -1
+	case uint64:
+		return compareNumbers(a, b.(uint64))
+	case string:
+		return strings.Compare(a, b.(string))
+	case []*heapfile.Object:
+		return len(a) - len(b.([]*heapfile.Object))
+	}
+	return 0
+}
+
+type rowsByKeys struct {
+	rows  []*Row
+	order []orderKey
+}
+
+func (r rowsByKeys) Len() int      { return len(r.rows) }
+func (r rowsByKeys) Swap(i, j int) { r.rows[i], r.rows[j] = r.rows[j], r.rows[i] }
+func (r rowsByKeys) Less(i, j int) bool {
+	for k, key := range r.order {
+		cmp := orderValues(r.rows[i].keys[k], r.rows[j].keys[k])
+		if cmp == 0 {
+			continue
+		}
+		if key.descending {
+			return cmp > 0
+		}
+		return cmp < 0
+	}
+	return false
+}
+
+type objectsByAddress []*heapfile.Object
+
+func (o objectsByAddress) Len() int           { return len(o) }
+func (o objectsByAddress) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o objectsByAddress) Less(i, j int) bool { return o[i].Address < o[j].Address }
@@ -0,0 +1,121 @@
+package heapquery
+
+import (
+	"github.com/rubyist/gohat/pkg/heapfile"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestComparisonEval(t *testing.T) {
+	objects := []*heapfile.Object{{Address: 1}, {Address: 2}}
+	tests := []struct {
+		op          string
+		left, right interface{}
+		expected    interface{}
+	}{
+		{"==", uint64(1), uint64(1), true},
+		{"==", uint64(1), uint64(2), false},
+		{"!=", uint64(1), uint64(2), true},
+		{"==", Address(16), uint64(16), true},
+		{"<", Address(15), Address(16), true},
+		{"==", "a", "a", true},
+		{"==", "a", uint64(1), false},
+		{"!=", "a", uint64(1), true},
+		{"==", true, true, true},
+		{"<", uint64(1), uint64(2), true},
+		{"<", uint64(2), uint64(2), false},
+		{"<=", uint64(2), uint64(2), true},
+		{">", uint64(3), uint64(2), true},
+		{">=", uint64(1), uint64(2), false},
+		{"<", "abc", "abd", true},
+		{">", "b", "abc", true},
+
+		// Null equals only null, and ordering or matching it is false
+		{"==", nil, nil, true},
+		{"!=", nil, nil, false},
+		{"==", nil, uint64(0), false},
+		{"!=", uint64(0), nil, true},
+		{"<", nil, uint64(1), false},
+		{">=", uint64(1), nil, false},
+		{"=~", nil, "x", false},
+		{"LIKE", "x", nil, false},
+
+		{"=~", "map.bucket[string]int", `^map\.`, true},
+		{"=~", "string", `^map\.`, false},
+		{"LIKE", "map.bucket[string]int", "map.bucket*", true},
+		{"LIKE", "map.bucket[string]int", "map.hdr*", false},
+	}
+
+	for _, test := range tests {
+		c := &comparison{op: test.op, left: &literal{test.left}, right: &literal{test.right}}
+		v, err := c.eval(nil, nil)
+		if err != nil {
+			t.Errorf("%v %s %v: %s", test.left, test.op, test.right, err)
+			continue
+		}
+		if v != test.expected {
+			t.Errorf("%v %s %v is %v, expected %v", test.left, test.op, test.right, v, test.expected)
+		}
+	}
+
+	errors := []struct {
+		op          string
+		left, right interface{}
+		err         string
+	}{
+		{"==", objects, uint64(2), "can't compare lists"},
+		{"!=", uint64(2), objects, "can't compare lists"},
+		{"<", uint64(1), "a", "can't compare a number with a string"},
+		{"<", "a", Address(1), "can't compare a string with a number"},
+		{"<", true, false, "can't order a boolean"},
+		{">", objects, uint64(1), "can't order a list"},
+		{"=~", uint64(1), "x", "needs a string, got a number"},
+		{"LIKE", "x", uint64(1), "needs a string pattern, got a number"},
+		{"=~", "x", "(", "missing closing )"},
+	}
+
+	for _, test := range errors {
+		c := &comparison{op: test.op, left: &literal{test.left}, right: &literal{test.right}}
+		_, err := c.eval(nil, nil)
+		if err == nil {
+			t.Errorf("%v %s %v succeeded, expected an error containing %q", test.left, test.op, test.right, test.err)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v %s %v error %q, expected it to contain %q", test.left, test.op, test.right, err, test.err)
+		}
+	}
+}
+
+// A pattern compiled at parse time is used instead of the right hand side
+func TestComparisonEvalCompiledPattern(t *testing.T) {
+	c := &comparison{op: "=~", left: &literal{"string"}, right: &literal{nil}, pattern: regexp.MustCompile("^str")}
+	if v, err := c.eval(nil, nil); err != nil || v != false {
+		t.Errorf("null pattern matched %v, %v", v, err)
+	}
+	c.right = &literal{"ignored"}
+	if v, err := c.eval(nil, nil); err != nil || v != true {
+		t.Errorf("compiled pattern matched %v, %v", v, err)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, ""},
+		{true, "true"},
+		{uint64(42), "42"},
+		{Address(0xc208000100), "0xc208000100"},
+		{"text", "text"},
+		{[]*heapfile.Object{{Address: 0x10}, {Address: 0x20}}, "0x10 0x20"},
+	}
+
+	for _, test := range tests {
+		if s := FormatValue(test.value); s != test.expected {
+			t.Errorf("FormatValue(%#v) is %q, expected %q", test.value, s, test.expected)
+		}
+	}
+}